@@ -7,6 +7,8 @@ import (
 	"encoding/json"
 	"fmt"
 	"net/http"
+	"strconv"
+	"time"
 
 	"github.com/gdotgordon/locator-demo/analyzer/receiver"
 	"github.com/gdotgordon/locator-demo/analyzer/types"
@@ -17,12 +19,18 @@ type Api struct {
 	receiver *receiver.Receiver
 }
 
-func Init(ctx context.Context, r *mux.Router,
-	receiver *receiver.Receiver) error {
+// Init sets up the HTTP API bindings and handlers.  Each request has
+// the timeout as its deadline, if it is set, or a shorter one asked for
+// with the X-Request-Timeout header.
+func Init(ctx context.Context, r *mux.Router, receiver *receiver.Receiver,
+	timeout time.Duration) error {
 	ap := Api{receiver: receiver}
-	r.HandleFunc("/v1/status", wrapContext(ctx, ap.getStatus)).Methods("GET")
-	r.HandleFunc("/v1/statistics", wrapContext(ctx, ap.getStatistics)).Methods("GET")
-	r.HandleFunc("/v1/reset", wrapContext(ctx, ap.reset)).Methods("GET")
+	wrap := func(hf http.HandlerFunc) http.HandlerFunc {
+		return wrapContext(ctx, timeout, hf)
+	}
+	r.HandleFunc("/v1/status", wrap(ap.getStatus)).Methods("GET")
+	r.HandleFunc("/v1/statistics", wrap(ap.getStatistics)).Methods("GET")
+	r.HandleFunc("/v1/reset", wrap(ap.reset)).Methods("GET")
 	return nil
 }
 
@@ -79,17 +87,80 @@ func (a *Api) reset(w http.ResponseWriter, r *http.Request) {
 	w.WriteHeader(http.StatusOK)
 }
 
-func wrapContext(ctx context.Context, hf http.HandlerFunc) http.HandlerFunc {
-	cw := contextWrapper{ctx: ctx, hf: hf}
+// TimeoutHeader is the request header with which a client can ask for a
+// shorter deadline than the server's, as a duration such as "2.5s", or a
+// whole number of seconds.
+const TimeoutHeader = "X-Request-Timeout"
+
+// wrapContext runs the handler with a context derived from the request's,
+// which also ends when the server is shutting down, and has the request
+// deadline.
+func wrapContext(ctx context.Context, timeout time.Duration,
+	hf http.HandlerFunc) http.HandlerFunc {
+	cw := contextWrapper{ctx: ctx, timeout: timeout, hf: hf}
 	return cw.wrap
 }
 
 type contextWrapper struct {
-	ctx context.Context
-	hf  http.HandlerFunc
+	ctx     context.Context
+	timeout time.Duration
+	hf      http.HandlerFunc
 }
 
 func (cw *contextWrapper) wrap(w http.ResponseWriter, r *http.Request) {
-	rc := r.WithContext(cw.ctx)
-	cw.hf(w, rc)
+	timeout := cw.timeout
+	if h := r.Header.Get(TimeoutHeader); h != "" {
+		d, err := parseTimeout(h)
+		if err != nil {
+			w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+			w.WriteHeader(http.StatusBadRequest)
+			msg := fmt.Sprintf("{\"status\": \"bad request, error: %s\"}", err)
+			w.Write([]byte(msg))
+			return
+		}
+		if timeout <= 0 || d < timeout {
+			timeout = d
+		}
+	}
+	ctx, cancel := mergeContext(cw.ctx, r.Context(), timeout)
+	defer cancel()
+	cw.hf(w, r.WithContext(ctx))
+}
+
+// mergeContext returns a context derived from the request context, with
+// the timeout if there is one, that is cancelled when the server context
+// is.  The cancel function must be called to release it.
+func mergeContext(server, req context.Context,
+	timeout time.Duration) (context.Context, context.CancelFunc) {
+	var ctx context.Context
+	var cancel context.CancelFunc
+	if timeout > 0 {
+		ctx, cancel = context.WithTimeout(req, timeout)
+	} else {
+		ctx, cancel = context.WithCancel(req)
+	}
+	go func() {
+		select {
+		case <-server.Done():
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+	return ctx, cancel
+}
+
+// parseTimeout parses the value of the timeout header.
+func parseTimeout(v string) (time.Duration, error) {
+	d, err := time.ParseDuration(v)
+	if err != nil {
+		secs, serr := strconv.ParseUint(v, 10, 32)
+		if serr != nil {
+			return 0, fmt.Errorf("Invalid %s '%s'", TimeoutHeader, v)
+		}
+		d = time.Duration(secs) * time.Second
+	}
+	if d <= 0 {
+		return 0, fmt.Errorf("%s must be positive, got '%s'", TimeoutHeader, v)
+	}
+	return d, nil
 }
@@ -23,7 +23,9 @@ import (
 )
 
 var (
-	numWorkers = flag.Int("numWorkrs", 3, "Number of reciever workers")
+	numWorkers     = flag.Int("numWorkrs", 3, "Number of reciever workers")
+	requestTimeout = flag.Duration("requestTimeout", 9*time.Second,
+		"Deadline of each API request, less than the server write timeout")
 )
 
 func main() {
@@ -39,8 +41,8 @@ func main() {
 	// We'll propagate the context with cancel thorughout the program,
 	// such as http clients, server methods we implement, and other
 	// loops using channels.
-	ctx, _ := context.WithCancel(context.Background())
-	//defer cancel()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
 
 	// Create and run the receiver, which is the analyzer of the Redis events
 	// from Redis actions of the other code, such as the Locator.
@@ -55,7 +57,7 @@ func main() {
 	// set up the routes, as we don't need to know the details in the
 	// main program.
 	r := mux.NewRouter()
-	if err = api.Init(ctx, r, receiver); err != nil {
+	if err = api.Init(ctx, r, receiver, *requestTimeout); err != nil {
 		fmt.Fprintf(os.Stderr, "Error setting api: '%s'\n", err)
 		os.Exit(1)
 	}
@@ -75,8 +77,10 @@ func main() {
 		}
 	}()
 
-	// Block until we shutdown.
+	// Block until we shutdown, then cancel the requests that are still
+	// running after the grace period, and stop the receiver.
 	waitForShutdown(ctx, srv)
+	cancel()
 }
 
 func NewClient() (*redis.Client, error) {
@@ -24,15 +24,60 @@ import (
 
 // Receiver stores some statistics from the received events.
 type Receiver struct {
-	cli        *redis.Client
+	cli         *redis.Client
+	lists       lister
+	mu          sync.Mutex
+	groups      map[string]map[string]*opCounts
+	transitions map[string]map[string]int64
+	byName      map[string]map[string]int64
+}
+
+// lister reads the latency lists.  It is the Redis client, except in
+// tests.
+type lister interface {
+	LRange(key string, start, stop int64) *redis.StringSliceCmd
+}
+
+// tallied are the events counted by name alone, keyed by the first part
+// of the key after the prefix, such as "rejected" for
+// "locator:rejected:rate".  Each gives the field of the statistics its
+// counts are reported in.
+var tallied = map[string]func(sr *types.StatsResponse) *map[string]int64{
+	"rejected": func(sr *types.StatsResponse) *map[string]int64 { return &sr.Rejected },
+	"errors":   func(sr *types.StatsResponse) *map[string]int64 { return &sr.ErrorsByClass },
+	"jobs":     func(sr *types.StatsResponse) *map[string]int64 { return &sr.Jobs },
+	"geofence": func(sr *types.StatsResponse) *map[string]int64 { return &sr.Geofences },
+}
+
+// Groups of counters, by operation, by provider, by cache tier and by
+// type of workflow step.
+const (
+	opGroup       = "op"
+	providerGroup = "provider"
+	cacheGroup    = "cache"
+	stepGroup     = "step"
+)
+
+// opCounts are the event counts for one operation, such as a lookup, for
+// one geocoding provider, or for one cache tier.
+type opCounts struct {
 	latencyCnt int64
 	succCnt    int64
 	errCnt     int64
+	thrCnt     int64
+	canCnt     int64
+	hitCnt     int64
+	missCnt    int64
+	evictCnt   int64
+	coalCnt    int64
 }
 
 // New creates a new event receiver for keyspace events.
 func New(cli *redis.Client) (*Receiver, error) {
-	return &Receiver{cli: cli}, nil
+	return &Receiver{cli: cli, lists: cli,
+		groups:      make(map[string]map[string]*opCounts),
+		transitions: make(map[string]map[string]int64),
+		byName:      make(map[string]map[string]int64)}, nil
 }
 
 // Run is the main event loop processor.  For each event read, it
@@ -67,16 +112,7 @@ func (r *Receiver) Run(ctx context.Context, numWorkers int) {
 				ndx := strings.Index(msg.Channel, ":")
 				key := msg.Channel[ndx+1:]
 				log.Println("key: ", key)
-				if strings.HasSuffix(msg.Channel, ":latency") &&
-					msg.Payload == "lpush" {
-					atomic.AddInt64(&r.latencyCnt, 1)
-				} else if strings.HasSuffix(msg.Channel, ":success") &&
-					msg.Payload == "incrby" {
-					atomic.AddInt64(&r.succCnt, 1)
-				} else if strings.HasSuffix(msg.Channel, ":error") &&
-					msg.Payload == "incrby" {
-					atomic.AddInt64(&r.errCnt, 1)
-				}
+				r.handleEvent(key, msg.Payload)
 			}
 		}
 	}
@@ -95,19 +131,257 @@ func (r *Receiver) Run(ctx context.Context, numWorkers int) {
 	}()
 }
 
+// handleEvent counts a keyspace event for a key.  The keys look like
+// "locator:success" for lookups, "locator:reverse:success" for other
+// operations, "locator:provider:census:success" for providers,
+// "locator:cache:redis:hit" for caches, and "locator:step:geocode:error"
+// for workflow steps.  Circuit breaker transitions, such as
+// "locator:breaker:census:open", and the events in the tallied table,
+// such as "locator:rejected:rate", are counted separately.
+func (r *Receiver) handleEvent(key, payload string) {
+	parts := strings.Split(strings.TrimPrefix(key, types.KeyPrefix), ":")
+	if len(parts) == 2 {
+		if _, ok := tallied[parts[0]]; ok {
+			if payload == "incrby" {
+				r.tally(parts[0], parts[1])
+			}
+			return
+		}
+	}
+	if len(parts) == 3 && parts[0] == "breaker" {
+		if payload == "incrby" {
+			r.addTransition(parts[1], parts[2])
+		}
+		return
+	}
+	var oc *opCounts
+	var stat string
+	switch {
+	case len(parts) == 1:
+		oc, stat = r.counts(opGroup, types.OpLookup), parts[0]
+	case len(parts) == 2:
+		oc, stat = r.counts(opGroup, parts[0]), parts[1]
+	case len(parts) == 3 && parts[0] == "provider":
+		oc, stat = r.counts(providerGroup, parts[1]), parts[2]
+	case len(parts) == 3 && parts[0] == "cache":
+		oc, stat = r.counts(cacheGroup, parts[1]), parts[2]
+	case len(parts) == 3 && parts[0] == "step":
+		oc, stat = r.counts(stepGroup, parts[1]), parts[2]
+	default:
+		return
+	}
+
+	switch {
+	case stat == "latency" && payload == "lpush":
+		atomic.AddInt64(&oc.latencyCnt, 1)
+	case stat == "success" && payload == "incrby":
+		atomic.AddInt64(&oc.succCnt, 1)
+	case stat == "error" && payload == "incrby":
+		atomic.AddInt64(&oc.errCnt, 1)
+	case stat == "throttled" && payload == "incrby":
+		atomic.AddInt64(&oc.thrCnt, 1)
+	case stat == "canceled" && payload == "incrby":
+		atomic.AddInt64(&oc.canCnt, 1)
+	case stat == "hit" && payload == "incrby":
+		atomic.AddInt64(&oc.hitCnt, 1)
+	case stat == "miss" && payload == "incrby":
+		atomic.AddInt64(&oc.missCnt, 1)
+	case stat == "eviction" && payload == "incrby":
+		atomic.AddInt64(&oc.evictCnt, 1)
+	case stat == "coalesced" && payload == "incrby":
+		atomic.AddInt64(&oc.coalCnt, 1)
+	}
+}
+
+// counts returns the counters for the name in the group, creating them
+// the first time the name is seen.
+func (r *Receiver) counts(group, name string) *opCounts {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	m, ok := r.groups[group]
+	if !ok {
+		m = make(map[string]*opCounts)
+		r.groups[group] = m
+	}
+	oc, ok := m[name]
+	if !ok {
+		oc = &opCounts{}
+		m[name] = oc
+	}
+	return oc
+}
+
+// addTransition counts a transition of the breaker to the state.
+func (r *Receiver) addTransition(breaker, state string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	m, ok := r.transitions[breaker]
+	if !ok {
+		m = make(map[string]int64)
+		r.transitions[breaker] = m
+	}
+	m[state]++
+}
+
+// breakers returns a copy of the breaker transition counts.
+func (r *Receiver) breakers() map[string]map[string]int64 {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if len(r.transitions) == 0 {
+		return nil
+	}
+	out := make(map[string]map[string]int64)
+	for b, m := range r.transitions {
+		out[b] = make(map[string]int64)
+		for state, n := range m {
+			out[b][state] = n
+		}
+	}
+	return out
+}
+
+// tally counts an event of the kind for the name, such as the reason an
+// API request was rejected, the class of an error, or the state of a job.
+func (r *Receiver) tally(kind, name string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	m, ok := r.byName[kind]
+	if !ok {
+		m = make(map[string]int64)
+		r.byName[kind] = m
+	}
+	m[name]++
+}
+
+// tallies returns a copy of the counts of the kind.
+func (r *Receiver) tallies(kind string) map[string]int64 {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	m := r.byName[kind]
+	if len(m) == 0 {
+		return nil
+	}
+	out := make(map[string]int64)
+	for name, n := range m {
+		out[name] = n
+	}
+	return out
+}
+
+// names returns the names seen so far in the group.
+func (r *Receiver) names(group string) []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var names []string
+	for name := range r.groups[group] {
+		names = append(names, name)
+	}
+	return names
+}
+
 // GetStats returns a statisitcs object with the accumulated local data.
 // For the latency we compute an average of the 100 (or max) latest
 // events.
 func (r *Receiver) GetStats() (*types.StatsResponse, error) {
-	res, err := r.cli.LRange(types.LatencyKey, 0, 100).Result()
+	lk, err := r.opStats(opGroup, types.OpLookup,
+		types.StatsKey(types.OpLookup, "latency"))
 	if err != nil {
 		return nil, err
 	}
+	sr := types.StatsResponse{Success: lk.Success, Error: lk.Error,
+		LatencyCount: lk.LatencyCount, Latency: lk.Latency,
+		Throttled: lk.Throttled, Canceled: lk.Canceled}
+	for _, op := range r.names(opGroup) {
+		if op == types.OpLookup {
+			continue
+		}
+		ost, err := r.opStats(opGroup, op, types.StatsKey(op, "latency"))
+		if err != nil {
+			return nil, err
+		}
+		if sr.Operations == nil {
+			sr.Operations = make(map[string]types.OperationStats)
+		}
+		sr.Operations[op] = ost
+	}
+	for _, p := range r.names(providerGroup) {
+		pst, err := r.opStats(providerGroup, p, types.ProviderKey(p, "latency"))
+		if err != nil {
+			return nil, err
+		}
+		if sr.Providers == nil {
+			sr.Providers = make(map[string]types.OperationStats)
+		}
+		sr.Providers[p] = pst
+	}
+	for _, st := range r.names(stepGroup) {
+		sst, err := r.opStats(stepGroup, st, types.StepKey(st, "latency"))
+		if err != nil {
+			return nil, err
+		}
+		if sr.Steps == nil {
+			sr.Steps = make(map[string]types.OperationStats)
+		}
+		sr.Steps[st] = sst
+	}
+	for _, tier := range r.names(cacheGroup) {
+		if sr.Cache == nil {
+			sr.Cache = make(map[string]types.CacheStats)
+		}
+		sr.Cache[tier] = r.cacheStats(tier)
+	}
+	sr.Breakers = r.breakers()
+	for kind, field := range tallied {
+		*field(&sr) = r.tallies(kind)
+	}
+	return &sr, nil
+}
+
+// cacheStats gathers the counts of a cache tier.
+func (r *Receiver) cacheStats(tier string) types.CacheStats {
+	oc := r.counts(cacheGroup, tier)
+	cs := types.CacheStats{
+		Hits:      atomic.LoadInt64(&oc.hitCnt),
+		Misses:    atomic.LoadInt64(&oc.missCnt),
+		Evictions: atomic.LoadInt64(&oc.evictCnt),
+		Coalesced: atomic.LoadInt64(&oc.coalCnt),
+	}
+	if total := cs.Hits + cs.Misses; total > 0 {
+		cs.HitRatio = float64(cs.Hits) / float64(total)
+	}
+	return cs
+}
+
+// opStats gathers the counts and average latency for one operation,
+// provider or type of step, given the key of its latency list.
+func (r *Receiver) opStats(group, name,
+	latencyKey string) (types.OperationStats, error) {
+	avg, err := r.averageLatency(latencyKey)
+	if err != nil {
+		return types.OperationStats{}, err
+	}
+	oc := r.counts(group, name)
+	return types.OperationStats{
+		Success:      atomic.LoadInt64(&oc.succCnt),
+		Error:        atomic.LoadInt64(&oc.errCnt),
+		LatencyCount: atomic.LoadInt64(&oc.latencyCnt),
+		Latency:      avg.String(),
+		Throttled:    atomic.LoadInt64(&oc.thrCnt),
+		Canceled:     atomic.LoadInt64(&oc.canCnt),
+	}, nil
+}
+
+// averageLatency averages the latest 100 (or max) latencies in the list.
+func (r *Receiver) averageLatency(key string) (time.Duration, error) {
+	res, err := r.lists.LRange(key, 0, 100).Result()
+	if err != nil {
+		return 0, err
+	}
 	var sum int64
 	for _, v := range res {
 		f, err := strconv.ParseInt(v, 10, 64)
 		if err != nil {
-			return nil, err
+			return 0, err
 		}
 		sum += f
 	}
@@ -115,15 +389,15 @@ func (r *Receiver) GetStats() (*types.StatsResponse, error) {
 	if len(res) > 0 {
 		avg = float64(sum) / float64(len(res))
 	}
-	davg := time.Duration(int64(math.Round(avg)))
-	return &types.StatsResponse{Success: r.succCnt, Error: r.errCnt,
-		LatencyCount: r.latencyCnt, Latency: davg.String()}, nil
+	return time.Duration(int64(math.Round(avg))), nil
 }
 
 // Resets the counter and db.  Mostly for testing.
 func (r *Receiver) Reset() error {
-	r.latencyCnt = 0
-	r.succCnt = 0
-	r.errCnt = 0
+	r.mu.Lock()
+	r.groups = make(map[string]map[string]*opCounts)
+	r.transitions = make(map[string]map[string]int64)
+	r.byName = make(map[string]map[string]int64)
+	r.mu.Unlock()
 	return r.cli.FlushDB().Err()
 }
@@ -0,0 +1,131 @@
+package receiver
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/gdotgordon/locator-demo/analyzer/types"
+	"github.com/go-redis/redis"
+)
+
+// memLists has the latency lists in memory, so we can get the stats
+// without a redis.
+type memLists map[string][]string
+
+func (ml memLists) LRange(key string, start, stop int64) *redis.StringSliceCmd {
+	return redis.NewStringSliceResult(ml[key], nil)
+}
+
+func TestHandleEvent(t *testing.T) {
+	r, err := New(nil)
+	if err != nil {
+		t.Fatalf("Got unexpected error: %v", err)
+	}
+	r.lists = memLists{
+		types.LatencyKey: {"100", "300"},
+		types.StatsKey(types.OpReverse, "latency"): {"50"},
+		types.ProviderKey("census", "latency"):     {"20"},
+		types.StepKey("geocode", "latency"):        {"10", "20"},
+	}
+
+	for _, ev := range []struct {
+		key     string
+		payload string
+	}{
+		{key: types.LatencyKey, payload: "lpush"},
+		{key: types.LatencyKey, payload: "lpush"},
+		{key: types.SuccessKey, payload: "incrby"},
+		{key: types.SuccessKey, payload: "incrby"},
+		{key: types.ErrorKey, payload: "incrby"},
+		{key: types.StatsKey(types.OpLookup, "throttled"), payload: "incrby"},
+		{key: types.StatsKey(types.OpReverse, "latency"), payload: "lpush"},
+		{key: types.StatsKey(types.OpReverse, "success"), payload: "incrby"},
+		{key: types.StatsKey(types.OpReverse, "canceled"), payload: "incrby"},
+		{key: types.ProviderKey("census", "latency"), payload: "lpush"},
+		{key: types.ProviderKey("census", "error"), payload: "incrby"},
+		{key: types.StepKey("geocode", "success"), payload: "incrby"},
+		{key: types.CacheKey("redis", "hit"), payload: "incrby"},
+		{key: types.CacheKey("redis", "hit"), payload: "incrby"},
+		{key: types.CacheKey("redis", "hit"), payload: "incrby"},
+		{key: types.CacheKey("redis", "miss"), payload: "incrby"},
+		{key: types.CacheKey("memory", "eviction"), payload: "incrby"},
+		{key: types.CacheKey("memory", "coalesced"), payload: "incrby"},
+		{key: types.BreakerKey("census", "open"), payload: "incrby"},
+		{key: types.BreakerKey("census", "half_open"), payload: "incrby"},
+		{key: types.BreakerKey("census", "open"), payload: "incrby"},
+		{key: types.RejectedKey("rate"), payload: "incrby"},
+		{key: types.RejectedKey("inflight"), payload: "incrby"},
+		{key: types.ErrorClassKey("upstream_timeout"), payload: "incrby"},
+		{key: types.ErrorClassKey("upstream_timeout"), payload: "incrby"},
+		{key: types.JobKey("queued"), payload: "incrby"},
+		{key: types.GeofenceKey("downtown"), payload: "incrby"},
+		// Events other than the counts and pushes, and keys of unknown
+		// shape, are ignored.
+		{key: types.SuccessKey, payload: "del"},
+		{key: types.RejectedKey("rate"), payload: "expire"},
+		{key: types.BreakerKey("census", "closed"), payload: "set"},
+		{key: types.KeyPrefix + "a:b:c:d", payload: "incrby"},
+	} {
+		r.handleEvent(ev.key, ev.payload)
+	}
+
+	sr, err := r.GetStats()
+	if err != nil {
+		t.Fatalf("Got unexpected error: %v", err)
+	}
+	if sr.Success != 2 || sr.Error != 1 || sr.Throttled != 1 ||
+		sr.LatencyCount != 2 || sr.Latency != (200*time.Nanosecond).String() {
+		t.Fatalf("Unexpected lookup stats: %+v", sr)
+	}
+	for _, test := range []struct {
+		name string
+		got  interface{}
+		exp  interface{}
+	}{
+		{name: "operations", got: sr.Operations,
+			exp: map[string]types.OperationStats{
+				types.OpReverse: {Success: 1, LatencyCount: 1, Latency: "50ns",
+					Canceled: 1}}},
+		{name: "providers", got: sr.Providers,
+			exp: map[string]types.OperationStats{
+				"census": {Error: 1, LatencyCount: 1, Latency: "20ns"}}},
+		{name: "steps", got: sr.Steps,
+			exp: map[string]types.OperationStats{
+				"geocode": {Success: 1, Latency: "15ns"}}},
+		{name: "cache", got: sr.Cache,
+			exp: map[string]types.CacheStats{
+				"redis":  {Hits: 3, Misses: 1, HitRatio: 0.75},
+				"memory": {Evictions: 1, Coalesced: 1}}},
+		{name: "breakers", got: sr.Breakers,
+			exp: map[string]map[string]int64{
+				"census": {"open": 2, "half_open": 1}}},
+		{name: "rejected", got: sr.Rejected,
+			exp: map[string]int64{"rate": 1, "inflight": 1}},
+		{name: "errors by class", got: sr.ErrorsByClass,
+			exp: map[string]int64{"upstream_timeout": 2}},
+		{name: "jobs", got: sr.Jobs, exp: map[string]int64{"queued": 1}},
+		{name: "geofences", got: sr.Geofences,
+			exp: map[string]int64{"downtown": 1}},
+	} {
+		if fmt.Sprint(test.got) != fmt.Sprint(test.exp) {
+			t.Fatalf("Expected %s %v, got %v", test.name, test.exp, test.got)
+		}
+	}
+}
+
+func TestGetStatsEmpty(t *testing.T) {
+	r, err := New(nil)
+	if err != nil {
+		t.Fatalf("Got unexpected error: %v", err)
+	}
+	r.lists = memLists{}
+	sr, err := r.GetStats()
+	if err != nil {
+		t.Fatalf("Got unexpected error: %v", err)
+	}
+	if sr.Operations != nil || sr.Breakers != nil || sr.Rejected != nil ||
+		sr.ErrorsByClass != nil || sr.Jobs != nil || sr.Geofences != nil {
+		t.Fatalf("Expected no grouped stats, got %+v", sr)
+	}
+}
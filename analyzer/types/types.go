@@ -9,15 +9,125 @@ const (
 	ErrorKey   = KeyPrefix + "error"
 )
 
+// Operations the locator labels its stats with.  Lookups use the
+// unlabelled keys above, while others have the operation name after the
+// prefix, for example "locator:reverse:success".
+const (
+	OpLookup  = "lookup"
+	OpReverse = "reverse"
+	OpBatch   = "batch"
+)
+
+// StatsKey returns the key for the named stat of an operation.
+func StatsKey(op, stat string) string {
+	if op == OpLookup {
+		return KeyPrefix + stat
+	}
+	return KeyPrefix + op + ":" + stat
+}
+
+// ProviderKey returns the key for the named stat of a geocoding provider,
+// for example "locator:provider:census:success".
+func ProviderKey(provider, stat string) string {
+	return KeyPrefix + "provider:" + provider + ":" + stat
+}
+
+// CacheKey returns the key for the named stat ("hit", "miss", "eviction"
+// or "coalesced") of a cache tier, for example "locator:cache:redis:hit".
+func CacheKey(tier, stat string) string {
+	return KeyPrefix + "cache:" + tier + ":" + stat
+}
+
+// BreakerKey returns the key counting the transitions of a circuit
+// breaker to the state, for example "locator:breaker:census:open".
+func BreakerKey(breaker, state string) string {
+	return KeyPrefix + "breaker:" + breaker + ":" + state
+}
+
+// RejectedKey returns the key counting the API requests rejected for the
+// reason, for example "locator:rejected:rate".
+func RejectedKey(reason string) string {
+	return KeyPrefix + "rejected:" + reason
+}
+
+// ErrorClassKey returns the key counting the failures of the class, for
+// example "locator:errors:upstream_timeout".
+func ErrorClassKey(class string) string {
+	return KeyPrefix + "errors:" + class
+}
+
+// JobKey returns the key counting the job lifecycle events of the state,
+// for example "locator:jobs:succeeded".
+func JobKey(state string) string {
+	return KeyPrefix + "jobs:" + state
+}
+
+// GeofenceKey returns the key counting the lookups found in the geofence,
+// for example "locator:geofence:downtown".
+func GeofenceKey(name string) string {
+	return KeyPrefix + "geofence:" + name
+}
+
+// StepKey returns the key for the named stat of a type of workflow step,
+// for example "locator:step:geocode:success".
+func StepKey(stepType, stat string) string {
+	return KeyPrefix + "step:" + stepType + ":" + stat
+}
+
 // StatusResponse is the response to astatus check (ping).
 type StatusResponse struct {
 	Status string `json:"status"`
 }
 
 // StatsResponse is the response to a call to get accumulated statistics.
+// The top level fields are for address lookups, and the statistics for
+// any other operations are keyed by the operation name.  The provider
+// statistics cover all the operations of each geocoding provider, and
+// the cache statistics are keyed by the cache tier.  Breakers has the
+// number of transitions of each circuit breaker to each state, Rejected
+// the number of API requests rejected by the client limits, by reason,
+// ErrorsByClass the failures of every operation by error class, and Jobs
+// the asynchronous jobs that were queued, started, succeeded and failed.
+// Steps has the statistics of the workflow steps, by type of step, and
+// Geofences the number of lookups found in each geofence.
 type StatsResponse struct {
+	Success       int64                       `json:"success"`
+	Error         int64                       `json:"failure"`
+	LatencyCount  int64                       `json:"latency_events"`
+	Latency       string                      `json:"latency"`
+	Throttled     int64                       `json:"throttled,omitempty"`
+	Canceled      int64                       `json:"canceled,omitempty"`
+	Operations    map[string]OperationStats   `json:"operations,omitempty"`
+	Providers     map[string]OperationStats   `json:"providers,omitempty"`
+	Cache         map[string]CacheStats       `json:"cache,omitempty"`
+	Breakers      map[string]map[string]int64 `json:"breakers,omitempty"`
+	Rejected      map[string]int64            `json:"rejected,omitempty"`
+	ErrorsByClass map[string]int64            `json:"errors_by_class,omitempty"`
+	Jobs          map[string]int64            `json:"jobs,omitempty"`
+	Steps         map[string]OperationStats   `json:"steps,omitempty"`
+	Geofences     map[string]int64            `json:"geofences,omitempty"`
+}
+
+// OperationStats are the accumulated statistics for one operation, one
+// provider, or one type of workflow step.  Canceled counts the calls cut
+// short by the client going away, or the locator shutting down, which are
+// neither successes nor failures.
+type OperationStats struct {
 	Success      int64  `json:"success"`
 	Error        int64  `json:"failure"`
 	LatencyCount int64  `json:"latency_events"`
 	Latency      string `json:"latency"`
+	Throttled    int64  `json:"throttled,omitempty"`
+	Canceled     int64  `json:"canceled,omitempty"`
+}
+
+// CacheStats are the accumulated hits and misses of one cache tier,
+// along with the responses evicted to make room, and the lookups that
+// shared another's call to the provider.
+type CacheStats struct {
+	Hits      int64   `json:"hits"`
+	Misses    int64   `json:"misses"`
+	HitRatio  float64 `json:"hit_ratio"`
+	Evictions int64   `json:"evictions"`
+	Coalesced int64   `json:"coalesced"`
 }
@@ -7,26 +7,150 @@ import (
 	"encoding/json"
 	"fmt"
 	"net/http"
+	"strconv"
 	"strings"
+	"time"
 
+	"github.com/gdotgordon/locator-demo/locator/geoerr"
+	"github.com/gdotgordon/locator-demo/locator/geofence"
 	"github.com/gdotgordon/locator-demo/locator/geolocator"
+	"github.com/gdotgordon/locator-demo/locator/jobs"
 	"github.com/gdotgordon/locator-demo/locator/store"
 	"github.com/gdotgordon/locator-demo/locator/types"
+	"github.com/gdotgordon/locator-demo/locator/workflow"
 	"github.com/gorilla/mux"
 )
 
+// Config holds the tunable settings for the API.
+type Config struct {
+	// BatchWorkers is the number of goroutines that service a single
+	// batch lookup.
+	BatchWorkers int
+
+	// MaxBatchSize is the largest number of addresses accepted in a
+	// single batch lookup.
+	MaxBatchSize int
+
+	// Geo is the configuration for the geolocator.
+	Geo geolocator.Config
+
+	// Clients, if set, limits the requests of each client.
+	Clients ClientLimiter
+
+	// Locator, if set, is used instead of creating one from Geo, so that
+	// it can be shared with the job workers.
+	Locator geolocator.Geolocator
+
+	// Jobs, if set, is the queue of asynchronous lookup jobs.
+	Jobs jobs.Queue
+
+	// MaxJobSize is the largest number of addresses accepted in a job.
+	MaxJobSize int
+
+	// MaxMatrixSize is the largest number of origins times destinations
+	// accepted in a distance matrix.
+	MaxMatrixSize int
+
+	// Workflows, if set, is the queue of workflows.
+	Workflows workflow.Queue
+
+	// WebhookHosts are the hosts that workflow webhook steps may call.
+	WebhookHosts []string
+
+	// Geofences, if set, are the geofences the located addresses are
+	// checked against.
+	Geofences geofence.Store
+
+	// RequestTimeout, if set, is the deadline of each request.  A client
+	// may ask for a shorter one with the X-Request-Timeout header.
+	RequestTimeout time.Duration
+
+	// BulkWorkers is the number of goroutines that look up the rows of a
+	// bulk upload, and BulkWindow the number of rows read ahead of the
+	// one being written, which bounds the memory used by an upload.
+	BulkWorkers int
+	BulkWindow  int
+
+	// MaxBulkSize is the largest bulk upload accepted, in bytes.
+	MaxBulkSize int64
+
+	// BulkTimeout, if set, is the deadline of each bulk upload.
+	BulkTimeout time.Duration
+}
+
 type api struct {
-	loc   geolocator.Geolocator
-	store store.Store
+	loc    geolocator.Geolocator
+	store  store.Store
+	cfg    Config
+	fences *geofence.Matcher
+}
+
+// newAPI creates the API, and the geolocator if there isn't a shared one.
+func newAPI(store store.Store, cfg Config) (*api, error) {
+	loc := cfg.Locator
+	if loc == nil {
+		var err error
+		if loc, err = geolocator.New(cfg.Geo, store); err != nil {
+			return nil, err
+		}
+	}
+	ap := &api{cfg: cfg, loc: loc, store: store}
+	if cfg.Geofences != nil {
+		ap.fences = geofence.NewMatcher(cfg.Geofences)
+	}
+	return ap, nil
 }
 
 // Init sets up the HTTP API bindings and handlers
-func Init(ctx context.Context, r *mux.Router, store store.Store) error {
-	ap := api{}
-	r.HandleFunc("/v1/status", wrapContext(ctx, ap.getStatus)).Methods("GET")
-	r.HandleFunc("/v1/lookup", wrapContext(ctx, ap.lookup)).Methods("POST")
-	ap.loc = geolocator.New(30, store)
-	ap.store = store
+func Init(ctx context.Context, r *mux.Router, store store.Store,
+	cfg Config) error {
+	ap, err := newAPI(store, cfg)
+	if err != nil {
+		return err
+	}
+	wrap := func(hf http.HandlerFunc) http.HandlerFunc {
+		return wrapContext(ctx, cfg.RequestTimeout, hf)
+	}
+	r.HandleFunc("/v1/status", wrap(ap.getStatus)).Methods("GET")
+	r.HandleFunc("/v1/lookup", wrap(ap.limit(ap.lookup))).Methods("POST")
+	r.HandleFunc("/v2/lookup", wrap(ap.limit(ap.lookupV2))).Methods("POST")
+	r.HandleFunc("/v1/lookup/batch", wrap(ap.limit(ap.lookupBatch))).Methods("POST")
+	r.HandleFunc("/v1/reverse", wrap(ap.limit(ap.reverse))).Methods("POST")
+	r.HandleFunc("/v1/distance", wrap(ap.limit(ap.distance))).Methods("POST")
+	r.HandleFunc("/v1/distance/matrix", wrap(ap.limit(ap.distanceMatrix))).Methods("POST")
+	if cfg.Jobs != nil {
+		r.HandleFunc("/v1/jobs", wrap(ap.limit(ap.createJob))).Methods("POST")
+		r.HandleFunc("/v1/jobs/{id}", wrap(ap.limit(ap.getJob))).Methods("GET")
+	}
+	if cfg.Workflows != nil {
+		r.HandleFunc("/v1/workflows", wrap(ap.limit(ap.createWorkflow))).Methods("POST")
+		r.HandleFunc("/v1/workflows/{id}", wrap(ap.limit(ap.getWorkflow))).Methods("GET")
+	}
+	if cfg.Geo.Index != nil {
+		r.HandleFunc("/v1/nearby", wrap(ap.limit(ap.nearby))).Methods("POST")
+		r.HandleFunc("/v1/within", wrap(ap.limit(ap.within))).Methods("POST")
+	}
+	if cfg.Geofences != nil {
+		r.HandleFunc("/v1/geofences", wrap(ap.limit(ap.listGeofences))).Methods("GET")
+		r.HandleFunc("/v1/geofences/{name}", wrap(ap.limit(ap.putGeofence))).Methods("PUT")
+		r.HandleFunc("/v1/geofences/{name}", wrap(ap.limit(ap.getGeofence))).Methods("GET")
+		r.HandleFunc("/v1/geofences/{name}", wrap(ap.limit(ap.deleteGeofence))).Methods("DELETE")
+	}
+	return nil
+}
+
+// InitBulk sets up the bulk upload handler.  An upload can take much
+// longer than the other requests, so it is meant to be served by its own
+// server, without their read and write timeouts, and has a deadline of
+// its own.
+func InitBulk(ctx context.Context, r *mux.Router, store store.Store,
+	cfg Config) error {
+	ap, err := newAPI(store, cfg)
+	if err != nil {
+		return err
+	}
+	r.HandleFunc("/v1/lookup/bulk", wrapContext(ctx, cfg.BulkTimeout,
+		ap.limit(ap.lookupBulk))).Methods("POST")
 	return nil
 }
 
@@ -46,14 +170,25 @@ func (a *api) getStatus(w http.ResponseWriter, r *http.Request) {
 	w.Write(b.Bytes())
 }
 
-// Look up a geocdoing.
+// Look up a geocdoing, returning the original v1 response of just the zip
+// and coordinates.
 func (a *api) lookup(w http.ResponseWriter, r *http.Request) {
+	a.locate(w, r, true)
+}
+
+// Look up a geocoding, returning the full match details.
+func (a *api) lookupV2(w http.ResponseWriter, r *http.Request) {
+	a.locate(w, r, false)
+}
+
+func (a *api) locate(w http.ResponseWriter, r *http.Request, v1 bool) {
 	defer r.Body.Close()
 
 	if !strings.HasPrefix(r.Header.Get("Content-type"), "application/json") {
 		w.Header().Set("Content-Type", "application/json; charset=UTF-8")
 		w.WriteHeader(http.StatusBadRequest)
 		w.Write([]byte("{\"Status\": \"Bad Request\"}"))
+		return
 	}
 	var req types.AddressRequest
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
@@ -65,21 +200,23 @@ func (a *api) lookup(w http.ResponseWriter, r *http.Request) {
 
 	resp, err := a.loc.Locate(r.Context(), req)
 	if err != nil {
-		w.Header().Set("Content-Type", "application/json; charset=UTF-8")
-		w.WriteHeader(http.StatusBadRequest)
-		msg := fmt.Sprintf("{\"status\": \"bad request, error: %s\"}", err)
-		w.Write([]byte(msg))
+		writeError(w, err)
 		return
 	}
-	if resp.Zip == "" {
+	if resp.MatchCount == 0 {
 		w.Header().Set("Content-Type", "application/json; charset=UTF-8")
 		w.WriteHeader(http.StatusNotFound)
 		w.Write([]byte("{\"status\": \"address not located\"}"))
 		return
 	}
+	a.annotate(resp)
 
+	var body interface{} = resp
+	if v1 {
+		body = resp.V1()
+	}
 	var buf bytes.Buffer
-	err = json.NewEncoder(&buf).Encode(resp)
+	err = json.NewEncoder(&buf).Encode(body)
 	if err != nil {
 		w.Header().Set("Content-Type", "application/json; charset=UTF-8")
 		w.WriteHeader(http.StatusInternalServerError)
@@ -92,17 +229,174 @@ func (a *api) lookup(w http.ResponseWriter, r *http.Request) {
 	w.Write(buf.Bytes())
 }
 
-func wrapContext(ctx context.Context, hf http.HandlerFunc) http.HandlerFunc {
-	cw := contextWrapper{ctx: ctx, hf: hf}
+// Look up a batch of geocodings.  The body is a JSON array of address
+// requests, and the response has a result for each, in the same order.
+func (a *api) lookupBatch(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+
+	if !strings.HasPrefix(r.Header.Get("Content-type"), "application/json") {
+		writeStatus(w, http.StatusBadRequest, "bad request, expected JSON")
+		return
+	}
+	var reqs []types.AddressRequest
+	if err := json.NewDecoder(r.Body).Decode(&reqs); err != nil {
+		writeStatus(w, http.StatusBadRequest,
+			fmt.Sprintf("bad request, error: %s", err))
+		return
+	}
+	if len(reqs) == 0 {
+		writeStatus(w, http.StatusBadRequest, "bad request, no addresses")
+		return
+	}
+	if a.cfg.MaxBatchSize > 0 && len(reqs) > a.cfg.MaxBatchSize {
+		writeStatus(w, http.StatusRequestEntityTooLarge,
+			fmt.Sprintf("batch exceeds %d addresses", a.cfg.MaxBatchSize))
+		return
+	}
+
+	results := geolocator.LocateBatch(r.Context(), a.loc, reqs,
+		a.cfg.BatchWorkers)
+	resp := types.BatchResponse{Results: results}
+	for _, res := range results {
+		switch res.Status {
+		case types.BatchFound:
+			resp.Found++
+		case types.BatchNotFound:
+			resp.NotFound++
+		default:
+			resp.Failed++
+		}
+	}
+	writeJSON(w, http.StatusOK, resp)
+}
+
+// Look up the Census geographies containing a set of coordinates.
+func (a *api) reverse(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+
+	if !strings.HasPrefix(r.Header.Get("Content-type"), "application/json") {
+		writeStatus(w, http.StatusBadRequest, "bad request, expected JSON")
+		return
+	}
+	var coords types.Coords
+	if err := json.NewDecoder(r.Body).Decode(&coords); err != nil {
+		writeStatus(w, http.StatusBadRequest,
+			fmt.Sprintf("bad request, error: %s", err))
+		return
+	}
+
+	resp, err := a.loc.Reverse(r.Context(), coords)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	if resp.Geographies.State == "" {
+		writeStatus(w, http.StatusNotFound, "coordinates not located")
+		return
+	}
+	writeJSON(w, http.StatusOK, resp)
+}
+
+// writeJSON encodes the value as the JSON response body.
+func writeJSON(w http.ResponseWriter, code int, v interface{}) {
+	var buf bytes.Buffer
+	if err := json.NewEncoder(&buf).Encode(v); err != nil {
+		writeStatus(w, http.StatusInternalServerError, "json marshal error")
+		return
+	}
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	w.WriteHeader(code)
+	w.Write(buf.Bytes())
+}
+
+// writeStatus writes a JSON status message along with the HTTP code.
+func writeStatus(w http.ResponseWriter, code int, msg string) {
+	b, _ := json.Marshal(types.StatusResponse{Status: msg})
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	w.WriteHeader(code)
+	w.Write(b)
+}
+
+// writeError writes the status for the class of a failed lookup, so that
+// for example a Census outage isn't reported as a bad request.
+func writeError(w http.ResponseWriter, err error) {
+	code := geoerr.HTTPStatus(err)
+	writeStatus(w, code, fmt.Sprintf("%s, error: %s",
+		strings.ToLower(http.StatusText(code)), err))
+}
+
+// TimeoutHeader is the request header with which a client can ask for a
+// shorter deadline than the server's, as a duration such as "2.5s", or a
+// whole number of seconds.
+const TimeoutHeader = "X-Request-Timeout"
+
+// wrapContext runs the handler with a context derived from the request's,
+// so that the lookups are abandoned if the client goes away, which also
+// ends when the server is shutting down, and has the request deadline.
+func wrapContext(ctx context.Context, timeout time.Duration,
+	hf http.HandlerFunc) http.HandlerFunc {
+	cw := contextWrapper{ctx: ctx, timeout: timeout, hf: hf}
 	return cw.wrap
 }
 
 type contextWrapper struct {
-	ctx context.Context
-	hf  http.HandlerFunc
+	ctx     context.Context
+	timeout time.Duration
+	hf      http.HandlerFunc
 }
 
 func (cw *contextWrapper) wrap(w http.ResponseWriter, r *http.Request) {
-	rc := r.WithContext(cw.ctx)
-	cw.hf(w, rc)
+	timeout := cw.timeout
+	if h := r.Header.Get(TimeoutHeader); h != "" {
+		d, err := parseTimeout(h)
+		if err != nil {
+			writeStatus(w, http.StatusBadRequest,
+				fmt.Sprintf("bad request, error: %s", err))
+			return
+		}
+		if timeout <= 0 || d < timeout {
+			timeout = d
+		}
+	}
+	ctx, cancel := mergeContext(cw.ctx, r.Context(), timeout)
+	defer cancel()
+	cw.hf(w, r.WithContext(ctx))
+}
+
+// mergeContext returns a context derived from the request context, with
+// the timeout if there is one, that is cancelled when the server context
+// is.  The cancel function must be called to release it.
+func mergeContext(server, req context.Context,
+	timeout time.Duration) (context.Context, context.CancelFunc) {
+	var ctx context.Context
+	var cancel context.CancelFunc
+	if timeout > 0 {
+		ctx, cancel = context.WithTimeout(req, timeout)
+	} else {
+		ctx, cancel = context.WithCancel(req)
+	}
+	go func() {
+		select {
+		case <-server.Done():
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+	return ctx, cancel
+}
+
+// parseTimeout parses the value of the timeout header.
+func parseTimeout(v string) (time.Duration, error) {
+	d, err := time.ParseDuration(v)
+	if err != nil {
+		secs, serr := strconv.ParseUint(v, 10, 32)
+		if serr != nil {
+			return 0, fmt.Errorf("Invalid %s '%s'", TimeoutHeader, v)
+		}
+		d = time.Duration(secs) * time.Second
+	}
+	if d <= 0 {
+		return 0, fmt.Errorf("%s must be positive, got '%s'", TimeoutHeader, v)
+	}
+	return d, nil
 }
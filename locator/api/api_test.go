@@ -0,0 +1,109 @@
+package api
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gdotgordon/locator-demo/locator/types"
+)
+
+func TestWrapContext(t *testing.T) {
+	for _, test := range []struct {
+		timeout time.Duration
+		header  string
+		code    int
+		max     time.Duration
+	}{
+		{code: http.StatusOK},
+		{timeout: time.Minute, code: http.StatusOK, max: time.Minute},
+		{timeout: time.Minute, header: "2s", code: http.StatusOK,
+			max: 2 * time.Second},
+		{timeout: time.Minute, header: "5", code: http.StatusOK,
+			max: 5 * time.Second},
+		{timeout: time.Second, header: "1h", code: http.StatusOK,
+			max: time.Second},
+		{header: "1h", code: http.StatusOK, max: time.Hour},
+		{header: "soon", code: http.StatusBadRequest},
+		{header: "-1s", code: http.StatusBadRequest},
+	} {
+		var deadline time.Time
+		var hasDeadline bool
+		hf := wrapContext(context.Background(), test.timeout,
+			func(w http.ResponseWriter, r *http.Request) {
+				deadline, hasDeadline = r.Context().Deadline()
+				w.WriteHeader(http.StatusOK)
+			})
+		req := httptest.NewRequest("GET", "/v1/status", nil)
+		if test.header != "" {
+			req.Header.Set(TimeoutHeader, test.header)
+		}
+		rec := httptest.NewRecorder()
+		start := time.Now()
+		hf(rec, req)
+		if rec.Code != test.code {
+			t.Fatalf("Expected status %d for '%s', got %d", test.code,
+				test.header, rec.Code)
+		}
+		if test.code != http.StatusOK {
+			continue
+		}
+		if hasDeadline != (test.max > 0) {
+			t.Fatalf("Expected deadline %v, got %v", test.max > 0, hasDeadline)
+		}
+		if hasDeadline && (deadline.Before(start.Add(test.max/2)) ||
+			deadline.After(time.Now().Add(test.max))) {
+			t.Fatalf("Expected a deadline within %v, got %v", test.max,
+				deadline.Sub(start))
+		}
+	}
+}
+
+func TestWrapContextCancel(t *testing.T) {
+	// The request context ends when the client goes away, and the server
+	// context when the server shuts down, and either ends the handler's.
+	for _, server := range []bool{true, false} {
+		sctx, scancel := context.WithCancel(context.Background())
+		rctx, rcancel := context.WithCancel(context.Background())
+		var err error
+		hf := wrapContext(sctx, time.Minute,
+			func(w http.ResponseWriter, r *http.Request) {
+				if server {
+					scancel()
+				} else {
+					rcancel()
+				}
+				select {
+				case <-r.Context().Done():
+					err = r.Context().Err()
+				case <-time.After(time.Second):
+				}
+			})
+		req := httptest.NewRequest("GET", "/v1/status", nil)
+		hf(httptest.NewRecorder(), req.WithContext(rctx))
+		if err != context.Canceled {
+			t.Fatalf("Expected context canceled (server %v), got %v", server,
+				err)
+		}
+		scancel()
+		rcancel()
+	}
+}
+
+func TestLocateContentType(t *testing.T) {
+	// A body that isn't JSON is refused before it is looked up.
+	ap := &api{loc: &placeLocator{places: map[string]types.Coords{}}}
+	req := httptest.NewRequest("POST", "/v1/lookup",
+		strings.NewReader(`{"street": "Main St"}`))
+	req.Header.Set("Content-Type", "text/plain")
+	rec := httptest.NewRecorder()
+	ap.locate(rec, req, true)
+	if rec.Code != http.StatusBadRequest ||
+		rec.Body.String() != `{"Status": "Bad Request"}` {
+		t.Fatalf("Expected a bad request, got %d: %s", rec.Code,
+			rec.Body.String())
+	}
+}
@@ -0,0 +1,490 @@
+package api
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"mime"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/gdotgordon/locator-demo/locator/geoerr"
+	"github.com/gdotgordon/locator-demo/locator/geolocator"
+	"github.com/gdotgordon/locator-demo/locator/types"
+)
+
+// The formats of a bulk upload and of its results.
+const (
+	formatCSV    = "csv"
+	formatNDJSON = "ndjson"
+)
+
+// The trailers of a bulk upload response, with the counts of the rows,
+// and the error that stopped the upload early, if one did.
+const (
+	TrailerRows     = "X-Bulk-Rows"
+	TrailerFound    = "X-Bulk-Found"
+	TrailerNotFound = "X-Bulk-Not-Found"
+	TrailerFailed   = "X-Bulk-Failed"
+	TrailerError    = "X-Bulk-Error"
+)
+
+// maxLineSize is the longest line of an NDJSON upload.
+const maxLineSize = 1 << 20
+
+// errUploadTooLarge is the error spooling an upload over the size limit.
+var errUploadTooLarge = errors.New("upload too large")
+
+// bulkFields sets the AddressRequest fields that a CSV column can be
+// mapped to, by their JSON names.
+var bulkFields = map[string]func(*types.AddressRequest, string){
+	"struct_number": func(r *types.AddressRequest, v string) { r.StructureNumber = v },
+	"street":        func(r *types.AddressRequest, v string) { r.Street = v },
+	"oneline":       func(r *types.AddressRequest, v string) { r.OneLine = v },
+	"city":          func(r *types.AddressRequest, v string) { r.City = v },
+	"state":         func(r *types.AddressRequest, v string) { r.State = v },
+	"zip":           func(r *types.AddressRequest, v string) { r.Zip = v },
+	"benchmark":     func(r *types.AddressRequest, v string) { r.Benchmark = v },
+	"vintage":       func(r *types.AddressRequest, v string) { r.Vintage = v },
+}
+
+// resultColumns are the columns of the CSV results, after the columns of
+// the upload.
+var resultColumns = []string{"index", "status", "matched_address", "x", "y",
+	"matched_zip", "error_class", "error"}
+
+// Look up the addresses of an uploaded file, a CSV file with a header
+// row, or NDJSON with an address request on each line.  The rows are
+// looked up concurrently, and the results streamed back in the order of
+// the rows, as CSV or NDJSON, with the counts in the trailers.
+func (a *api) lookupBulk(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+
+	in, err := bulkFormat(r.Header.Get("Content-type"))
+	if err != nil {
+		writeStatus(w, http.StatusBadRequest,
+			fmt.Sprintf("bad request, error: %s", err))
+		return
+	}
+	out := r.URL.Query().Get("format")
+	if out == "" {
+		out = in
+	}
+	if out != formatCSV && out != formatNDJSON {
+		writeStatus(w, http.StatusBadRequest,
+			fmt.Sprintf("bad request, unknown format '%s'", out))
+		return
+	}
+
+	// The upload is spooled to a file before any results are written,
+	// as an HTTP/1.1 handler can't read the rest of the body once it has
+	// started writing the response.
+	f, err := a.spool(r.Body)
+	if err == errUploadTooLarge {
+		writeStatus(w, http.StatusRequestEntityTooLarge,
+			fmt.Sprintf("upload exceeds %d bytes", a.cfg.MaxBulkSize))
+		return
+	}
+	if err != nil {
+		writeStatus(w, http.StatusInternalServerError,
+			fmt.Sprintf("error reading upload: %s", err))
+		return
+	}
+	defer os.Remove(f.Name())
+	defer f.Close()
+
+	var header []string
+	var next func() (bulkRow, error)
+	if in == formatCSV {
+		header, next, err = csvRows(f, r.URL.Query().Get("columns"))
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+	} else {
+		next = ndjsonRows(f)
+	}
+
+	var rw rowWriter
+	if out == formatCSV {
+		rw = newCSVWriter(w, header)
+		w.Header().Set("Content-Type", "text/csv; charset=UTF-8")
+	} else {
+		rw = newNDJSONWriter(w)
+		w.Header().Set("Content-Type", "application/x-ndjson")
+	}
+	w.Header().Set("Trailer", strings.Join([]string{TrailerRows,
+		TrailerFound, TrailerNotFound, TrailerFailed, TrailerError}, ", "))
+	w.WriteHeader(http.StatusOK)
+
+	var resp types.BatchResponse
+	err = rw.begin()
+	if err == nil {
+		err = locateRows(r.Context(), a.loc, next, a.cfg.BulkWorkers,
+			a.cfg.BulkWindow, rw, func(res types.BatchResult) {
+				switch res.Status {
+				case types.BatchFound:
+					resp.Found++
+				case types.BatchNotFound:
+					resp.NotFound++
+				default:
+					resp.Failed++
+				}
+			})
+	}
+	if ferr := rw.flush(); err == nil {
+		err = ferr
+	}
+	if err != nil {
+		log.Printf("bulk upload stopped: %v", err)
+		w.Header().Set(TrailerError, err.Error())
+	}
+	w.Header().Set(TrailerRows,
+		strconv.Itoa(resp.Found+resp.NotFound+resp.Failed))
+	w.Header().Set(TrailerFound, strconv.Itoa(resp.Found))
+	w.Header().Set(TrailerNotFound, strconv.Itoa(resp.NotFound))
+	w.Header().Set(TrailerFailed, strconv.Itoa(resp.Failed))
+}
+
+// bulkFormat returns the format of the upload, from its content type.
+func bulkFormat(contentType string) (string, error) {
+	mt, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return "", fmt.Errorf("invalid content type '%s'", contentType)
+	}
+	switch mt {
+	case "text/csv":
+		return formatCSV, nil
+	case "application/x-ndjson", "application/ndjson":
+		return formatNDJSON, nil
+	}
+	return "", fmt.Errorf("expected CSV or NDJSON, got '%s'", mt)
+}
+
+// spool copies the upload to a temporary file, which the caller must
+// close and remove, and rewinds it.
+func (a *api) spool(body io.Reader) (*os.File, error) {
+	f, err := ioutil.TempFile("", "locator-bulk-")
+	if err != nil {
+		return nil, err
+	}
+	if a.cfg.MaxBulkSize > 0 {
+		body = io.LimitReader(body, a.cfg.MaxBulkSize+1)
+	}
+	n, err := io.Copy(f, body)
+	if err == nil && a.cfg.MaxBulkSize > 0 && n > a.cfg.MaxBulkSize {
+		err = errUploadTooLarge
+	}
+	if err == nil {
+		_, err = f.Seek(0, io.SeekStart)
+	}
+	if err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return nil, err
+	}
+	return f, nil
+}
+
+// bulkRow is one row of an upload, with its request and, for CSV, its
+// record, or the error parsing it.
+type bulkRow struct {
+	req    types.AddressRequest
+	record []string
+	err    error
+}
+
+// csvRows reads the header of the CSV file, and returns it with a
+// function reading the rows that follow.  columns maps the AddressRequest
+// fields to the columns, as "street:Address,zip:Postcode", and without it
+// the columns named after the fields are used.
+func csvRows(rd io.Reader, columns string) ([]string,
+	func() (bulkRow, error), error) {
+	cr := csv.NewReader(rd)
+	header, err := cr.Read()
+	if err == io.EOF {
+		return nil, nil, geoerr.New(geoerr.Validation, "CSV upload is empty")
+	}
+	if err != nil {
+		return nil, nil, geoerr.Errorf(geoerr.Validation,
+			"Invalid CSV header: %v", err)
+	}
+
+	cols := make(map[string]int)
+	for i, name := range header {
+		cols[strings.ToLower(strings.TrimSpace(name))] = i
+	}
+	setters := make(map[int]func(*types.AddressRequest, string))
+	if columns == "" {
+		for field, set := range bulkFields {
+			if i, ok := cols[field]; ok {
+				setters[i] = set
+			}
+		}
+	} else {
+		for _, m := range strings.Split(columns, ",") {
+			kv := strings.SplitN(m, ":", 2)
+			if len(kv) != 2 {
+				return nil, nil, geoerr.Errorf(geoerr.Validation,
+					"Invalid column mapping '%s', expected field:column", m)
+			}
+			set, ok := bulkFields[strings.TrimSpace(kv[0])]
+			if !ok {
+				return nil, nil, geoerr.Errorf(geoerr.Validation,
+					"Unknown address field '%s'", kv[0])
+			}
+			i, ok := cols[strings.ToLower(strings.TrimSpace(kv[1]))]
+			if !ok {
+				return nil, nil, geoerr.Errorf(geoerr.Validation,
+					"Column '%s' is not in the CSV header", kv[1])
+			}
+			setters[i] = set
+		}
+	}
+	if len(setters) == 0 {
+		return nil, nil, geoerr.New(geoerr.Validation,
+			"No CSV columns are mapped to address fields")
+	}
+
+	next := func() (bulkRow, error) {
+		rec, err := cr.Read()
+		if err != nil {
+			if pe, ok := err.(*csv.ParseError); ok {
+				return bulkRow{record: rec, err: geoerr.Errorf(
+					geoerr.Validation, "Invalid CSV row: %v", pe)}, nil
+			}
+			return bulkRow{}, err
+		}
+		row := bulkRow{record: rec}
+		for i, set := range setters {
+			set(&row.req, rec[i])
+		}
+		return row, nil
+	}
+	return header, next, nil
+}
+
+// ndjsonRows returns a function reading the address requests of an
+// NDJSON file, skipping blank lines.
+func ndjsonRows(rd io.Reader) func() (bulkRow, error) {
+	sc := bufio.NewScanner(rd)
+	sc.Buffer(make([]byte, 4096), maxLineSize)
+	return func() (bulkRow, error) {
+		for sc.Scan() {
+			line := sc.Bytes()
+			if len(bytes.TrimSpace(line)) == 0 {
+				continue
+			}
+			var row bulkRow
+			if err := json.Unmarshal(line, &row.req); err != nil {
+				row.err = geoerr.Errorf(geoerr.Validation,
+					"Invalid request: %v", err)
+			}
+			return row, nil
+		}
+		if err := sc.Err(); err != nil {
+			return bulkRow{}, err
+		}
+		return bulkRow{}, io.EOF
+	}
+}
+
+// rowWriter writes the results of an upload.  begin writes anything that
+// comes before the results, and flush sends what has been written so far
+// to the client.
+type rowWriter interface {
+	begin() error
+	write(row bulkRow, res types.BatchResult) error
+	flush() error
+}
+
+// flushWriter flushes the response, if it can be.
+type flushWriter struct {
+	w http.ResponseWriter
+}
+
+func (fw flushWriter) flushResponse() {
+	if f, ok := fw.w.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// csvWriter writes each result as a CSV row, after the columns of the
+// uploaded row, if it was CSV.
+type csvWriter struct {
+	flushWriter
+	cw     *csv.Writer
+	header []string
+}
+
+func newCSVWriter(w http.ResponseWriter, header []string) *csvWriter {
+	return &csvWriter{flushWriter: flushWriter{w: w}, cw: csv.NewWriter(w),
+		header: header}
+}
+
+func (cw *csvWriter) begin() error {
+	return cw.cw.Write(append(append([]string{}, cw.header...),
+		resultColumns...))
+}
+
+func (cw *csvWriter) write(row bulkRow, res types.BatchResult) error {
+	// A row that couldn't be parsed may have the wrong number of columns.
+	rec := make([]string, len(cw.header), len(cw.header)+len(resultColumns))
+	copy(rec, row.record)
+	rec = append(rec, strconv.Itoa(res.Index), res.Status)
+	if res.Response != nil {
+		rec = append(rec, res.Response.MatchedAddress,
+			strconv.FormatFloat(res.Response.Coordinates.X, 'f', -1, 64),
+			strconv.FormatFloat(res.Response.Coordinates.Y, 'f', -1, 64),
+			res.Response.Zip)
+	} else {
+		rec = append(rec, "", "", "", "")
+	}
+	rec = append(rec, res.ErrorClass, res.Error)
+	return cw.cw.Write(rec)
+}
+
+func (cw *csvWriter) flush() error {
+	cw.cw.Flush()
+	if err := cw.cw.Error(); err != nil {
+		return err
+	}
+	cw.flushResponse()
+	return nil
+}
+
+// ndjsonWriter writes each result as a line of JSON.
+type ndjsonWriter struct {
+	flushWriter
+	enc *json.Encoder
+}
+
+func newNDJSONWriter(w http.ResponseWriter) *ndjsonWriter {
+	return &ndjsonWriter{flushWriter: flushWriter{w: w},
+		enc: json.NewEncoder(w)}
+}
+
+func (nw *ndjsonWriter) begin() error {
+	return nil
+}
+
+func (nw *ndjsonWriter) write(row bulkRow, res types.BatchResult) error {
+	return nw.enc.Encode(res)
+}
+
+func (nw *ndjsonWriter) flush() error {
+	nw.flushResponse()
+	return nil
+}
+
+// pendingRow is a row being looked up, and where its result goes.
+type pendingRow struct {
+	index int
+	row   bulkRow
+	res   chan types.BatchResult
+}
+
+// locateRows looks up the rows returned by next, until it returns io.EOF,
+// using a pool of workers, and writes the results in the order of the
+// rows, passing each one written to count.  At most window rows are read
+// ahead of the one being written, so the memory used doesn't grow with
+// the upload.  The results written so far are flushed whenever the next
+// one isn't ready.  It returns the error reading or writing the rows, or the error
+// of the context if it ends before all the rows are read.
+func locateRows(ctx context.Context, loc geolocator.Geolocator,
+	next func() (bulkRow, error), workers, window int, rw rowWriter,
+	count func(types.BatchResult)) error {
+	if workers <= 0 {
+		workers = 1
+	}
+	if window < workers {
+		window = workers
+	}
+
+	order := make(chan *pendingRow, window)
+	work := make(chan *pendingRow)
+	stop := make(chan struct{})
+	var readErr error
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		defer close(work)
+		defer close(order)
+		for i := 0; ; i++ {
+			if err := ctx.Err(); err != nil {
+				readErr = err
+				return
+			}
+			row, err := next()
+			if err == io.EOF {
+				return
+			}
+			if err != nil {
+				readErr = err
+				return
+			}
+			p := &pendingRow{index: i, row: row,
+				res: make(chan types.BatchResult, 1)}
+			select {
+			case order <- p:
+			case <-stop:
+				return
+			}
+			if row.err != nil {
+				p.res <- types.BatchResult{Index: i, Status: types.BatchError,
+					Error: row.err.Error(), ErrorClass: geoerr.ClassOf(row.err)}
+				continue
+			}
+			select {
+			case work <- p:
+			case <-stop:
+				return
+			}
+		}
+	}()
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for p := range work {
+				p.res <- geolocator.LocateOne(ctx, loc, p.index, p.row.req)
+			}
+		}()
+	}
+
+	var writeErr error
+	for p := range order {
+		var res types.BatchResult
+		select {
+		case res = <-p.res:
+		default:
+			writeErr = rw.flush()
+			res = <-p.res
+		}
+		if writeErr == nil {
+			if writeErr = rw.write(p.row, res); writeErr == nil {
+				count(res)
+			}
+		}
+		if writeErr != nil {
+			close(stop)
+			break
+		}
+	}
+	wg.Wait()
+	if writeErr != nil {
+		return writeErr
+	}
+	return readErr
+}
@@ -0,0 +1,260 @@
+package api
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gdotgordon/locator-demo/locator/types"
+)
+
+func bulkRequest(ap *api, contentType, query, body string) *http.Response {
+	req := httptest.NewRequest("POST", "/v1/lookup/bulk"+query,
+		strings.NewReader(body))
+	req.Header.Set("Content-Type", contentType)
+	rec := httptest.NewRecorder()
+	ap.lookupBulk(rec, req)
+	return rec.Result()
+}
+
+func TestLookupBulk(t *testing.T) {
+	ap := &api{loc: &placeLocator{places: map[string]types.Coords{
+		"Equator St": {X: 1, Y: 0}}},
+		cfg: Config{BulkWorkers: 3, BulkWindow: 4, MaxBulkSize: 1000}}
+
+	for _, test := range []struct {
+		contentType string
+		query       string
+		body        string
+		rows        [][]string
+		lines       []types.BatchResult
+		counts      []string
+	}{
+		{contentType: "text/csv", query: "?columns=street:Addr",
+			body: "Addr,Town\nEquator St,Quito\nNowhere Rd,Nowhere\n" +
+				",Lost\nToo,Many,Columns\n",
+			rows: [][]string{
+				{"Addr", "Town", "index", "status", "matched_address", "x",
+					"y", "matched_zip", "error_class", "error"},
+				{"Equator St", "Quito", "0", "found", "", "1", "0", "", "", ""},
+				{"Nowhere Rd", "Nowhere", "1", "not_found", "", "", "", "",
+					"", ""},
+				{"", "Lost", "2", "error", "", "", "", "", "validation",
+					"Street is required"},
+				{"Too", "Many", "3", "error", "", "", "", "", "validation",
+					"Invalid CSV row: record on line 5: wrong number of fields"},
+			},
+			counts: []string{"4", "1", "1", "2"}},
+		{contentType: "application/x-ndjson",
+			body: `{"street": "Equator St"}` + "\n\n" + `{"street": 1}` +
+				"\n" + `{"street": "Nowhere Rd"}`,
+			lines: []types.BatchResult{
+				{Index: 0, Status: types.BatchFound,
+					Response: &types.AddressResponse{MatchCount: 1,
+						Coordinates: types.Coords{X: 1, Y: 0}}},
+				{Index: 1, Status: types.BatchError, ErrorClass: "validation",
+					Error: "Invalid request: json: cannot unmarshal number " +
+						"into Go struct field AddressRequest.street of type " +
+						"string"},
+				{Index: 2, Status: types.BatchNotFound},
+			},
+			counts: []string{"3", "1", "1", "1"}},
+		{contentType: "application/x-ndjson", query: "?format=csv",
+			body: `{"street": "Equator St"}`,
+			rows: [][]string{
+				{"index", "status", "matched_address", "x", "y",
+					"matched_zip", "error_class", "error"},
+				{"0", "found", "", "1", "0", "", "", ""},
+			},
+			counts: []string{"1", "1", "0", "0"}},
+	} {
+		resp := bulkRequest(ap, test.contentType, test.query, test.body)
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("Expected status 200, got %d", resp.StatusCode)
+		}
+		if test.rows != nil {
+			rows, err := csv.NewReader(resp.Body).ReadAll()
+			if err != nil {
+				t.Fatalf("Got unexpected error: %v", err)
+			}
+			if !reflect.DeepEqual(rows, test.rows) {
+				t.Fatalf("Expected rows %q, got %q", test.rows, rows)
+			}
+		} else {
+			var lines []types.BatchResult
+			dec := json.NewDecoder(resp.Body)
+			for {
+				var res types.BatchResult
+				if err := dec.Decode(&res); err == io.EOF {
+					break
+				} else if err != nil {
+					t.Fatalf("Got unexpected error: %v", err)
+				}
+				lines = append(lines, res)
+			}
+			if !reflect.DeepEqual(lines, test.lines) {
+				t.Fatalf("Expected results %+v, got %+v", test.lines, lines)
+			}
+		}
+		var counts []string
+		for _, tr := range []string{TrailerRows, TrailerFound,
+			TrailerNotFound, TrailerFailed} {
+			counts = append(counts, resp.Trailer.Get(tr))
+		}
+		if !reflect.DeepEqual(counts, test.counts) ||
+			resp.Trailer.Get(TrailerError) != "" {
+			t.Fatalf("Expected counts %v, got %v and error '%s'", test.counts,
+				counts, resp.Trailer.Get(TrailerError))
+		}
+	}
+}
+
+func TestLookupBulkInvalid(t *testing.T) {
+	ap := &api{loc: &placeLocator{}, cfg: Config{MaxBulkSize: 30}}
+	for _, test := range []struct {
+		contentType string
+		query       string
+		body        string
+		code        int
+		e           string
+	}{
+		{contentType: "application/json", body: "[]",
+			code: http.StatusBadRequest,
+			e: "bad request, error: expected CSV or NDJSON, got " +
+				"'application/json'"},
+		{contentType: "text/csv", query: "?format=xml", body: "street\n",
+			code: http.StatusBadRequest,
+			e:    "bad request, unknown format 'xml'"},
+		{contentType: "text/csv", body: "", code: http.StatusBadRequest,
+			e: "bad request, error: CSV upload is empty"},
+		{contentType: "text/csv", body: "Address,Town\n",
+			code: http.StatusBadRequest,
+			e: "bad request, error: No CSV columns are mapped to " +
+				"address fields"},
+		{contentType: "text/csv", query: "?columns=street:Road",
+			body: "Address,Town\n", code: http.StatusBadRequest,
+			e: "bad request, error: Column 'Road' is not in the CSV header"},
+		{contentType: "text/csv", query: "?columns=county:Town",
+			body: "Address,Town\n", code: http.StatusBadRequest,
+			e: "bad request, error: Unknown address field 'county'"},
+		{contentType: "text/csv", body: strings.Repeat("street\n", 5),
+			code: http.StatusRequestEntityTooLarge,
+			e:    "upload exceeds 30 bytes"},
+	} {
+		resp := bulkRequest(ap, test.contentType, test.query, test.body)
+		var sr types.StatusResponse
+		if err := json.NewDecoder(resp.Body).Decode(&sr); err != nil {
+			t.Fatalf("Got unexpected error: %v", err)
+		}
+		if resp.StatusCode != test.code || sr.Status != test.e {
+			t.Fatalf("Expected status %d and '%s', got %d and '%s'",
+				test.code, test.e, resp.StatusCode, sr.Status)
+		}
+	}
+}
+
+// slowLocator finds every address, taking longer for the lower numbered
+// streets, so that the lookups finish out of order.
+type slowLocator struct{}
+
+func (sl slowLocator) Locate(ctx context.Context,
+	req types.AddressRequest) (*types.AddressResponse, error) {
+	n, _ := strconv.Atoi(req.Street)
+	time.Sleep(time.Duration(10-n%10) * time.Millisecond)
+	return &types.AddressResponse{MatchCount: 1, MatchedAddress: req.Street},
+		nil
+}
+
+func (sl slowLocator) Reverse(ctx context.Context,
+	coords types.Coords) (*types.ReverseResponse, error) {
+	return &types.ReverseResponse{Coordinates: coords}, nil
+}
+
+// resultWriter keeps the results it is given, and fails to write any
+// more once it has limit of them, if that is set.
+type resultWriter struct {
+	results []types.BatchResult
+	flushes int
+	limit   int
+}
+
+func (rw *resultWriter) begin() error {
+	return nil
+}
+
+func (rw *resultWriter) write(row bulkRow, res types.BatchResult) error {
+	if rw.limit > 0 && len(rw.results) == rw.limit {
+		return errors.New("client went away")
+	}
+	rw.results = append(rw.results, res)
+	return nil
+}
+
+func (rw *resultWriter) flush() error {
+	rw.flushes++
+	return nil
+}
+
+func TestLocateRows(t *testing.T) {
+	const rows = 100
+	i := 0
+	next := func() (bulkRow, error) {
+		if i == rows {
+			return bulkRow{}, io.EOF
+		}
+		i++
+		return bulkRow{req: types.AddressRequest{Street: fmt.Sprint(i - 1)}},
+			nil
+	}
+	rw := &resultWriter{}
+	found := 0
+	err := locateRows(context.Background(), slowLocator{}, next, 8, 16, rw,
+		func(res types.BatchResult) {
+			if res.Status == types.BatchFound {
+				found++
+			}
+		})
+	if err != nil {
+		t.Fatalf("Got unexpected error: %v", err)
+	}
+	if len(rw.results) != rows || found != rows || rw.flushes == 0 {
+		t.Fatalf("Expected %d results found and flushed, got %d, %d found "+
+			"and %d flushes", rows, len(rw.results), found, rw.flushes)
+	}
+	for n, res := range rw.results {
+		if res.Index != n || res.Response.MatchedAddress != fmt.Sprint(n) {
+			t.Fatalf("Expected result %d in order, got %+v", n, res)
+		}
+	}
+
+	// Only the results that were written are counted.
+	i, found = 0, 0
+	rw = &resultWriter{limit: 10}
+	err = locateRows(context.Background(), slowLocator{}, next, 8, 16, rw,
+		func(types.BatchResult) { found++ })
+	if err == nil || len(rw.results) != 10 || found != 10 {
+		t.Fatalf("Expected 10 results written and counted, got %d, %d "+
+			"counted and error %v", len(rw.results), found, err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	i = 0
+	rw = &resultWriter{}
+	err = locateRows(ctx, slowLocator{}, next, 8, 16, rw,
+		func(types.BatchResult) {})
+	if err != context.Canceled || len(rw.results) != 0 {
+		t.Fatalf("Expected no results and cancellation, got %d and %v",
+			len(rw.results), err)
+	}
+}
@@ -0,0 +1,190 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/gdotgordon/locator-demo/locator/geo"
+	"github.com/gdotgordon/locator-demo/locator/geoerr"
+	"github.com/gdotgordon/locator-demo/locator/geolocator"
+	"github.com/gdotgordon/locator-demo/locator/types"
+)
+
+// Get the distance between two locations, each given by its coordinates
+// or by an address to look up.
+func (a *api) distance(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+
+	if !strings.HasPrefix(r.Header.Get("Content-type"), "application/json") {
+		writeStatus(w, http.StatusBadRequest, "bad request, expected JSON")
+		return
+	}
+	var req types.DistanceRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeStatus(w, http.StatusBadRequest,
+			fmt.Sprintf("bad request, error: %s", err))
+		return
+	}
+	unit, err := unitLength(&req.Unit)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	res := a.resolve(r.Context(), []types.Location{req.From, req.To})
+	for i, name := range []string{"from", "to"} {
+		if res[i].err != nil {
+			writeError(w, fmt.Errorf("%s: %w", name, res[i].err))
+			return
+		}
+	}
+	from, to := *res[0].coords, *res[1].coords
+	writeJSON(w, http.StatusOK, types.DistanceResponse{From: from, To: to,
+		Unit: req.Unit, Distance: measure(from, to, unit)})
+}
+
+// Get the distance from each of a set of origins to each of a set of
+// destinations.  A location that can't be found doesn't fail the request,
+// but its row or column of the matrix has its error instead.
+func (a *api) distanceMatrix(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+
+	if !strings.HasPrefix(r.Header.Get("Content-type"), "application/json") {
+		writeStatus(w, http.StatusBadRequest, "bad request, expected JSON")
+		return
+	}
+	var req types.MatrixRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeStatus(w, http.StatusBadRequest,
+			fmt.Sprintf("bad request, error: %s", err))
+		return
+	}
+	if len(req.Origins) == 0 || len(req.Destinations) == 0 {
+		writeStatus(w, http.StatusBadRequest,
+			"bad request, expected origins and destinations")
+		return
+	}
+	if a.cfg.MaxMatrixSize > 0 &&
+		len(req.Origins)*len(req.Destinations) > a.cfg.MaxMatrixSize {
+		writeStatus(w, http.StatusRequestEntityTooLarge,
+			fmt.Sprintf("matrix exceeds %d cells", a.cfg.MaxMatrixSize))
+		return
+	}
+	unit, err := unitLength(&req.Unit)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	n := len(req.Origins)
+	res := a.resolve(r.Context(), append(append([]types.Location{},
+		req.Origins...), req.Destinations...))
+	origins, dests := res[:n], res[n:]
+	resp := types.MatrixResponse{Unit: req.Unit,
+		Rows: make([][]types.MatrixCell, len(origins))}
+	for _, o := range origins {
+		resp.Origins = append(resp.Origins, o.resolved())
+	}
+	for _, d := range dests {
+		resp.Destinations = append(resp.Destinations, d.resolved())
+	}
+	for i, o := range origins {
+		resp.Rows[i] = make([]types.MatrixCell, len(dests))
+		for j, d := range dests {
+			cell := &resp.Rows[i][j]
+			switch {
+			case o.err != nil:
+				cell.Error = fmt.Sprintf("Origin %d: %s", i, o.err)
+				cell.ErrorClass = geoerr.ClassOf(o.err)
+			case d.err != nil:
+				cell.Error = fmt.Sprintf("Destination %d: %s", j, d.err)
+				cell.ErrorClass = geoerr.ClassOf(d.err)
+			default:
+				dist := measure(*o.coords, *d.coords, unit)
+				cell.Distance = &dist
+			}
+		}
+	}
+	writeJSON(w, http.StatusOK, resp)
+}
+
+// location is the outcome of finding the coordinates of a location.
+type location struct {
+	coords *types.Coords
+	err    error
+}
+
+// resolved returns the location as it is reported.
+func (l location) resolved() types.ResolvedLocation {
+	if l.err != nil {
+		return types.ResolvedLocation{Error: l.err.Error(),
+			ErrorClass: geoerr.ClassOf(l.err)}
+	}
+	return types.ResolvedLocation{Coordinates: l.coords}
+}
+
+// resolve finds the coordinates of the locations, looking up the
+// addresses together, as a batch.
+func (a *api) resolve(ctx context.Context,
+	locs []types.Location) []location {
+	out := make([]location, len(locs))
+	var reqs []types.AddressRequest
+	var pos []int
+	for i, l := range locs {
+		switch {
+		case (l.Coordinates == nil) == (l.Address == nil):
+			out[i].err = geoerr.New(geoerr.Validation,
+				"Expected either coordinates or an address")
+		case l.Coordinates != nil:
+			if !geo.Valid(*l.Coordinates) {
+				out[i].err = geoerr.New(geoerr.Validation,
+					"Coordinates are out of range")
+				continue
+			}
+			out[i].coords = l.Coordinates
+		default:
+			reqs = append(reqs, *l.Address)
+			pos = append(pos, i)
+		}
+	}
+	if len(reqs) == 0 {
+		return out
+	}
+	for _, res := range geolocator.LocateBatch(ctx, a.loc, reqs,
+		a.cfg.BatchWorkers) {
+		l := &out[pos[res.Index]]
+		switch res.Status {
+		case types.BatchFound:
+			c := res.Response.Coordinates
+			l.coords = &c
+		case types.BatchNotFound:
+			l.err = geoerr.New(geoerr.NotFound, "Address not located")
+		default:
+			l.err = geoerr.New(res.ErrorClass, res.Error)
+		}
+	}
+	return out
+}
+
+// unitLength returns the length in meters of the unit, which defaults to
+// kilometers.
+func unitLength(unit *string) (float64, error) {
+	if *unit == "" {
+		*unit = geo.DefaultUnit
+	}
+	return geo.UnitLength(*unit)
+}
+
+// measure returns the distance between the points in the unit, whose
+// length is in meters.
+func measure(from, to types.Coords, unit float64) types.Distance {
+	d := types.Distance{Haversine: geo.Haversine(from, to) / unit}
+	if v, err := geo.Vincenty(from, to); err == nil {
+		v /= unit
+		d.Vincenty = &v
+	}
+	return d
+}
@@ -0,0 +1,146 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gdotgordon/locator-demo/locator/geoerr"
+	"github.com/gdotgordon/locator-demo/locator/types"
+)
+
+// placeLocator locates the streets it knows, and nothing else.
+type placeLocator struct {
+	places map[string]types.Coords
+}
+
+func (pl *placeLocator) Locate(ctx context.Context,
+	req types.AddressRequest) (*types.AddressResponse, error) {
+	if req.Street == "" {
+		return nil, geoerr.New(geoerr.Validation, "Street is required")
+	}
+	c, ok := pl.places[req.Street]
+	if !ok {
+		return &types.AddressResponse{}, nil
+	}
+	return &types.AddressResponse{MatchCount: 1, Coordinates: c}, nil
+}
+
+func (pl *placeLocator) Reverse(ctx context.Context,
+	coords types.Coords) (*types.ReverseResponse, error) {
+	return &types.ReverseResponse{Coordinates: coords}, nil
+}
+
+func TestDistance(t *testing.T) {
+	ap := &api{loc: &placeLocator{places: map[string]types.Coords{
+		"Equator St": {X: 1, Y: 0}}}, cfg: Config{MaxMatrixSize: 4}}
+	for _, test := range []struct {
+		body      string
+		code      int
+		haversine float64
+	}{
+		{body: `{"from": {"coordinates": {"x": 0, "y": 0}},
+			"to": {"address": {"street": "Equator St"}}}`,
+			code: http.StatusOK, haversine: 111.195},
+		{body: `{"from": {"coordinates": {"x": 0, "y": 0}},
+			"to": {"coordinates": {"x": 1, "y": 0}}, "unit": "mi"}`,
+			code: http.StatusOK, haversine: 69.093},
+		{body: `{"from": {"coordinates": {"x": 0, "y": 0}},
+			"to": {"address": {"street": "Nowhere Rd"}}}`,
+			code: http.StatusNotFound},
+		{body: `{"from": {"coordinates": {"x": 0, "y": 100}},
+			"to": {"coordinates": {"x": 1, "y": 0}}}`,
+			code: http.StatusBadRequest},
+		{body: `{"from": {}, "to": {"coordinates": {"x": 1, "y": 0}}}`,
+			code: http.StatusBadRequest},
+		{body: `{"from": {"coordinates": {"x": 0, "y": 0}},
+			"to": {"coordinates": {"x": 1, "y": 0}}, "unit": "cubit"}`,
+			code: http.StatusBadRequest},
+	} {
+		req := httptest.NewRequest("POST", "/v1/distance",
+			strings.NewReader(test.body))
+		req.Header.Set("Content-Type", "application/json")
+		rec := httptest.NewRecorder()
+		ap.distance(rec, req)
+		if rec.Code != test.code {
+			t.Fatalf("Expected status %d for %s, got %d: %s", test.code,
+				test.body, rec.Code, rec.Body.String())
+		}
+		if test.code != http.StatusOK {
+			continue
+		}
+		var resp types.DistanceResponse
+		if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+			t.Fatalf("Got unexpected error: %v", err)
+		}
+		if math.Abs(resp.Haversine-test.haversine) > 0.001 ||
+			resp.Vincenty == nil {
+			t.Fatalf("Expected haversine %v and Vincenty, got %+v",
+				test.haversine, resp.Distance)
+		}
+	}
+}
+
+func TestDistanceMatrix(t *testing.T) {
+	ap := &api{loc: &placeLocator{places: map[string]types.Coords{
+		"Equator St": {X: 1, Y: 0}}}, cfg: Config{MaxMatrixSize: 4}}
+	body := `{"origins": [{"coordinates": {"x": 0, "y": 0}},
+			{"address": {"street": "Nowhere Rd"}}],
+		"destinations": [{"address": {"street": "Equator St"}},
+			{"address": {"city": "Suitland"}}], "unit": "m"}`
+	req := httptest.NewRequest("POST", "/v1/distance/matrix",
+		strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	rec := httptest.NewRecorder()
+	ap.distanceMatrix(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d: %s", rec.Code,
+			rec.Body.String())
+	}
+	var resp types.MatrixResponse
+	if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+		t.Fatalf("Got unexpected error: %v", err)
+	}
+
+	if d := resp.Rows[0][0].Distance; d == nil ||
+		math.Abs(d.Haversine-111195.080) > 0.01 {
+		t.Fatalf("Expected a distance of 111195.080m, got %+v", d)
+	}
+	for _, test := range []struct {
+		cell  types.MatrixCell
+		e     string
+		class string
+	}{
+		{cell: resp.Rows[0][1], e: "Destination 1: Street is required",
+			class: geoerr.Validation},
+		{cell: resp.Rows[1][0], e: "Origin 1: Address not located",
+			class: geoerr.NotFound},
+		{cell: resp.Rows[1][1], e: "Origin 1: Address not located",
+			class: geoerr.NotFound},
+	} {
+		if test.cell.Distance != nil || test.cell.Error != test.e ||
+			test.cell.ErrorClass != test.class {
+			t.Fatalf("Expected error '%s' of class '%s', got %+v", test.e,
+				test.class, test.cell)
+		}
+	}
+	if resp.Destinations[0].Coordinates == nil ||
+		resp.Origins[1].ErrorClass != geoerr.NotFound {
+		t.Fatalf("Expected the resolved locations, got %+v and %+v",
+			resp.Origins, resp.Destinations)
+	}
+
+	req = httptest.NewRequest("POST", "/v1/distance/matrix",
+		strings.NewReader(`{"origins": [{}, {}, {}],
+			"destinations": [{}, {}]}`))
+	req.Header.Set("Content-Type", "application/json")
+	rec = httptest.NewRecorder()
+	ap.distanceMatrix(rec, req)
+	if rec.Code != http.StatusRequestEntityTooLarge {
+		t.Fatalf("Expected status 413, got %d", rec.Code)
+	}
+}
@@ -0,0 +1,113 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/gdotgordon/locator-demo/locator/geofence"
+	"github.com/gdotgordon/locator-demo/locator/types"
+	"github.com/gorilla/mux"
+)
+
+// List the geofences.
+func (a *api) listGeofences(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+
+	fences, err := a.cfg.Geofences.List()
+	if err != nil {
+		writeStatus(w, http.StatusInternalServerError,
+			fmt.Sprintf("error listing geofences: %s", err))
+		return
+	}
+	writeJSON(w, http.StatusOK, fences)
+}
+
+// Create or replace a geofence.  The body is the geofence, whose name, if
+// any, is overridden by the one in the path.
+func (a *api) putGeofence(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+
+	if !strings.HasPrefix(r.Header.Get("Content-type"), "application/json") {
+		writeStatus(w, http.StatusBadRequest, "bad request, expected JSON")
+		return
+	}
+	var f types.Geofence
+	if err := json.NewDecoder(r.Body).Decode(&f); err != nil {
+		writeStatus(w, http.StatusBadRequest,
+			fmt.Sprintf("bad request, error: %s", err))
+		return
+	}
+	f.Name = mux.Vars(r)["name"]
+	if err := geofence.Validate(&f); err != nil {
+		writeError(w, err)
+		return
+	}
+	created, err := a.cfg.Geofences.Put(&f)
+	if err != nil {
+		writeStatus(w, http.StatusInternalServerError,
+			fmt.Sprintf("error saving geofence: %s", err))
+		return
+	}
+	code := http.StatusOK
+	if created {
+		code = http.StatusCreated
+	}
+	writeJSON(w, code, f)
+}
+
+// Get a geofence.
+func (a *api) getGeofence(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+
+	f, err := a.cfg.Geofences.Get(mux.Vars(r)["name"])
+	if err != nil {
+		writeStatus(w, http.StatusInternalServerError,
+			fmt.Sprintf("error getting geofence: %s", err))
+		return
+	}
+	if f == nil {
+		writeStatus(w, http.StatusNotFound, "geofence not found")
+		return
+	}
+	writeJSON(w, http.StatusOK, f)
+}
+
+// Delete a geofence.
+func (a *api) deleteGeofence(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+
+	deleted, err := a.cfg.Geofences.Delete(mux.Vars(r)["name"])
+	if err != nil {
+		writeStatus(w, http.StatusInternalServerError,
+			fmt.Sprintf("error deleting geofence: %s", err))
+		return
+	}
+	if !deleted {
+		writeStatus(w, http.StatusNotFound, "geofence not found")
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// annotate adds the geofences containing the located address to the
+// response, and counts a hit for each.  The lookup doesn't fail if the
+// geofences can't be read.
+func (a *api) annotate(resp *types.AddressResponse) {
+	if a.fences == nil {
+		return
+	}
+	names, err := a.fences.Match(resp.Coordinates)
+	if err != nil {
+		log.Printf("error matching geofences, skipped: %v", err)
+		return
+	}
+	resp.Geofences = names
+	for _, name := range names {
+		if err := a.store.Incr(types.GeofenceKey(name)); err != nil {
+			log.Printf("error counting hit of geofence '%s': %v", name, err)
+		}
+	}
+}
@@ -0,0 +1,111 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/gdotgordon/locator-demo/locator/geofence"
+	"github.com/gdotgordon/locator-demo/locator/store/storetest"
+	"github.com/gdotgordon/locator-demo/locator/types"
+	"github.com/gorilla/mux"
+)
+
+// fenceStore keeps the geofences in memory.
+type fenceStore struct {
+	fences  map[string]*types.Geofence
+	version int64
+}
+
+func (fs *fenceStore) Put(f *types.Geofence) (bool, error) {
+	_, ok := fs.fences[f.Name]
+	fs.fences[f.Name] = f
+	fs.version++
+	return !ok, nil
+}
+
+func (fs *fenceStore) Get(name string) (*types.Geofence, error) {
+	return fs.fences[name], nil
+}
+
+func (fs *fenceStore) Delete(name string) (bool, error) {
+	_, ok := fs.fences[name]
+	delete(fs.fences, name)
+	fs.version++
+	return ok, nil
+}
+
+func (fs *fenceStore) List() ([]*types.Geofence, error) {
+	var fences []*types.Geofence
+	for _, f := range fs.fences {
+		fences = append(fences, f)
+	}
+	return fences, nil
+}
+
+func (fs *fenceStore) Version() (int64, error) {
+	return fs.version, nil
+}
+
+func TestGeofences(t *testing.T) {
+	fs := &fenceStore{fences: make(map[string]*types.Geofence)}
+	hs := &storetest.Store{}
+	ap := &api{loc: &placeLocator{places: map[string]types.Coords{
+		"Silver Hill Rd": {X: -76.92691, Y: 38.846542}}}, store: hs,
+		cfg: Config{Geofences: fs}, fences: geofence.NewMatcher(fs)}
+
+	dc := `{"geometry": {"type": "Polygon", "coordinates": [[[-77.12, 38.79],
+		[-76.91, 38.79], [-76.91, 38.99], [-77.12, 38.99], [-77.12, 38.79]]]}}`
+	for _, test := range []struct {
+		method string
+		name   string
+		body   string
+		code   int
+	}{
+		{method: "PUT", name: "dc", body: dc, code: http.StatusCreated},
+		{method: "PUT", name: "dc", body: dc, code: http.StatusOK},
+		{method: "PUT", name: "moon", body: `{"geometry": {"type": "Point",
+			"coordinates": [0, 0]}}`, code: http.StatusBadRequest},
+		{method: "GET", name: "dc", code: http.StatusOK},
+		{method: "GET", name: "moon", code: http.StatusNotFound},
+		{method: "DELETE", name: "moon", code: http.StatusNotFound},
+	} {
+		req := httptest.NewRequest(test.method, "/v1/geofences/"+test.name,
+			strings.NewReader(test.body))
+		req.Header.Set("Content-Type", "application/json")
+		req = mux.SetURLVars(req, map[string]string{"name": test.name})
+		rec := httptest.NewRecorder()
+		switch test.method {
+		case "PUT":
+			ap.putGeofence(rec, req)
+		case "GET":
+			ap.getGeofence(rec, req)
+		case "DELETE":
+			ap.deleteGeofence(rec, req)
+		}
+		if rec.Code != test.code {
+			t.Fatalf("Expected status %d for %s %s, got %d: %s", test.code,
+				test.method, test.name, rec.Code, rec.Body.String())
+		}
+	}
+
+	b, _ := json.Marshal(types.AddressRequest{Street: "Silver Hill Rd"})
+	req := httptest.NewRequest("POST", "/v1/lookup", bytes.NewReader(b))
+	req.Header.Set("Content-Type", "application/json")
+	rec := httptest.NewRecorder()
+	ap.lookup(rec, req)
+	var resp types.AddressResponseV1
+	if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+		t.Fatalf("Got unexpected error: %v", err)
+	}
+	if !reflect.DeepEqual(resp.Geofences, []string{"dc"}) {
+		t.Fatalf("Expected geofences [dc], got %v", resp.Geofences)
+	}
+	if n := hs.Count(types.GeofenceKey("dc")); n != 1 {
+		t.Fatalf("Expected 1 hit of geofence 'dc', got %d", n)
+	}
+}
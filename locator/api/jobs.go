@@ -0,0 +1,66 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/gdotgordon/locator-demo/locator/jobs"
+	"github.com/gdotgordon/locator-demo/locator/types"
+	"github.com/gorilla/mux"
+)
+
+// Queue a job of lookups.  The body is a JSON array of address requests,
+// as for a batch lookup, and the response is the queued job, whose id is
+// used to follow it.
+func (a *api) createJob(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+
+	if !strings.HasPrefix(r.Header.Get("Content-type"), "application/json") {
+		writeStatus(w, http.StatusBadRequest, "bad request, expected JSON")
+		return
+	}
+	var reqs []types.AddressRequest
+	if err := json.NewDecoder(r.Body).Decode(&reqs); err != nil {
+		writeStatus(w, http.StatusBadRequest,
+			fmt.Sprintf("bad request, error: %s", err))
+		return
+	}
+	if len(reqs) == 0 {
+		writeStatus(w, http.StatusBadRequest, "bad request, no addresses")
+		return
+	}
+	if a.cfg.MaxJobSize > 0 && len(reqs) > a.cfg.MaxJobSize {
+		writeStatus(w, http.StatusRequestEntityTooLarge,
+			fmt.Sprintf("job exceeds %d addresses", a.cfg.MaxJobSize))
+		return
+	}
+
+	job, err := jobs.Submit(a.cfg.Jobs, a.store, reqs)
+	if err != nil {
+		writeStatus(w, http.StatusInternalServerError,
+			fmt.Sprintf("error queueing job: %s", err))
+		return
+	}
+	w.Header().Set("Location", "/v1/jobs/"+job.ID)
+	writeJSON(w, http.StatusAccepted, job)
+}
+
+// Get the status, progress and results so far of a job.
+func (a *api) getJob(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+
+	id := mux.Vars(r)["id"]
+	job, err := a.cfg.Jobs.Get(id)
+	if err != nil {
+		writeStatus(w, http.StatusInternalServerError,
+			fmt.Sprintf("error reading job: %s", err))
+		return
+	}
+	if job == nil {
+		writeStatus(w, http.StatusNotFound, "job not found")
+		return
+	}
+	writeJSON(w, http.StatusOK, job)
+}
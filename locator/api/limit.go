@@ -0,0 +1,122 @@
+package api
+
+import (
+	"log"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gdotgordon/locator-demo/locator/ratelimit"
+	"github.com/gdotgordon/locator-demo/locator/types"
+)
+
+// APIKeyHeader is the header identifying a client.  Clients without one
+// are identified by their remote address.
+const APIKeyHeader = "X-API-Key"
+
+// Reasons a request is rejected by the client limits, which label the
+// rejection events.
+const (
+	RejectRate     = "rate"
+	RejectInFlight = "inflight"
+)
+
+// ClientLimiter enforces the per-client limits.  Allow returns how long
+// the client must wait before its next request, zero meaning it may go
+// ahead now.  Acquire counts a request in flight, returning false if the
+// client has too many, Renew keeps the count from expiring while the
+// request runs, and Release uncounts it.
+type ClientLimiter interface {
+	Allow(client string) (time.Duration, error)
+	Acquire(client string) (bool, error)
+	Renew(client string) error
+	Release(client string) error
+}
+
+// renewInterval is how often a request in flight renews its count, well
+// within the time the count lasts.
+var renewInterval = ratelimit.InFlightTTL / 3
+
+// clientID identifies the client making the request.
+func clientID(r *http.Request) string {
+	if key := r.Header.Get(APIKeyHeader); key != "" {
+		return "key:" + key
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	return "addr:" + host
+}
+
+// limit wraps a handler with the per-client limits, if there are any.
+// Requests over a limit are rejected with a 429 and a Retry-After header.
+// If the limiter fails, say because Redis is down, requests are let
+// through rather than rejected.
+func (a *api) limit(hf http.HandlerFunc) http.HandlerFunc {
+	if a.cfg.Clients == nil {
+		return hf
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		client := clientID(r)
+		wait, err := a.cfg.Clients.Allow(client)
+		if err != nil {
+			log.Printf("error checking rate of client '%s', skipped: %v",
+				client, err)
+		} else if wait > 0 {
+			a.reject(w, RejectRate, wait)
+			return
+		}
+
+		ok, err := a.cfg.Clients.Acquire(client)
+		if err != nil {
+			log.Printf("error counting client '%s' in flight, skipped: %v",
+				client, err)
+			hf(w, r)
+			return
+		}
+		if !ok {
+			a.reject(w, RejectInFlight, time.Second)
+			return
+		}
+		stop, renewed := make(chan struct{}), make(chan struct{})
+		go func() {
+			defer close(renewed)
+			t := time.NewTicker(renewInterval)
+			defer t.Stop()
+			for {
+				select {
+				case <-stop:
+					return
+				case <-t.C:
+					if err := a.cfg.Clients.Renew(client); err != nil {
+						log.Printf("error renewing client '%s': %v", client,
+							err)
+					}
+				}
+			}
+		}()
+		defer func() {
+			close(stop)
+			<-renewed
+			if err := a.cfg.Clients.Release(client); err != nil {
+				log.Printf("error releasing client '%s': %v", client, err)
+			}
+		}()
+		hf(w, r)
+	}
+}
+
+// reject sends the 429 for a request over a limit, and the event for it.
+func (a *api) reject(w http.ResponseWriter, reason string,
+	retry time.Duration) {
+	if err := a.store.Incr(types.RejectedKey(reason)); err != nil {
+		log.Printf("error storing rejection event, skipped: %v", err)
+	}
+	secs := int(math.Ceil(retry.Seconds()))
+	w.Header().Set("Retry-After", strconv.Itoa(secs))
+	writeStatus(w, http.StatusTooManyRequests,
+		"too many requests, limit: "+reason)
+}
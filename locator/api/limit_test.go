@@ -0,0 +1,139 @@
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gdotgordon/locator-demo/locator/store/storetest"
+	"github.com/gdotgordon/locator-demo/locator/types"
+)
+
+// fakeLimits allows a fixed number of requests for each client, and one
+// in flight at a time.
+type fakeLimits struct {
+	mu       sync.Mutex
+	allowed  int
+	requests map[string]int
+	inFlight map[string]bool
+	renewals int
+}
+
+func (fl *fakeLimits) Allow(client string) (time.Duration, error) {
+	fl.mu.Lock()
+	defer fl.mu.Unlock()
+	fl.requests[client]++
+	if fl.requests[client] > fl.allowed {
+		return 1500 * time.Millisecond, nil
+	}
+	return 0, nil
+}
+
+func (fl *fakeLimits) Acquire(client string) (bool, error) {
+	fl.mu.Lock()
+	defer fl.mu.Unlock()
+	if fl.inFlight[client] {
+		return false, nil
+	}
+	fl.inFlight[client] = true
+	return true, nil
+}
+
+func (fl *fakeLimits) Renew(client string) error {
+	fl.mu.Lock()
+	defer fl.mu.Unlock()
+	fl.renewals++
+	return nil
+}
+
+func (fl *fakeLimits) renewed() int {
+	fl.mu.Lock()
+	defer fl.mu.Unlock()
+	return fl.renewals
+}
+
+func (fl *fakeLimits) Release(client string) error {
+	fl.mu.Lock()
+	defer fl.mu.Unlock()
+	fl.inFlight[client] = false
+	return nil
+}
+
+func TestClientLimits(t *testing.T) {
+	defer func(d time.Duration) { renewInterval = d }(renewInterval)
+	renewInterval = time.Millisecond
+
+	fl := &fakeLimits{allowed: 2, requests: make(map[string]int),
+		inFlight: make(map[string]bool)}
+	rs := &storetest.Store{}
+	a := &api{store: rs, cfg: Config{Clients: fl}}
+
+	release := make(chan struct{})
+	h := a.limit(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/slow" {
+			<-release
+		}
+		w.WriteHeader(http.StatusOK)
+	})
+	do := func(path, key, addr string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodPost, path, nil)
+		r.RemoteAddr = addr
+		if key != "" {
+			r.Header.Set(APIKeyHeader, key)
+		}
+		w := httptest.NewRecorder()
+		h(w, r)
+		return w
+	}
+
+	// The slow request holds the one in-flight slot of client "a".
+	done := make(chan struct{})
+	go func() {
+		do("/slow", "a", "10.0.0.1:1234")
+		close(done)
+	}()
+	for {
+		fl.mu.Lock()
+		busy := fl.inFlight["key:a"]
+		fl.mu.Unlock()
+		if busy {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+	if w := do("/", "a", "10.0.0.2:1234"); w.Code != http.StatusTooManyRequests ||
+		w.Header().Get("Retry-After") != "1" {
+		t.Fatalf("Expected 429 for in flight, got %d", w.Code)
+	}
+	// The slow request keeps renewing its count until it is done.
+	for fl.renewed() < 2 {
+		time.Sleep(time.Millisecond)
+	}
+	close(release)
+	<-done
+	n := fl.renewed()
+	time.Sleep(5 * time.Millisecond)
+	if fl.renewed() != n {
+		t.Fatalf("Expected no renewals after release")
+	}
+
+	// Client "a" has used up its requests, while the same address
+	// without a key is a different client.
+	if w := do("/", "a", "10.0.0.1:1234"); w.Code != http.StatusTooManyRequests ||
+		w.Header().Get("Retry-After") != "2" {
+		t.Fatalf("Expected 429 with Retry-After 2, got %d, '%s'", w.Code,
+			w.Header().Get("Retry-After"))
+	}
+	if w := do("/", "", "10.0.0.1:1234"); w.Code != http.StatusOK {
+		t.Fatalf("Expected 200 for address client, got %d", w.Code)
+	}
+
+	inFlight := rs.Count(types.RejectedKey(RejectInFlight))
+	rate := rs.Count(types.RejectedKey(RejectRate))
+	if inFlight != 1 || rate != 1 {
+		t.Fatalf("Expected 1 rejection of each kind, got %d and %d",
+			inFlight, rate)
+	}
+}
@@ -0,0 +1,81 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/gdotgordon/locator-demo/locator/geoindex"
+	"github.com/gdotgordon/locator-demo/locator/types"
+)
+
+// Find the located addresses within a radius of coordinates, or of an
+// address, which is looked up first.  The response is a page of them,
+// nearest first.
+func (a *api) nearby(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+
+	if !strings.HasPrefix(r.Header.Get("Content-type"), "application/json") {
+		writeStatus(w, http.StatusBadRequest, "bad request, expected JSON")
+		return
+	}
+	var req types.NearbyRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeStatus(w, http.StatusBadRequest,
+			fmt.Sprintf("bad request, error: %s", err))
+		return
+	}
+	if (req.Coordinates == nil) == (req.Address == nil) {
+		writeStatus(w, http.StatusBadRequest,
+			"bad request, expected either coordinates or an address")
+		return
+	}
+
+	q := geoindex.Query{Radius: req.Radius, Unit: req.Unit,
+		Offset: req.Offset, Limit: req.Limit}
+	if req.Coordinates != nil {
+		q.Center = *req.Coordinates
+	} else {
+		resp, err := a.loc.Locate(r.Context(), *req.Address)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		if resp.MatchCount == 0 {
+			writeStatus(w, http.StatusNotFound, "address not located")
+			return
+		}
+		q.Center = resp.Coordinates
+	}
+	a.search(w, q)
+}
+
+// Find the located addresses within a bounding box.  The response is a
+// page of them, nearest the center of the box first.
+func (a *api) within(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+
+	if !strings.HasPrefix(r.Header.Get("Content-type"), "application/json") {
+		writeStatus(w, http.StatusBadRequest, "bad request, expected JSON")
+		return
+	}
+	var req types.WithinRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeStatus(w, http.StatusBadRequest,
+			fmt.Sprintf("bad request, error: %s", err))
+		return
+	}
+	a.search(w, geoindex.Query{Box: &geoindex.Box{Min: req.Min, Max: req.Max},
+		Unit: req.Unit, Offset: req.Offset, Limit: req.Limit})
+}
+
+// search runs the query against the index, and writes the results.
+func (a *api) search(w http.ResponseWriter, q geoindex.Query) {
+	resp, err := a.cfg.Geo.Index.Search(q)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, resp)
+}
@@ -0,0 +1,61 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/gdotgordon/locator-demo/locator/geoerr"
+	"github.com/gdotgordon/locator-demo/locator/types"
+	"github.com/gdotgordon/locator-demo/locator/workflow"
+	"github.com/gorilla/mux"
+)
+
+// Queue a workflow.  The body is the steps of the workflow, and the
+// response is the queued workflow, whose id is used to follow it.
+func (a *api) createWorkflow(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+
+	if !strings.HasPrefix(r.Header.Get("Content-type"), "application/json") {
+		writeStatus(w, http.StatusBadRequest, "bad request, expected JSON")
+		return
+	}
+	var req types.WorkflowRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeStatus(w, http.StatusBadRequest,
+			fmt.Sprintf("bad request, error: %s", err))
+		return
+	}
+
+	wf, err := workflow.Submit(a.cfg.Workflows, req, a.cfg.WebhookHosts)
+	if geoerr.ClassOf(err) == geoerr.Validation {
+		writeError(w, err)
+		return
+	}
+	if err != nil {
+		writeStatus(w, http.StatusInternalServerError,
+			fmt.Sprintf("error queueing workflow: %s", err))
+		return
+	}
+	w.Header().Set("Location", "/v1/workflows/"+wf.ID)
+	writeJSON(w, http.StatusAccepted, wf)
+}
+
+// Get the status of a workflow, and the state and output of its steps.
+func (a *api) getWorkflow(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+
+	id := mux.Vars(r)["id"]
+	wf, err := a.cfg.Workflows.Get(id)
+	if err != nil {
+		writeStatus(w, http.StatusInternalServerError,
+			fmt.Sprintf("error reading workflow: %s", err))
+		return
+	}
+	if wf == nil {
+		writeStatus(w, http.StatusNotFound, "workflow not found")
+		return
+	}
+	writeJSON(w, http.StatusOK, wf)
+}
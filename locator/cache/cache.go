@@ -0,0 +1,93 @@
+// Package cache defines the cache of geocoding results, keyed on a
+// normalized form of the address request, and implements it in Redis.
+// The cache keys are outside of the "locator:" keyspace, so caching
+// doesn't generate events for the analyzer.
+package cache
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"strings"
+	"time"
+
+	"github.com/gdotgordon/locator-demo/locator/normalize"
+	"github.com/gdotgordon/locator-demo/locator/types"
+	"github.com/go-redis/redis"
+)
+
+// KeyPrefix is the prefix of the Redis cache keys.
+const KeyPrefix = "geocache:"
+
+// Cache stores geocoding results.  Get returns false, and no error, for
+// a key that is not cached.
+type Cache interface {
+	Get(key string) (*types.AddressResponse, bool, error)
+	Set(key string, resp *types.AddressResponse, ttl time.Duration) error
+}
+
+// RedisCache implements the Cache interface for the Redis client.  The
+// responses are stored as JSON, with Redis taking care of the expiry.
+type RedisCache struct {
+	cli *redis.Client
+}
+
+// NewRedisCache creates a cache using the Redis client.
+func NewRedisCache(cli *redis.Client) *RedisCache {
+	return &RedisCache{cli: cli}
+}
+
+// Get looks up the cached response for the key.
+func (rc *RedisCache) Get(key string) (*types.AddressResponse, bool, error) {
+	b, err := rc.cli.Get(KeyPrefix + key).Bytes()
+	if err == redis.Nil {
+		return nil, false, nil
+	}
+	if err != nil {
+		return nil, false, err
+	}
+	var resp types.AddressResponse
+	if err := json.Unmarshal(b, &resp); err != nil {
+		return nil, false, err
+	}
+	return &resp, true, nil
+}
+
+// Set caches the response for the key, for the ttl.
+func (rc *RedisCache) Set(key string, resp *types.AddressResponse,
+	ttl time.Duration) error {
+	b, err := json.Marshal(resp)
+	if err != nil {
+		return err
+	}
+	return rc.cli.Set(KeyPrefix+key, b, ttl).Err()
+}
+
+// Key returns the cache key for the request.  The address is normalized
+// first, so that different spellings of the same address share a key.
+// The options that change the response are part of the key too.  The
+// result is hashed to keep the keys short.
+func Key(req types.AddressRequest) string {
+	req, _ = normalize.Request(req)
+	parts := []string{
+		req.StructureNumber,
+		req.Street,
+		req.City,
+		req.State,
+		req.Zip,
+		req.OneLine,
+		flag(req.PreferInputMatch),
+		flag(req.Enrich),
+		req.Benchmark,
+		req.Vintage,
+	}
+	sum := sha1.Sum([]byte(strings.Join(parts, "|")))
+	return hex.EncodeToString(sum[:])
+}
+
+func flag(b bool) string {
+	if b {
+		return "1"
+	}
+	return "0"
+}
@@ -0,0 +1,50 @@
+package cache
+
+import (
+	"testing"
+
+	"github.com/gdotgordon/locator-demo/locator/types"
+)
+
+func TestKey(t *testing.T) {
+	base := types.AddressRequest{StructureNumber: "4600", Street: "Silver Hill Rd",
+		City: "Suitland", State: "MD", Zip: "20746"}
+	for _, test := range []struct {
+		rq   types.AddressRequest
+		same bool
+	}{
+		{
+			rq: types.AddressRequest{StructureNumber: " 4600", Street: "SILVER  HILL RD.",
+				City: "suitland", State: "md", Zip: "20746"},
+			same: true,
+		},
+		{
+			rq: types.AddressRequest{StructureNumber: "4600", Street: "Silver Hill, Rd",
+				City: "Suitland", State: "MD", Zip: "20746"},
+			same: true,
+		},
+		{
+			rq: types.AddressRequest{StructureNumber: "4600", Street: "silver hill road",
+				City: "Suitland", State: "Maryland", Zip: "20746-1234"},
+			same: true,
+		},
+		{
+			rq: types.AddressRequest{StructureNumber: "4602", Street: "Silver Hill Rd",
+				City: "Suitland", State: "MD", Zip: "20746"},
+			same: false,
+		},
+		{
+			rq: types.AddressRequest{StructureNumber: "4600", Street: "Silver Hill Rd",
+				City: "Suitland", State: "MD", Zip: "20746", Enrich: true},
+			same: false,
+		},
+		{
+			rq:   types.AddressRequest{OneLine: "4600 Silver Hill Rd Suitland MD 20746"},
+			same: false,
+		},
+	} {
+		if same := Key(test.rq) == Key(base); same != test.same {
+			t.Fatalf("Expected same key %t for %+v", test.same, test.rq)
+		}
+	}
+}
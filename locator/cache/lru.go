@@ -0,0 +1,104 @@
+package cache
+
+import (
+	"container/list"
+	"sync"
+	"time"
+
+	"github.com/gdotgordon/locator-demo/locator/types"
+)
+
+// LRU is an in-memory Cache holding at most a fixed number of responses.
+// When it is full, the least recently used response is evicted to make
+// room.  Expired responses are dropped when they are next looked up.
+type LRU struct {
+	mu      sync.Mutex
+	size    int
+	ll      *list.List
+	items   map[string]*list.Element
+	onEvict func()
+	now     func() time.Time
+}
+
+type lruEntry struct {
+	key     string
+	resp    types.AddressResponse
+	expires time.Time
+}
+
+// NewLRU creates a cache of the given size.  If onEvict is not nil, it is
+// called for each response evicted to make room, outside of the cache
+// lock.
+func NewLRU(size int, onEvict func()) *LRU {
+	return &LRU{size: size, ll: list.New(),
+		items: make(map[string]*list.Element), onEvict: onEvict,
+		now: time.Now}
+}
+
+// Get looks up the cached response for the key.  The caller gets its own
+// copy of the response.
+func (c *LRU) Get(key string) (*types.AddressResponse, bool, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	el, ok := c.items[key]
+	if !ok {
+		return nil, false, nil
+	}
+	ent := el.Value.(*lruEntry)
+	if !c.now().Before(ent.expires) {
+		c.ll.Remove(el)
+		delete(c.items, key)
+		return nil, false, nil
+	}
+	c.ll.MoveToFront(el)
+	resp := ent.resp
+	return &resp, true, nil
+}
+
+// Set caches a copy of the response for the key, for the ttl.
+func (c *LRU) Set(key string, resp *types.AddressResponse,
+	ttl time.Duration) error {
+	evicted := c.set(key, resp, ttl)
+	if c.onEvict != nil {
+		for i := 0; i < evicted; i++ {
+			c.onEvict()
+		}
+	}
+	return nil
+}
+
+// set does the work of Set under the lock, and returns the number of
+// responses evicted.
+func (c *LRU) set(key string, resp *types.AddressResponse,
+	ttl time.Duration) int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.size <= 0 {
+		return 0
+	}
+	expires := c.now().Add(ttl)
+	if el, ok := c.items[key]; ok {
+		ent := el.Value.(*lruEntry)
+		ent.resp, ent.expires = *resp, expires
+		c.ll.MoveToFront(el)
+		return 0
+	}
+	c.items[key] = c.ll.PushFront(&lruEntry{key: key, resp: *resp,
+		expires: expires})
+
+	var evicted int
+	for c.ll.Len() > c.size {
+		el := c.ll.Back()
+		c.ll.Remove(el)
+		delete(c.items, el.Value.(*lruEntry).key)
+		evicted++
+	}
+	return evicted
+}
+
+// Len returns the number of responses in the cache.
+func (c *LRU) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.ll.Len()
+}
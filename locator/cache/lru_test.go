@@ -0,0 +1,50 @@
+package cache
+
+import (
+	"testing"
+	"time"
+
+	"github.com/gdotgordon/locator-demo/locator/types"
+)
+
+func TestLRU(t *testing.T) {
+	var evictions int
+	c := NewLRU(2, func() { evictions++ })
+	now := time.Now()
+	c.now = func() time.Time { return now }
+
+	c.Set("a", &types.AddressResponse{Zip: "1"}, time.Hour)
+	c.Set("b", &types.AddressResponse{Zip: "2"}, time.Minute)
+	if _, ok, _ := c.Get("a"); !ok {
+		t.Fatalf("Expected 'a' to be cached")
+	}
+	// "b" is now the least recently used.
+	c.Set("c", &types.AddressResponse{Zip: "3"}, time.Hour)
+	if evictions != 1 {
+		t.Fatalf("Expected 1 eviction, got %d", evictions)
+	}
+	if _, ok, _ := c.Get("b"); ok {
+		t.Fatalf("Expected 'b' to be evicted")
+	}
+
+	resp, ok, _ := c.Get("a")
+	if !ok || resp.Zip != "1" {
+		t.Fatalf("Expected zip '1' for 'a', got %+v", resp)
+	}
+	resp.Zip = "changed"
+	if resp, _, _ := c.Get("a"); resp.Zip != "1" {
+		t.Fatalf("Expected cached copy to be unchanged, got '%s'", resp.Zip)
+	}
+
+	now = now.Add(2 * time.Hour)
+	if _, ok, _ := c.Get("c"); ok {
+		t.Fatalf("Expected 'c' to be expired")
+	}
+	if c.Len() != 1 {
+		t.Fatalf("Expected 1 cached response, got %d", c.Len())
+	}
+	if evictions != 1 {
+		t.Fatalf("Expected expiry not to count as eviction, got %d",
+			evictions)
+	}
+}
@@ -0,0 +1,136 @@
+// Package geo computes the great-circle distance between coordinates,
+// both on a sphere with the haversine formula, which is fast, and on the
+// WGS-84 ellipsoid with Vincenty's formulae, which is accurate to within
+// a millimeter, and converts distances between units.
+package geo
+
+import (
+	"errors"
+	"math"
+
+	"github.com/gdotgordon/locator-demo/locator/geoerr"
+	"github.com/gdotgordon/locator-demo/locator/types"
+)
+
+const (
+	// EarthRadius is the mean radius of the Earth, in meters.
+	EarthRadius = 6371008.8
+
+	// DefaultUnit is the unit of distance when none is given.
+	DefaultUnit = "km"
+
+	// The WGS-84 ellipsoid: the semi-major axis, in meters, the
+	// flattening, and the semi-minor axis.
+	wgs84A = 6378137.0
+	wgs84F = 1 / 298.257223563
+	wgs84B = (1 - wgs84F) * wgs84A
+
+	// maxIterations bounds Vincenty's iteration, which converges to
+	// within the tolerance in a handful of steps, except near antipodes.
+	maxIterations = 200
+	tolerance     = 1e-12
+)
+
+// ErrNoConvergence is returned by Vincenty for nearly antipodal points,
+// where its iteration doesn't converge.
+var ErrNoConvergence = errors.New("Vincenty's formulae did not converge")
+
+// meters are the lengths of the units of distance.
+var meters = map[string]float64{
+	"m":   1,
+	"km":  1000,
+	"mi":  1609.344,
+	"ft":  0.3048,
+	"nmi": 1852,
+}
+
+// UnitLength returns the length of the unit in meters.
+func UnitLength(unit string) (float64, error) {
+	m, ok := meters[unit]
+	if !ok {
+		return 0, geoerr.Errorf(geoerr.Validation,
+			"Unknown unit '%s', expected m, km, mi, ft or nmi", unit)
+	}
+	return m, nil
+}
+
+// Valid says whether the coordinates are a longitude (X) and latitude (Y)
+// in degrees.
+func Valid(c types.Coords) bool {
+	return c.X >= -180 && c.X <= 180 && c.Y >= -90 && c.Y <= 90
+}
+
+func radians(deg float64) float64 {
+	return deg * math.Pi / 180
+}
+
+// Haversine returns the great-circle distance between the points, in
+// meters, on a sphere of the Earth's mean radius.
+func Haversine(a, b types.Coords) float64 {
+	return GreatCircle(a, b, EarthRadius)
+}
+
+// GreatCircle returns the haversine distance between the points on a
+// sphere of the radius, in the unit of the radius.
+func GreatCircle(a, b types.Coords, radius float64) float64 {
+	lat1, lat2 := radians(a.Y), radians(b.Y)
+	dlat, dlon := lat2-lat1, radians(b.X-a.X)
+	h := math.Pow(math.Sin(dlat/2), 2) +
+		math.Cos(lat1)*math.Cos(lat2)*math.Pow(math.Sin(dlon/2), 2)
+	return 2 * radius * math.Asin(math.Min(1, math.Sqrt(h)))
+}
+
+// Vincenty returns the distance between the points, in meters, on the
+// WGS-84 ellipsoid, using Vincenty's inverse formula.  It fails with
+// ErrNoConvergence for nearly antipodal points.
+func Vincenty(a, b types.Coords) (float64, error) {
+	L := math.Remainder(radians(b.X-a.X), 2*math.Pi)
+	u1 := math.Atan((1 - wgs84F) * math.Tan(radians(a.Y)))
+	u2 := math.Atan((1 - wgs84F) * math.Tan(radians(b.Y)))
+	sinU1, cosU1 := math.Sincos(u1)
+	sinU2, cosU2 := math.Sincos(u2)
+
+	lambda := L
+	var sinSigma, cosSigma, sigma, cos2Alpha, cos2SigmaM float64
+	converged := false
+	for i := 0; i < maxIterations; i++ {
+		sinLambda, cosLambda := math.Sincos(lambda)
+		sinSigma = math.Hypot(cosU2*sinLambda,
+			cosU1*sinU2-sinU1*cosU2*cosLambda)
+		if sinSigma == 0 {
+			// The points are the same.
+			return 0, nil
+		}
+		cosSigma = sinU1*sinU2 + cosU1*cosU2*cosLambda
+		sigma = math.Atan2(sinSigma, cosSigma)
+		sinAlpha := cosU1 * cosU2 * sinLambda / sinSigma
+		cos2Alpha = 1 - sinAlpha*sinAlpha
+		cos2SigmaM = 0
+		if cos2Alpha != 0 {
+			// Off the equator.
+			cos2SigmaM = cosSigma - 2*sinU1*sinU2/cos2Alpha
+		}
+		c := wgs84F / 16 * cos2Alpha * (4 + wgs84F*(4-3*cos2Alpha))
+		prev := lambda
+		lambda = L + (1-c)*wgs84F*sinAlpha*(sigma+c*sinSigma*
+			(cos2SigmaM+c*cosSigma*(-1+2*cos2SigmaM*cos2SigmaM)))
+		if math.Abs(lambda) > math.Pi {
+			break
+		}
+		if math.Abs(lambda-prev) < tolerance {
+			converged = true
+			break
+		}
+	}
+	if !converged {
+		return 0, ErrNoConvergence
+	}
+
+	uSq := cos2Alpha * (wgs84A*wgs84A - wgs84B*wgs84B) / (wgs84B * wgs84B)
+	A := 1 + uSq/16384*(4096+uSq*(-768+uSq*(320-175*uSq)))
+	B := uSq / 1024 * (256 + uSq*(-128+uSq*(74-47*uSq)))
+	deltaSigma := B * sinSigma * (cos2SigmaM + B/4*(cosSigma*
+		(-1+2*cos2SigmaM*cos2SigmaM)-B/6*cos2SigmaM*
+		(-3+4*sinSigma*sinSigma)*(-3+4*cos2SigmaM*cos2SigmaM)))
+	return wgs84B * A * (sigma - deltaSigma), nil
+}
@@ -0,0 +1,59 @@
+package geo
+
+import (
+	"math"
+	"testing"
+
+	"github.com/gdotgordon/locator-demo/locator/types"
+)
+
+func TestDistance(t *testing.T) {
+	for _, test := range []struct {
+		a, b      types.Coords
+		haversine float64
+		vincenty  float64
+	}{
+		{a: types.Coords{X: 0, Y: 0}, b: types.Coords{X: 0, Y: 0}},
+		// One degree along the equator.
+		{a: types.Coords{X: 0, Y: 0}, b: types.Coords{X: 1, Y: 0},
+			haversine: 111195.080, vincenty: 111319.491},
+		// Flinders Peak to Buninyong, the example in Vincenty's paper.
+		{a: types.Coords{X: 144.424867889, Y: -37.951033417},
+			b: types.Coords{X: 143.926495528, Y: -37.652821139},
+			haversine: 54925.508, vincenty: 54972.271},
+		// Across the antimeridian.
+		{a: types.Coords{X: 179.5, Y: 0}, b: types.Coords{X: -179.5, Y: 0},
+			haversine: 111195.080, vincenty: 111319.491},
+	} {
+		if h := Haversine(test.a, test.b); math.Abs(h-test.haversine) > 0.01 {
+			t.Fatalf("Expected haversine %.3f for %v to %v, got %.3f",
+				test.haversine, test.a, test.b, h)
+		}
+		v, err := Vincenty(test.a, test.b)
+		if err != nil {
+			t.Fatalf("Got unexpected error: %v", err)
+		}
+		if math.Abs(v-test.vincenty) > 0.001 {
+			t.Fatalf("Expected Vincenty %.3f for %v to %v, got %.3f",
+				test.vincenty, test.a, test.b, v)
+		}
+	}
+
+	if _, err := Vincenty(types.Coords{X: 0, Y: 0},
+		types.Coords{X: 179.7, Y: 0.5}); err != ErrNoConvergence {
+		t.Fatalf("Expected no convergence for antipodes, got %v", err)
+	}
+}
+
+func TestUnitLength(t *testing.T) {
+	for unit, exp := range map[string]float64{"m": 1, "km": 1000,
+		"mi": 1609.344, "ft": 0.3048, "nmi": 1852} {
+		if m, err := UnitLength(unit); err != nil || m != exp {
+			t.Fatalf("Expected %v for '%s', got %v, %v", exp, unit, m, err)
+		}
+	}
+	if _, err := UnitLength("league"); err == nil ||
+		err.Error() != "Unknown unit 'league', expected m, km, mi, ft or nmi" {
+		t.Fatalf("Expected unknown unit error, got %v", err)
+	}
+}
@@ -0,0 +1,144 @@
+// Package geoerr classifies the errors of the locator, so that each class
+// of failure can be mapped to the right HTTP status, and counted in its
+// own stats.  Errors are classified where they are made, by wrapping them
+// in an Error, and ClassOf falls back on recognizing the well known
+// errors of the standard library.
+package geoerr
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+
+	"github.com/gdotgordon/locator-demo/locator/ratelimit"
+)
+
+// The error classes.
+const (
+	// Validation is a request that can't be looked up as it stands.
+	Validation = "validation"
+
+	// UpstreamTimeout is a geocoding service that didn't answer in time.
+	UpstreamTimeout = "upstream_timeout"
+
+	// UpstreamStatus is a geocoding service that answered with an error
+	// status.
+	UpstreamStatus = "upstream_status"
+
+	// UpstreamUnavailable is a geocoding service that couldn't be reached,
+	// or that the circuit breaker has given up on for now.
+	UpstreamUnavailable = "upstream_unavailable"
+
+	// BadPayload is a geocoding service answer that couldn't be parsed.
+	BadPayload = "bad_payload"
+
+	// NotFound is an address or coordinates that weren't located.
+	NotFound = "not_found"
+
+	// Throttled is a call rejected by the rate limits.
+	Throttled = "throttled"
+
+	// Unsupported is an operation the provider doesn't do.
+	Unsupported = "unsupported"
+
+	// Canceled is a call cut short because the client went away, or the
+	// server is shutting down.  It is an outcome of its own, rather than
+	// a failure of the service.
+	Canceled = "canceled"
+
+	// Internal is anything else.
+	Internal = "internal"
+)
+
+// statuses are the HTTP statuses of the classes.  A client that went away
+// never sees the status for Canceled, so it is for those still waiting
+// when the server shuts down.
+var statuses = map[string]int{
+	Validation:          http.StatusBadRequest,
+	UpstreamTimeout:     http.StatusGatewayTimeout,
+	UpstreamStatus:      http.StatusBadGateway,
+	UpstreamUnavailable: http.StatusServiceUnavailable,
+	BadPayload:          http.StatusBadGateway,
+	NotFound:            http.StatusNotFound,
+	Throttled:           http.StatusTooManyRequests,
+	Unsupported:         http.StatusNotImplemented,
+	Canceled:            http.StatusServiceUnavailable,
+	Internal:            http.StatusInternalServerError,
+}
+
+// Error is an error of a known class.  Status is the HTTP status returned
+// by the geocoding service, for the UpstreamStatus class.
+type Error struct {
+	Class  string
+	Status int
+	Err    error
+}
+
+func (e *Error) Error() string {
+	return e.Err.Error()
+}
+
+// Unwrap returns the underlying error.
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// New creates an error of the class with the message.
+func New(class, msg string) *Error {
+	return &Error{Class: class, Err: errors.New(msg)}
+}
+
+// Wrap classifies the error.
+func Wrap(class string, err error) *Error {
+	return &Error{Class: class, Err: err}
+}
+
+// Errorf creates an error of the class with the formatted message.
+func Errorf(class, format string, args ...interface{}) *Error {
+	return &Error{Class: class, Err: fmt.Errorf(format, args...)}
+}
+
+// FromStatus creates the error for an unexpected HTTP status from a
+// geocoding service.
+func FromStatus(code int) *Error {
+	return &Error{Class: UpstreamStatus, Status: code,
+		Err: fmt.Errorf("HTTP status %d : %s", code, http.StatusText(code))}
+}
+
+// ClassOf returns the class of the error, or "" for no error.
+func ClassOf(err error) string {
+	if err == nil {
+		return ""
+	}
+	var ge *Error
+	if errors.As(err, &ge) {
+		return ge.Class
+	}
+	if err == ratelimit.ErrThrottled {
+		return Throttled
+	}
+	if errors.Is(err, context.Canceled) {
+		return Canceled
+	}
+	if errors.Is(err, context.DeadlineExceeded) {
+		return UpstreamTimeout
+	}
+	var ne net.Error
+	if errors.As(err, &ne) {
+		if ne.Timeout() {
+			return UpstreamTimeout
+		}
+		return UpstreamUnavailable
+	}
+	return Internal
+}
+
+// HTTPStatus returns the HTTP status for the error.
+func HTTPStatus(err error) int {
+	if code, ok := statuses[ClassOf(err)]; ok {
+		return code
+	}
+	return http.StatusInternalServerError
+}
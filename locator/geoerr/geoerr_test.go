@@ -0,0 +1,55 @@
+package geoerr
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"testing"
+
+	"github.com/gdotgordon/locator-demo/locator/ratelimit"
+)
+
+func TestClassOf(t *testing.T) {
+	for _, test := range []struct {
+		err    error
+		class  string
+		status int
+	}{
+		{err: nil, class: "", status: http.StatusInternalServerError},
+		{err: New(Validation, "Street is required"), class: Validation,
+			status: http.StatusBadRequest},
+		{err: fmt.Errorf("lookup: %w", FromStatus(503)), class: UpstreamStatus,
+			status: http.StatusBadGateway},
+		{err: Errorf(BadPayload, "Invalid JSON"), class: BadPayload,
+			status: http.StatusBadGateway},
+		{err: ratelimit.ErrThrottled, class: Throttled,
+			status: http.StatusTooManyRequests},
+		{err: context.DeadlineExceeded, class: UpstreamTimeout,
+			status: http.StatusGatewayTimeout},
+		{err: &url.Error{Op: "Get", URL: "http://x", Err: context.Canceled},
+			class: Canceled, status: http.StatusServiceUnavailable},
+		{err: &net.OpError{Op: "dial", Err: errors.New("refused")},
+			class: UpstreamUnavailable, status: http.StatusServiceUnavailable},
+		{err: errors.New("something else"), class: Internal,
+			status: http.StatusInternalServerError},
+	} {
+		if class := ClassOf(test.err); class != test.class {
+			t.Fatalf("Expected class '%s' for %v, got '%s'", test.class,
+				test.err, class)
+		}
+		if test.err == nil {
+			continue
+		}
+		if status := HTTPStatus(test.err); status != test.status {
+			t.Fatalf("Expected status %d for %v, got %d", test.status,
+				test.err, status)
+		}
+	}
+
+	if msg := FromStatus(502).Error(); msg != "HTTP status 502 : Bad Gateway" {
+		t.Fatalf("Expected status message, got '%s'", msg)
+	}
+}
@@ -0,0 +1,223 @@
+// Package geofence keeps the named areas, or geofences, that the located
+// addresses are checked against, and finds the ones containing a point.
+// The geofences are stored in Redis as GeoJSON, outside of the "locator:"
+// keyspace, so editing them doesn't generate events for the analyzer.
+package geofence
+
+import (
+	"encoding/json"
+	"log"
+	"regexp"
+	"sort"
+	"sync"
+
+	"github.com/gdotgordon/locator-demo/locator/geo"
+	"github.com/gdotgordon/locator-demo/locator/geoerr"
+	"github.com/gdotgordon/locator-demo/locator/types"
+)
+
+// The geometry types a geofence may have.
+const (
+	Polygon      = "Polygon"
+	MultiPolygon = "MultiPolygon"
+)
+
+// validName is what a geofence may be named.  The name is part of the key
+// of its stats, so it can't have a ':'.
+var validName = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9 _.-]{0,63}$`)
+
+// ring is a closed ring of [longitude, latitude] positions, and polygon
+// an outer ring followed by the rings of any holes in it.
+type ring [][2]float64
+type polygon []ring
+
+// fence is a geofence parsed for finding the points in it.  The bounding
+// box rules out most points without looking at the polygons.
+type fence struct {
+	name  string
+	polys []polygon
+	min   [2]float64
+	max   [2]float64
+}
+
+// Validate checks the name and geometry of the geofence.
+func Validate(f *types.Geofence) error {
+	_, err := compile(f)
+	return err
+}
+
+// compile parses the geofence.
+func compile(f *types.Geofence) (*fence, error) {
+	if !validName.MatchString(f.Name) {
+		return nil, geoerr.Errorf(geoerr.Validation,
+			"Invalid geofence name '%s', expected up to 64 letters, digits, "+
+				"spaces, '.', '_' or '-'", f.Name)
+	}
+	var polys []polygon
+	var err error
+	switch f.Geometry.Type {
+	case Polygon:
+		var p polygon
+		err = json.Unmarshal(f.Geometry.Coordinates, &p)
+		polys = []polygon{p}
+	case MultiPolygon:
+		err = json.Unmarshal(f.Geometry.Coordinates, &polys)
+	default:
+		return nil, geoerr.Errorf(geoerr.Validation,
+			"Unsupported geometry type '%s', expected Polygon or MultiPolygon",
+			f.Geometry.Type)
+	}
+	if err != nil {
+		return nil, geoerr.Errorf(geoerr.Validation,
+			"Invalid %s coordinates: %v", f.Geometry.Type, err)
+	}
+	if len(polys) == 0 {
+		return nil, geoerr.New(geoerr.Validation, "Geometry has no polygons")
+	}
+
+	fc := &fence{name: f.Name, polys: polys,
+		min: [2]float64{180, 90}, max: [2]float64{-180, -90}}
+	for _, p := range polys {
+		if len(p) == 0 {
+			return nil, geoerr.New(geoerr.Validation, "Polygon has no rings")
+		}
+		for _, r := range p {
+			if err := checkRing(r); err != nil {
+				return nil, err
+			}
+		}
+		for _, pos := range p[0] {
+			for i := 0; i < 2; i++ {
+				if pos[i] < fc.min[i] {
+					fc.min[i] = pos[i]
+				}
+				if pos[i] > fc.max[i] {
+					fc.max[i] = pos[i]
+				}
+			}
+		}
+	}
+	return fc, nil
+}
+
+// checkRing checks that the ring is closed, and its positions are valid.
+func checkRing(r ring) error {
+	if len(r) < 4 {
+		return geoerr.New(geoerr.Validation,
+			"Polygon ring has fewer than 4 positions")
+	}
+	if r[0] != r[len(r)-1] {
+		return geoerr.New(geoerr.Validation,
+			"Polygon ring is not closed, the first and last positions differ")
+	}
+	for _, pos := range r {
+		if !geo.Valid(types.Coords{X: pos[0], Y: pos[1]}) {
+			return geoerr.Errorf(geoerr.Validation,
+				"Position [%v, %v] is out of range", pos[0], pos[1])
+		}
+	}
+	return nil
+}
+
+// contains says whether the point is in the geofence.
+func (fc *fence) contains(c types.Coords) bool {
+	if c.X < fc.min[0] || c.X > fc.max[0] || c.Y < fc.min[1] ||
+		c.Y > fc.max[1] {
+		return false
+	}
+	for _, p := range fc.polys {
+		if p.contains(c) {
+			return true
+		}
+	}
+	return false
+}
+
+// contains says whether the point is inside the outer ring of the
+// polygon, and not in any of its holes.
+func (p polygon) contains(c types.Coords) bool {
+	if !p[0].contains(c) {
+		return false
+	}
+	for _, hole := range p[1:] {
+		if hole.contains(c) {
+			return false
+		}
+	}
+	return true
+}
+
+// contains casts a ray east from the point, and counts the edges of the
+// ring it crosses, an odd number meaning the point is inside.
+func (r ring) contains(c types.Coords) bool {
+	in := false
+	for i, j := 0, len(r)-1; i < len(r); j, i = i, i+1 {
+		xi, yi := r[i][0], r[i][1]
+		xj, yj := r[j][0], r[j][1]
+		if (yi > c.Y) != (yj > c.Y) &&
+			c.X < (xj-xi)*(c.Y-yi)/(yj-yi)+xi {
+			in = !in
+		}
+	}
+	return in
+}
+
+// Matcher finds the geofences containing a point.  It keeps the parsed
+// geofences, and reloads them when the store says they have changed, so
+// every replica of the locator sees the edits made through any of them.
+type Matcher struct {
+	store   Store
+	mu      sync.Mutex
+	version int64
+	loaded  bool
+	fences  []*fence
+}
+
+// NewMatcher creates a matcher for the geofences in the store.
+func NewMatcher(st Store) *Matcher {
+	return &Matcher{store: st}
+}
+
+// Match returns the sorted names of the geofences containing the point.
+func (m *Matcher) Match(c types.Coords) ([]string, error) {
+	fences, err := m.load()
+	if err != nil {
+		return nil, err
+	}
+	var names []string
+	for _, fc := range fences {
+		if fc.contains(c) {
+			names = append(names, fc.name)
+		}
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// load returns the geofences, reading them again if they have changed.
+func (m *Matcher) load() ([]*fence, error) {
+	v, err := m.store.Version()
+	if err != nil {
+		return nil, err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.loaded && v == m.version {
+		return m.fences, nil
+	}
+	all, err := m.store.List()
+	if err != nil {
+		return nil, err
+	}
+	fences := make([]*fence, 0, len(all))
+	for _, f := range all {
+		fc, err := compile(f)
+		if err != nil {
+			log.Printf("error parsing geofence '%s', skipped: %v", f.Name, err)
+			continue
+		}
+		fences = append(fences, fc)
+	}
+	m.fences, m.version, m.loaded = fences, v, true
+	return fences, nil
+}
@@ -0,0 +1,164 @@
+package geofence
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+
+	"github.com/gdotgordon/locator-demo/locator/geoerr"
+	"github.com/gdotgordon/locator-demo/locator/types"
+)
+
+// square is the ring of a square from (x, y) to (x+size, y+size).
+func square(x, y, size float64) string {
+	b, _ := json.Marshal(ring{{x, y}, {x + size, y}, {x + size, y + size},
+		{x, y + size}, {x, y}})
+	return string(b)
+}
+
+func geofence(name, typ, coords string) *types.Geofence {
+	return &types.Geofence{Name: name, Geometry: types.Geometry{Type: typ,
+		Coordinates: json.RawMessage(coords)}}
+}
+
+func TestValidate(t *testing.T) {
+	for _, test := range []struct {
+		f *types.Geofence
+		e string
+	}{
+		{f: geofence("downtown", Polygon, "["+square(0, 0, 1)+"]")},
+		{f: geofence("two squares", MultiPolygon,
+			"[["+square(0, 0, 1)+"], ["+square(5, 5, 1)+"]]")},
+		{f: geofence("a:b", Polygon, "["+square(0, 0, 1)+"]"),
+			e: "Invalid geofence name 'a:b', expected up to 64 letters, " +
+				"digits, spaces, '.', '_' or '-'"},
+		{f: geofence("line", "LineString", "[[0, 0], [1, 1]]"),
+			e: "Unsupported geometry type 'LineString', expected Polygon " +
+				"or MultiPolygon"},
+		{f: geofence("empty", MultiPolygon, "[]"),
+			e: "Geometry has no polygons"},
+		{f: geofence("short", Polygon, "[[[0, 0], [1, 0], [0, 0]]]"),
+			e: "Polygon ring has fewer than 4 positions"},
+		{f: geofence("open", Polygon, "[[[0, 0], [1, 0], [1, 1], [0, 1]]]"),
+			e: "Polygon ring is not closed, the first and last positions " +
+				"differ"},
+		{f: geofence("far", Polygon, "["+square(179, 0, 2)+"]"),
+			e: "Position [181, 0] is out of range"},
+	} {
+		err := Validate(test.f)
+		if test.e == "" {
+			if err != nil {
+				t.Fatalf("Got unexpected error: %v", err)
+			}
+			continue
+		}
+		if err == nil || err.Error() != test.e {
+			t.Fatalf("Expected error '%s', got %v", test.e, err)
+		}
+		if class := geoerr.ClassOf(err); class != geoerr.Validation {
+			t.Fatalf("Expected class '%s', got '%s'", geoerr.Validation, class)
+		}
+	}
+}
+
+func TestContains(t *testing.T) {
+	// A square with a square hole in the middle, and a second square off
+	// to the north east.
+	fc, err := compile(geofence("park", MultiPolygon,
+		"[["+square(0, 0, 4)+", "+square(1, 1, 2)+"], ["+
+			square(10, 10, 1)+"]]"))
+	if err != nil {
+		t.Fatalf("Got unexpected error: %v", err)
+	}
+	for _, test := range []struct {
+		c  types.Coords
+		in bool
+	}{
+		{c: types.Coords{X: 0.5, Y: 0.5}, in: true},
+		{c: types.Coords{X: 3.5, Y: 2}, in: true},
+		{c: types.Coords{X: 2, Y: 2}, in: false},
+		{c: types.Coords{X: 10.5, Y: 10.5}, in: true},
+		{c: types.Coords{X: 5, Y: 5}, in: false},
+		{c: types.Coords{X: -1, Y: 2}, in: false},
+	} {
+		if in := fc.contains(test.c); in != test.in {
+			t.Fatalf("Expected %+v in geofence to be %t, got %t", test.c,
+				test.in, in)
+		}
+	}
+}
+
+// memStore is a geofence store in memory.
+type memStore struct {
+	fences  map[string]*types.Geofence
+	version int64
+	lists   int
+}
+
+func (ms *memStore) Put(f *types.Geofence) (bool, error) {
+	_, ok := ms.fences[f.Name]
+	ms.fences[f.Name] = f
+	ms.version++
+	return !ok, nil
+}
+
+func (ms *memStore) Get(name string) (*types.Geofence, error) {
+	return ms.fences[name], nil
+}
+
+func (ms *memStore) Delete(name string) (bool, error) {
+	_, ok := ms.fences[name]
+	delete(ms.fences, name)
+	ms.version++
+	return ok, nil
+}
+
+func (ms *memStore) List() ([]*types.Geofence, error) {
+	ms.lists++
+	var fences []*types.Geofence
+	for _, f := range ms.fences {
+		fences = append(fences, f)
+	}
+	return fences, nil
+}
+
+func (ms *memStore) Version() (int64, error) {
+	return ms.version, nil
+}
+
+func TestMatcher(t *testing.T) {
+	ms := &memStore{fences: make(map[string]*types.Geofence)}
+	m := NewMatcher(ms)
+	c := types.Coords{X: 0.5, Y: 0.5}
+	for _, test := range []struct {
+		put    *types.Geofence
+		delete string
+		names  []string
+		lists  int
+	}{
+		{names: nil, lists: 1},
+		{put: geofence("west", Polygon, "["+square(0, 0, 1)+"]"),
+			names: []string{"west"}, lists: 2},
+		{names: []string{"west"}, lists: 2},
+		{put: geofence("county", Polygon, "["+square(-1, -1, 3)+"]"),
+			names: []string{"county", "west"}, lists: 3},
+		{put: geofence("east", Polygon, "["+square(2, 2, 1)+"]"),
+			names: []string{"county", "west"}, lists: 4},
+		{delete: "west", names: []string{"county"}, lists: 5},
+	} {
+		if test.put != nil {
+			ms.Put(test.put)
+		}
+		if test.delete != "" {
+			ms.Delete(test.delete)
+		}
+		names, err := m.Match(c)
+		if err != nil {
+			t.Fatalf("Got unexpected error: %v", err)
+		}
+		if !reflect.DeepEqual(names, test.names) || ms.lists != test.lists {
+			t.Fatalf("Expected %v after %d loads, got %v after %d",
+				test.names, test.lists, names, ms.lists)
+		}
+	}
+}
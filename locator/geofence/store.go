@@ -0,0 +1,121 @@
+package geofence
+
+import (
+	"encoding/json"
+	"sort"
+	"time"
+
+	"github.com/gdotgordon/locator-demo/locator/types"
+	"github.com/go-redis/redis"
+)
+
+const (
+	// KeyPrefix is the prefix of the Redis geofence keys.
+	KeyPrefix = "geofence:"
+
+	// fencesKey is the hash of the geofences by name, and versionKey the
+	// count of the changes made to them.
+	fencesKey  = KeyPrefix + "fences"
+	versionKey = KeyPrefix + "version"
+)
+
+// Store keeps the geofences by name.  Put creates or replaces a geofence,
+// returning whether it was created.  Get returns nil, and no error, for a
+// geofence that doesn't exist, and Delete returns whether there was one
+// to delete.  Version changes whenever a geofence does.
+type Store interface {
+	Put(f *types.Geofence) (bool, error)
+	Get(name string) (*types.Geofence, error)
+	Delete(name string) (bool, error)
+	List() ([]*types.Geofence, error)
+	Version() (int64, error)
+}
+
+// RedisStore implements the Store interface for the Redis client.  The
+// geofences are kept as JSON in a hash.
+type RedisStore struct {
+	cli *redis.Client
+}
+
+// NewRedisStore creates a geofence store using the Redis client.
+func NewRedisStore(cli *redis.Client) *RedisStore {
+	return &RedisStore{cli: cli}
+}
+
+// Put saves the geofence, stamped with the time.
+func (rs *RedisStore) Put(f *types.Geofence) (bool, error) {
+	f.Updated = time.Now()
+	b, err := json.Marshal(f)
+	if err != nil {
+		return false, err
+	}
+	var created *redis.BoolCmd
+	_, err = rs.cli.TxPipelined(func(p redis.Pipeliner) error {
+		created = p.HSet(fencesKey, f.Name, b)
+		p.Incr(versionKey)
+		return nil
+	})
+	if err != nil {
+		return false, err
+	}
+	return created.Val(), nil
+}
+
+// Get returns the named geofence.
+func (rs *RedisStore) Get(name string) (*types.Geofence, error) {
+	b, err := rs.cli.HGet(fencesKey, name).Bytes()
+	if err == redis.Nil {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var f types.Geofence
+	if err := json.Unmarshal(b, &f); err != nil {
+		return nil, err
+	}
+	return &f, nil
+}
+
+// Delete removes the named geofence.
+func (rs *RedisStore) Delete(name string) (bool, error) {
+	var deleted *redis.IntCmd
+	_, err := rs.cli.TxPipelined(func(p redis.Pipeliner) error {
+		deleted = p.HDel(fencesKey, name)
+		p.Incr(versionKey)
+		return nil
+	})
+	if err != nil {
+		return false, err
+	}
+	return deleted.Val() > 0, nil
+}
+
+// List returns all of the geofences, sorted by name.
+func (rs *RedisStore) List() ([]*types.Geofence, error) {
+	all, err := rs.cli.HGetAll(fencesKey).Result()
+	if err != nil {
+		return nil, err
+	}
+	fences := make([]*types.Geofence, 0, len(all))
+	for _, v := range all {
+		var f types.Geofence
+		if err := json.Unmarshal([]byte(v), &f); err != nil {
+			return nil, err
+		}
+		fences = append(fences, &f)
+	}
+	sort.Slice(fences, func(i, j int) bool {
+		return fences[i].Name < fences[j].Name
+	})
+	return fences, nil
+}
+
+// Version returns the count of the changes to the geofences.
+func (rs *RedisStore) Version() (int64, error) {
+	v, err := rs.cli.Get(versionKey).Int64()
+	if err == redis.Nil {
+		return 0, nil
+	}
+	return v, err
+}
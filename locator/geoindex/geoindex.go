@@ -0,0 +1,348 @@
+// Package geoindex keeps a geospatial index of the addresses the locator
+// has found, so that they can be searched by their distance from a point,
+// or by a bounding box.  The index is kept in Redis, outside of the
+// "locator:" keyspace, so indexing doesn't generate events for the
+// analyzer.
+package geoindex
+
+import (
+	"encoding/json"
+	"math"
+	"strings"
+	"time"
+
+	"github.com/gdotgordon/locator-demo/locator/geo"
+	"github.com/gdotgordon/locator-demo/locator/geoerr"
+	"github.com/gdotgordon/locator-demo/locator/types"
+	"github.com/go-redis/redis"
+)
+
+const (
+	// KeyPrefix is the prefix of the Redis index keys.
+	KeyPrefix = "geoindex:"
+
+	// placesKey is the Redis geo set of the addresses, detailsKey the
+	// hash of their details, and locatedKey the sorted set of when they
+	// were located, all keyed by the address.
+	placesKey  = KeyPrefix + "places"
+	detailsKey = KeyPrefix + "details"
+	locatedKey = KeyPrefix + "located"
+
+	// DefaultMaxAge is how long an address is kept after it was last
+	// located, and DefaultMaxSize the most addresses kept.
+	DefaultMaxAge  = 30 * 24 * time.Hour
+	DefaultMaxSize = 1000000
+
+	// pruneBatch is the most addresses pruned by each Add, so that an
+	// Add never does much more work than it adds.
+	pruneBatch = 100
+
+	// DefaultLimit is the size of a page of results, and MaxLimit the
+	// largest page that may be asked for.
+	DefaultLimit = 20
+	MaxLimit     = 100
+
+	// maxLatitude is the furthest from the equator Redis can index.
+	maxLatitude = 85.05112878
+
+	// earthRadius is the radius of the Earth, in meters, that Redis uses
+	// for its distances.
+	earthRadius = 6372797.560856
+)
+
+// Index stores the located addresses and searches them.  Adding an
+// address that is already indexed updates it.
+type Index interface {
+	Add(p types.Place) error
+	Search(q Query) (*types.PlacesResponse, error)
+}
+
+// Query is a search of the index, for the addresses within the radius of
+// the center, or if Box is set, within the box.  The results are sorted by
+// their distance from the center, which for a box is its middle, and
+// Offset and Limit select the page of them.
+type Query struct {
+	Center types.Coords
+	Radius float64
+	Box    *Box
+	Unit   string
+	Offset int
+	Limit  int
+}
+
+// Box is a bounding box, from its south west to its north east corner.
+type Box struct {
+	Min types.Coords
+	Max types.Coords
+}
+
+// contains says whether the point is in the box.
+func (b *Box) contains(c types.Coords) bool {
+	return c.X >= b.Min.X && c.X <= b.Max.X && c.Y >= b.Min.Y &&
+		c.Y <= b.Max.Y
+}
+
+// PlaceOf returns the place to index for a located address.
+func PlaceOf(resp *types.AddressResponse) types.Place {
+	p := types.Place{Address: resp.MatchedAddress,
+		Coordinates: resp.Coordinates, Zip: resp.Zip,
+		Normalized: resp.Normalized, Located: time.Now()}
+	if p.Address == "" && resp.Normalized != nil {
+		p.Address = format(resp.Normalized)
+	}
+	return p
+}
+
+// format writes out a normalized address on one line.
+func format(na *types.NormalizedAddress) string {
+	if na.OneLine != "" {
+		return na.OneLine
+	}
+	var parts []string
+	for _, s := range []string{
+		strings.TrimSpace(na.StructureNumber + " " + na.Street),
+		na.City,
+		strings.TrimSpace(na.State + " " + na.Zip),
+	} {
+		if s != "" {
+			parts = append(parts, s)
+		}
+	}
+	return strings.Join(parts, ", ")
+}
+
+// prepare fills in the defaults of the query, and checks it.  A box is
+// searched for as the circle around it.
+func (q *Query) prepare() error {
+	if q.Unit == "" {
+		q.Unit = geo.DefaultUnit
+	}
+	if _, err := geo.UnitLength(q.Unit); err != nil {
+		return err
+	}
+	if q.Limit == 0 {
+		q.Limit = DefaultLimit
+	}
+	if q.Limit < 0 || q.Limit > MaxLimit {
+		return geoerr.Errorf(geoerr.Validation,
+			"Limit must be between 1 and %d", MaxLimit)
+	}
+	if q.Offset < 0 {
+		return geoerr.New(geoerr.Validation, "Offset must not be negative")
+	}
+
+	if q.Box != nil {
+		if !valid(q.Box.Min) || !valid(q.Box.Max) {
+			return geoerr.New(geoerr.Validation,
+				"Bounding box is out of range")
+		}
+		if q.Box.Min.X >= q.Box.Max.X || q.Box.Min.Y >= q.Box.Max.Y {
+			return geoerr.New(geoerr.Validation,
+				"Bounding box min must be south west of its max")
+		}
+		q.Center = types.Coords{X: (q.Box.Min.X + q.Box.Max.X) / 2,
+			Y: (q.Box.Min.Y + q.Box.Max.Y) / 2}
+		// The corners nearest the equator are the furthest from the
+		// center, and a meter more allows for the precision of the index.
+		r := math.Max(distance(q.Center, q.Box.Min),
+			distance(q.Center, q.Box.Max))
+		unit, _ := geo.UnitLength(q.Unit)
+		q.Radius = (r + 1) / unit
+		return nil
+	}
+	if !valid(q.Center) {
+		return geoerr.New(geoerr.Validation, "Coordinates are out of range")
+	}
+	if q.Radius <= 0 {
+		return geoerr.New(geoerr.Validation, "Radius must be positive")
+	}
+	return nil
+}
+
+// valid says whether Redis can index the coordinates.
+func valid(c types.Coords) bool {
+	return c.X >= -180 && c.X <= 180 && c.Y >= -maxLatitude &&
+		c.Y <= maxLatitude
+}
+
+// distance is the distance between the points, in meters, on the sphere
+// Redis uses.
+func distance(a, b types.Coords) float64 {
+	return geo.GreatCircle(a, b, earthRadius)
+}
+
+// page returns the response for the places found, nearest first, with
+// the page of them the query asked for.
+func page(q Query, found []types.Place) *types.PlacesResponse {
+	resp := types.PlacesResponse{Center: q.Center, Unit: q.Unit,
+		Offset: q.Offset, Limit: q.Limit, Results: []types.Place{}}
+	if q.Offset >= len(found) {
+		return &resp
+	}
+	end := q.Offset + q.Limit
+	if end < len(found) {
+		resp.NextOffset = end
+	} else {
+		end = len(found)
+	}
+	resp.Results = found[q.Offset:end]
+	return &resp
+}
+
+// pruneScript removes the addresses located before the cutoff in ARGV[1],
+// and then the oldest of them while there are more than ARGV[2], if it
+// is positive, up to ARGV[3] of them in all.  It returns how many it
+// removed.
+var pruneScript = redis.NewScript(`
+local names = redis.call("ZRANGEBYSCORE", KEYS[3], "-inf", "(" .. ARGV[1],
+	"LIMIT", 0, ARGV[3])
+local max = tonumber(ARGV[2])
+if max > 0 then
+	local over = redis.call("ZCARD", KEYS[3]) - #names - max
+	if over > tonumber(ARGV[3]) - #names then
+		over = tonumber(ARGV[3]) - #names
+	end
+	if over > 0 then
+		local oldest = redis.call("ZRANGE", KEYS[3], #names, #names + over - 1)
+		for _, name in ipairs(oldest) do
+			table.insert(names, name)
+		end
+	end
+end
+for _, name in ipairs(names) do
+	redis.call("ZREM", KEYS[1], name)
+	redis.call("HDEL", KEYS[2], name)
+	redis.call("ZREM", KEYS[3], name)
+end
+return #names
+`)
+
+// RedisIndex implements the Index interface for the Redis client.  The
+// addresses not located again within maxAge are pruned, as are the
+// oldest ones while there are more than maxSize, a little at a time as
+// others are added.
+type RedisIndex struct {
+	cli     *redis.Client
+	maxAge  time.Duration
+	maxSize int64
+}
+
+// NewRedisIndex creates an index using the Redis client, keeping the
+// addresses for maxAge, and at most maxSize of them.  A zero maxAge or
+// maxSize means the default, and a negative one no limit.
+func NewRedisIndex(cli *redis.Client, maxAge time.Duration,
+	maxSize int64) *RedisIndex {
+	if maxAge == 0 {
+		maxAge = DefaultMaxAge
+	}
+	if maxSize == 0 {
+		maxSize = DefaultMaxSize
+	}
+	return &RedisIndex{cli: cli, maxAge: maxAge, maxSize: maxSize}
+}
+
+// Add indexes the place, keeping its details and when it was located
+// alongside, and prunes the places that are past keeping.
+func (ri *RedisIndex) Add(p types.Place) error {
+	p.Distance = 0
+	if p.Located.IsZero() {
+		p.Located = time.Now()
+	}
+	b, err := json.Marshal(p)
+	if err != nil {
+		return err
+	}
+	_, err = ri.cli.TxPipelined(func(pl redis.Pipeliner) error {
+		pl.GeoAdd(placesKey, &redis.GeoLocation{Name: p.Address,
+			Longitude: p.Coordinates.X, Latitude: p.Coordinates.Y})
+		pl.HSet(detailsKey, p.Address, b)
+		pl.ZAdd(locatedKey, redis.Z{Score: float64(p.Located.Unix()),
+			Member: p.Address})
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	return ri.prune()
+}
+
+// prune removes a batch of the places that are past keeping.
+func (ri *RedisIndex) prune() error {
+	var cutoff int64
+	if ri.maxAge > 0 {
+		cutoff = time.Now().Add(-ri.maxAge).Unix()
+	}
+	return pruneScript.Run(ri.cli,
+		[]string{placesKey, detailsKey, locatedKey}, cutoff, ri.maxSize,
+		pruneBatch).Err()
+}
+
+// Search finds the places in the radius, nearest first.  It asks for just
+// enough of them to fill the page, and to know if there is another, but
+// when searching a box, some of those found may be outside of it, in
+// which case it asks for twice as many until it has enough.
+func (ri *RedisIndex) Search(q Query) (*types.PlacesResponse, error) {
+	if err := q.prepare(); err != nil {
+		return nil, err
+	}
+	unit, _ := geo.UnitLength(q.Unit)
+	want := q.Offset + q.Limit + 1
+	count := want
+	var locs []redis.GeoLocation
+	for {
+		all, err := ri.cli.GeoRadiusRO(placesKey, q.Center.X, q.Center.Y,
+			&redis.GeoRadiusQuery{Radius: q.Radius * unit, Unit: "m",
+				WithCoord: true, WithDist: true, Count: count,
+				Sort: "ASC"}).Result()
+		if err != nil {
+			return nil, err
+		}
+		locs = locs[:0]
+		for _, l := range all {
+			c := types.Coords{X: l.Longitude, Y: l.Latitude}
+			if q.Box == nil || q.Box.contains(c) {
+				locs = append(locs, l)
+			}
+		}
+		if len(locs) >= want || len(all) < count {
+			break
+		}
+		count *= 2
+	}
+
+	resp := page(q, places(locs, unit))
+	if len(resp.Results) == 0 {
+		return resp, nil
+	}
+	names := make([]string, len(resp.Results))
+	for i, p := range resp.Results {
+		names[i] = p.Address
+	}
+	details, err := ri.cli.HMGet(detailsKey, names...).Result()
+	if err != nil {
+		return nil, err
+	}
+	for i, d := range details {
+		s, ok := d.(string)
+		if !ok {
+			continue
+		}
+		dist := resp.Results[i].Distance
+		if err := json.Unmarshal([]byte(s), &resp.Results[i]); err != nil {
+			return nil, err
+		}
+		resp.Results[i].Distance = dist
+	}
+	return resp, nil
+}
+
+// places converts the Redis locations, found in meters, until their
+// details are read.
+func places(locs []redis.GeoLocation, unit float64) []types.Place {
+	out := make([]types.Place, len(locs))
+	for i, l := range locs {
+		out[i] = types.Place{Address: l.Name, Distance: l.Dist / unit,
+			Coordinates: types.Coords{X: l.Longitude, Y: l.Latitude}}
+	}
+	return out
+}
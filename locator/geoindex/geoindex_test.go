@@ -0,0 +1,131 @@
+package geoindex
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"github.com/gdotgordon/locator-demo/locator/geoerr"
+	"github.com/gdotgordon/locator-demo/locator/types"
+)
+
+func TestPrepare(t *testing.T) {
+	center := types.Coords{X: -76.92691, Y: 38.846542}
+	for _, test := range []struct {
+		q      Query
+		e      string
+		unit   string
+		limit  int
+		radius float64
+	}{
+		{q: Query{Center: center, Radius: 5}, unit: "km", limit: 20,
+			radius: 5},
+		{q: Query{Center: center, Radius: 3, Unit: "mi", Limit: 50},
+			unit: "mi", limit: 50, radius: 3},
+		// The corner distance on the sphere Redis uses, plus a meter.
+		{q: Query{Box: &Box{Min: types.Coords{X: -1, Y: -1},
+			Max: types.Coords{X: 1, Y: 1}}, Unit: "m"}, unit: "m", limit: 20,
+			radius: distance(types.Coords{}, types.Coords{X: 1, Y: 1}) + 1},
+		{q: Query{Center: center}, e: "Radius must be positive"},
+		{q: Query{Center: types.Coords{X: 0, Y: 89}, Radius: 1},
+			e: "Coordinates are out of range"},
+		{q: Query{Center: center, Radius: 1, Unit: "furlong"},
+			e: "Unknown unit 'furlong', expected m, km, mi, ft or nmi"},
+		{q: Query{Center: center, Radius: 1, Limit: 500},
+			e: "Limit must be between 1 and 100"},
+		{q: Query{Center: center, Radius: 1, Offset: -1},
+			e: "Offset must not be negative"},
+		{q: Query{Box: &Box{Min: types.Coords{X: 1, Y: 1},
+			Max: types.Coords{X: -1, Y: -1}}},
+			e: "Bounding box min must be south west of its max"},
+		{q: Query{Box: &Box{Min: types.Coords{X: -200, Y: 1},
+			Max: types.Coords{X: -1, Y: 2}}},
+			e: "Bounding box is out of range"},
+	} {
+		err := test.q.prepare()
+		if test.e != "" {
+			if err == nil || err.Error() != test.e {
+				t.Fatalf("Expected error '%s', got %v", test.e, err)
+			}
+			if class := geoerr.ClassOf(err); class != geoerr.Validation {
+				t.Fatalf("Expected class '%s', got '%s'", geoerr.Validation,
+					class)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("Got unexpected error: %v", err)
+		}
+		if test.q.Unit != test.unit || test.q.Limit != test.limit {
+			t.Fatalf("Expected unit '%s' and limit %d, got '%s' and %d",
+				test.unit, test.limit, test.q.Unit, test.q.Limit)
+		}
+		if math.Abs(test.q.Radius-test.radius) > 1 {
+			t.Fatalf("Expected radius %v, got %v", test.radius, test.q.Radius)
+		}
+	}
+}
+
+func TestPage(t *testing.T) {
+	found := make([]types.Place, 5)
+	for _, test := range []struct {
+		offset, limit int
+		n, next       int
+	}{
+		{offset: 0, limit: 2, n: 2, next: 2},
+		{offset: 2, limit: 2, n: 2, next: 4},
+		{offset: 4, limit: 2, n: 1, next: 0},
+		{offset: 0, limit: 5, n: 5, next: 0},
+		{offset: 9, limit: 2, n: 0, next: 0},
+	} {
+		resp := page(Query{Offset: test.offset, Limit: test.limit}, found)
+		if len(resp.Results) != test.n || resp.NextOffset != test.next {
+			t.Fatalf("Expected %d results and next offset %d, got %d and %d",
+				test.n, test.next, len(resp.Results), resp.NextOffset)
+		}
+	}
+}
+
+func TestPlaceOf(t *testing.T) {
+	for _, test := range []struct {
+		resp    types.AddressResponse
+		address string
+	}{
+		{resp: types.AddressResponse{
+			MatchedAddress: "4600 SILVER HILL RD, WASHINGTON, DC, 20233",
+			Normalized: &types.NormalizedAddress{StructureNumber: "4600",
+				Street: "SILVER HILL RD"}},
+			address: "4600 SILVER HILL RD, WASHINGTON, DC, 20233"},
+		{resp: types.AddressResponse{
+			Normalized: &types.NormalizedAddress{StructureNumber: "1",
+				Street: "MAIN ST", State: "MD", Zip: "20746"}},
+			address: "1 MAIN ST, MD 20746"},
+		{resp: types.AddressResponse{
+			Normalized: &types.NormalizedAddress{OneLine: "1 MAIN ST 20746"}},
+			address: "1 MAIN ST 20746"},
+	} {
+		if p := PlaceOf(&test.resp); p.Address != test.address {
+			t.Fatalf("Expected address '%s', got '%s'", test.address,
+				p.Address)
+		}
+	}
+}
+
+func TestRetention(t *testing.T) {
+	for _, test := range []struct {
+		maxAge  time.Duration
+		maxSize int64
+		age     time.Duration
+		size    int64
+	}{
+		{age: DefaultMaxAge, size: DefaultMaxSize},
+		{maxAge: time.Hour, maxSize: 10, age: time.Hour, size: 10},
+		{maxAge: -1, maxSize: -1, age: -1, size: -1},
+	} {
+		ri := NewRedisIndex(nil, test.maxAge, test.maxSize)
+		if ri.maxAge != test.age || ri.maxSize != test.size {
+			t.Fatalf("Expected retention %v and %d, got %v and %d", test.age,
+				test.size, ri.maxAge, ri.maxSize)
+		}
+	}
+}
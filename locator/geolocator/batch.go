@@ -0,0 +1,74 @@
+package geolocator
+
+import (
+	"context"
+	"sync"
+
+	"github.com/gdotgordon/locator-demo/locator/geoerr"
+	"github.com/gdotgordon/locator-demo/locator/types"
+)
+
+// LocateBatch looks up each of the addresses using a bounded pool of
+// worker goroutines.  Each lookup goes through loc.Locate, so the usual
+// stats are sent for every item.  The results are returned in the same
+// order as the requests, and a failed item does not affect the others.
+func LocateBatch(ctx context.Context, loc Geolocator,
+	reqs []types.AddressRequest, workers int) []types.BatchResult {
+	if workers <= 0 {
+		workers = 1
+	}
+	if workers > len(reqs) {
+		workers = len(reqs)
+	}
+
+	// Each worker writes only to the slots for the indices it receives,
+	// so the results slice needs no further synchronization.
+	results := make([]types.BatchResult, len(reqs))
+	work := make(chan int)
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for ndx := range work {
+				results[ndx] = LocateOne(ctx, loc, ndx, reqs[ndx])
+			}
+		}()
+	}
+
+	for i := range reqs {
+		work <- i
+	}
+	close(work)
+	wg.Wait()
+	return results
+}
+
+// LocateOne looks up one address of a batch, at the index, reporting the
+// outcome as a batch result rather than an error.
+func LocateOne(ctx context.Context, loc Geolocator, ndx int,
+	req types.AddressRequest) types.BatchResult {
+	res := types.BatchResult{Index: ndx}
+
+	// Don't bother starting new lookups once the caller has gone away.
+	if err := ctx.Err(); err != nil {
+		res.Status = types.BatchError
+		res.Error = err.Error()
+		res.ErrorClass = geoerr.ClassOf(err)
+		return res
+	}
+
+	resp, err := loc.Locate(ctx, req)
+	switch {
+	case err != nil:
+		res.Status = types.BatchError
+		res.Error = err.Error()
+		res.ErrorClass = geoerr.ClassOf(err)
+	case resp.MatchCount == 0:
+		res.Status = types.BatchNotFound
+	default:
+		res.Status = types.BatchFound
+		res.Response = resp
+	}
+	return res
+}
@@ -0,0 +1,109 @@
+package geolocator
+
+import (
+	"context"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gdotgordon/locator-demo/locator/geoerr"
+	"github.com/gdotgordon/locator-demo/locator/types"
+)
+
+// stubLocator answers by street name, and keeps track of the number of
+// lookups in flight so we can check the pool bound.
+type stubLocator struct {
+	inFlight int32
+	maxSeen  int32
+}
+
+func (sl *stubLocator) Locate(ctx context.Context,
+	req types.AddressRequest) (*types.AddressResponse, error) {
+	n := atomic.AddInt32(&sl.inFlight, 1)
+	defer atomic.AddInt32(&sl.inFlight, -1)
+	for {
+		m := atomic.LoadInt32(&sl.maxSeen)
+		if n <= m || atomic.CompareAndSwapInt32(&sl.maxSeen, m, n) {
+			break
+		}
+	}
+	time.Sleep(10 * time.Millisecond)
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	switch strings.ToLower(req.Street) {
+	case "bad":
+		return nil, geoerr.New(geoerr.Validation, "bad street")
+	case "nowhere":
+		return &types.AddressResponse{}, nil
+	}
+	return &types.AddressResponse{Zip: req.Zip, MatchCount: 1,
+		Coordinates: types.Coords{X: 1, Y: 2}}, nil
+}
+
+func (sl *stubLocator) Reverse(ctx context.Context,
+	coords types.Coords) (*types.ReverseResponse, error) {
+	return &types.ReverseResponse{Coordinates: coords}, nil
+}
+
+func TestLocateBatch(t *testing.T) {
+	var reqs []types.AddressRequest
+	var expected []string
+	for i := 0; i < 20; i++ {
+		switch i % 3 {
+		case 0:
+			reqs = append(reqs, types.AddressRequest{StructureNumber: "1",
+				Street: "Main St", Zip: "12345"})
+			expected = append(expected, types.BatchFound)
+		case 1:
+			reqs = append(reqs, types.AddressRequest{Street: "nowhere"})
+			expected = append(expected, types.BatchNotFound)
+		case 2:
+			reqs = append(reqs, types.AddressRequest{Street: "bad"})
+			expected = append(expected, types.BatchError)
+		}
+	}
+
+	for _, workers := range []int{0, 1, 4, 50} {
+		sl := &stubLocator{}
+		results := LocateBatch(context.Background(), sl, reqs, workers)
+		if len(results) != len(reqs) {
+			t.Fatalf("Expected %d results, got %d", len(reqs), len(results))
+		}
+		for i, res := range results {
+			if res.Index != i {
+				t.Fatalf("Expected index %d, got %d", i, res.Index)
+			}
+			if res.Status != expected[i] {
+				t.Fatalf("Expected status '%s' for %d, got '%s'", expected[i],
+					i, res.Status)
+			}
+			if res.Status == types.BatchFound && res.Response.Zip != "12345" {
+				t.Fatalf("Expected zip '12345', got '%s'", res.Response.Zip)
+			}
+			if res.Status == types.BatchError && res.Error != "bad street" {
+				t.Fatalf("Expected error 'bad street', got '%s'", res.Error)
+			}
+		}
+		limit := int32(workers)
+		if limit <= 0 {
+			limit = 1
+		}
+		if sl.maxSeen > limit {
+			t.Fatalf("Expected at most %d concurrent lookups, got %d", limit,
+				sl.maxSeen)
+		}
+	}
+}
+
+func TestLocateBatchCancelled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	reqs := []types.AddressRequest{{StructureNumber: "1", Street: "Main St"}}
+	results := LocateBatch(ctx, &stubLocator{}, reqs, 2)
+	if results[0].Status != types.BatchError {
+		t.Fatalf("Expected error status, got '%s'", results[0].Status)
+	}
+}
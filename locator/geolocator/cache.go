@@ -0,0 +1,111 @@
+package geolocator
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/gdotgordon/locator-demo/locator/cache"
+	"github.com/gdotgordon/locator-demo/locator/store"
+	"github.com/gdotgordon/locator-demo/locator/types"
+)
+
+const (
+	// RedisTier labels the cache events of the Redis cache.
+	RedisTier = "redis"
+
+	// MemoryTier labels the cache events of the in-process cache.
+	MemoryTier = "memory"
+
+	// CacheProvider labels the stats of lookups answered from a cache.
+	CacheProvider = "cache"
+
+	// DefaultCacheTTL is how long found addresses are cached.
+	DefaultCacheTTL = 24 * time.Hour
+
+	// DefaultNegativeCacheTTL is how long addresses that were not found
+	// are cached.
+	DefaultNegativeCacheTTL = time.Hour
+)
+
+// cachingLocator looks up addresses in the cache before asking the
+// wrapped Geolocator.  Both found and not found results are cached, each
+// with their own time to live, but errors are not.  If flights is set,
+// concurrent misses for the same address share a single lookup.  Reverse
+// lookups are passed straight through.
+type cachingLocator struct {
+	loc     Geolocator
+	cache   cache.Cache
+	tier    string
+	ttl     time.Duration
+	negTTL  time.Duration
+	store   store.Store
+	flights *coalescer
+}
+
+func (cl *cachingLocator) Locate(ctx context.Context,
+	reqAddr types.AddressRequest) (*types.AddressResponse, error) {
+	key := cache.Key(reqAddr)
+	resp, ok, err := cl.cache.Get(key)
+	if err != nil {
+		log.Printf("error reading cache, skipped: %v", err)
+	}
+	if ok {
+		cl.event(true)
+		resp.Cached = true
+		return resp, nil
+	}
+	cl.event(false)
+
+	if cl.flights == nil {
+		return cl.fill(ctx, key, reqAddr)
+	}
+	resp, shared, err := cl.flights.do(ctx, key,
+		func() (*types.AddressResponse, error) {
+			return cl.fill(ctx, key, reqAddr)
+		})
+	if shared {
+		if serr := cl.store.Incr(types.CacheKey(cl.tier, "coalesced")); serr != nil {
+			log.Printf("error storing cache event, skipped: %v", serr)
+		}
+	}
+	return resp, err
+}
+
+// fill looks up the address with the wrapped Geolocator and caches the
+// result.
+func (cl *cachingLocator) fill(ctx context.Context, key string,
+	reqAddr types.AddressRequest) (*types.AddressResponse, error) {
+	resp, err := cl.loc.Locate(ctx, reqAddr)
+	if err != nil {
+		return nil, err
+	}
+	ttl := cl.ttl
+	if resp.MatchCount == 0 {
+		ttl = cl.negTTL
+	}
+	if ttl > 0 {
+		if err := cl.cache.Set(key, resp, ttl); err != nil {
+			log.Printf("error writing cache, skipped: %v", err)
+		}
+	}
+	return resp, nil
+}
+
+func (cl *cachingLocator) Reverse(ctx context.Context,
+	coords types.Coords) (*types.ReverseResponse, error) {
+	return cl.loc.Reverse(ctx, coords)
+}
+
+// event sends the cache hit or miss to the store.
+func (cl *cachingLocator) event(hit bool) {
+	var err error
+	if hit {
+		err = cl.store.Incr(types.CacheKey(cl.tier, "hit"))
+	} else {
+		err = cl.store.Incr(types.CacheKey(cl.tier, "miss"))
+	}
+	if err != nil {
+		log.Printf("error storing cache event, skipped: %v", err)
+	}
+}
@@ -0,0 +1,273 @@
+package geolocator
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gdotgordon/locator-demo/locator/cache"
+	"github.com/gdotgordon/locator-demo/locator/store"
+	"github.com/gdotgordon/locator-demo/locator/store/storetest"
+	"github.com/gdotgordon/locator-demo/locator/types"
+)
+
+// memCache is an in-memory cache that remembers the ttl of each entry.
+type memCache struct {
+	mu   sync.Mutex
+	resp map[string]types.AddressResponse
+	ttl  map[string]time.Duration
+	err  error
+}
+
+func newMemCache() *memCache {
+	return &memCache{resp: make(map[string]types.AddressResponse),
+		ttl: make(map[string]time.Duration)}
+}
+
+func (mc *memCache) Get(key string) (*types.AddressResponse, bool, error) {
+	mc.mu.Lock()
+	defer mc.mu.Unlock()
+	if mc.err != nil {
+		return nil, false, mc.err
+	}
+	resp, ok := mc.resp[key]
+	if !ok {
+		return nil, false, nil
+	}
+	return &resp, true, nil
+}
+
+func (mc *memCache) Set(key string, resp *types.AddressResponse,
+	ttl time.Duration) error {
+	mc.mu.Lock()
+	defer mc.mu.Unlock()
+	if mc.err != nil {
+		return mc.err
+	}
+	mc.resp[key] = *resp
+	mc.ttl[key] = ttl
+	return nil
+}
+
+func init() {
+	Register("cachestub", func(cfg Config, store store.Store) (Geolocator, error) {
+		return &stubLocator{}, nil
+	})
+}
+
+func TestCachedLookup(t *testing.T) {
+	mc := newMemCache()
+	rs := &storetest.Store{}
+	l, err := New(Config{Provider: "cachestub", Cache: mc,
+		CacheTTL: time.Hour, NegativeCacheTTL: time.Minute}, rs)
+	if err != nil {
+		t.Fatalf("Got unexpected error: %v", err)
+	}
+
+	found := types.AddressRequest{StructureNumber: "1", Street: "Main St",
+		Zip: "12345"}
+	nowhere := types.AddressRequest{Street: "nowhere"}
+	bad := types.AddressRequest{Street: "bad"}
+	for i, tc := range []struct {
+		req      types.AddressRequest
+		cached   bool
+		provider string
+		err      bool
+	}{
+		{req: found, provider: "cachestub"},
+		{req: found, cached: true, provider: CacheProvider},
+		{req: types.AddressRequest{StructureNumber: "1", Street: "main  st.",
+			Zip: "12345"}, cached: true, provider: CacheProvider},
+		{req: nowhere, provider: "cachestub"},
+		{req: nowhere, cached: true, provider: CacheProvider},
+		{req: bad, err: true},
+		{req: bad, err: true},
+	} {
+		resp, err := l.Locate(context.Background(), tc.req)
+		if tc.err {
+			if err == nil {
+				t.Fatalf("%d: Expected error, got none", i)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("%d: Got unexpected error: %v", i, err)
+		}
+		if resp.Cached != tc.cached {
+			t.Fatalf("%d: Expected cached %t, got %t", i, tc.cached,
+				resp.Cached)
+		}
+	}
+
+	if ttl := mc.ttl[cache.Key(found)]; ttl != time.Hour {
+		t.Fatalf("Expected ttl %v for found address, got %v", time.Hour, ttl)
+	}
+	if ttl := mc.ttl[cache.Key(nowhere)]; ttl != time.Minute {
+		t.Fatalf("Expected ttl %v for missing address, got %v", time.Minute,
+			ttl)
+	}
+	if _, ok := mc.resp[cache.Key(bad)]; ok {
+		t.Fatalf("Expected error not to be cached")
+	}
+
+	for key, exp := range map[string]int{
+		types.CacheKey(RedisTier, "hit"):            3,
+		types.CacheKey(RedisTier, "miss"):           4,
+		types.ProviderKey(CacheProvider, "success"): 3,
+		types.ProviderKey("cachestub", "success"):   2,
+		types.ProviderKey("cachestub", "error"):     2,
+	} {
+		if rs.Count(key) != exp {
+			t.Fatalf("Expected %d for '%s', got %d", exp, key, rs.Count(key))
+		}
+	}
+}
+
+func TestCacheErrors(t *testing.T) {
+	mc := newMemCache()
+	mc.err = errors.New("cache down")
+	cl := &cachingLocator{loc: &stubLocator{}, cache: mc, tier: RedisTier,
+		ttl: time.Hour, store: NoOpStore{}}
+	resp, err := cl.Locate(context.Background(), types.AddressRequest{
+		StructureNumber: "1", Street: "Main St", Zip: "12345"})
+	if err != nil {
+		t.Fatalf("Got unexpected error: %v", err)
+	}
+	if resp.Cached || resp.Zip != "12345" {
+		t.Fatalf("Expected uncached response for '12345', got %+v", resp)
+	}
+}
+
+// gatedLocator blocks each lookup until the gate is closed, and counts
+// the lookups.
+type gatedLocator struct {
+	gate  chan struct{}
+	calls int32
+}
+
+func (gl *gatedLocator) Locate(ctx context.Context,
+	req types.AddressRequest) (*types.AddressResponse, error) {
+	atomic.AddInt32(&gl.calls, 1)
+	<-gl.gate
+	return &types.AddressResponse{Zip: req.Zip, MatchCount: 1}, nil
+}
+
+func (gl *gatedLocator) Reverse(ctx context.Context,
+	coords types.Coords) (*types.ReverseResponse, error) {
+	return nil, ErrReverseUnsupported
+}
+
+func TestCoalescedLookup(t *testing.T) {
+	const n = 5
+	gl := &gatedLocator{gate: make(chan struct{})}
+	rs := &storetest.Store{}
+	cl := &cachingLocator{loc: gl, cache: cache.NewLRU(10, nil),
+		tier: MemoryTier, ttl: time.Hour, store: rs, flights: newCoalescer()}
+
+	req := types.AddressRequest{StructureNumber: "1", Street: "Main St",
+		Zip: "12345"}
+	var wg sync.WaitGroup
+	resps := make([]*types.AddressResponse, n)
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			resp, err := cl.Locate(context.Background(), req)
+			if err != nil {
+				t.Errorf("Got unexpected error: %v", err)
+			}
+			resps[i] = resp
+		}(i)
+	}
+	for rs.Count(types.CacheKey(MemoryTier, "miss")) < n {
+		time.Sleep(time.Millisecond)
+	}
+	time.Sleep(20 * time.Millisecond)
+	close(gl.gate)
+	wg.Wait()
+
+	if c := atomic.LoadInt32(&gl.calls); c != 1 {
+		t.Fatalf("Expected 1 upstream call, got %d", c)
+	}
+	if c := rs.Count(types.CacheKey(MemoryTier, "coalesced")); c != n-1 {
+		t.Fatalf("Expected %d coalesced lookups, got %d", n-1, c)
+	}
+	for i, resp := range resps {
+		if resp == nil || resp.Zip != "12345" {
+			t.Fatalf("Expected zip '12345' for %d, got %+v", i, resp)
+		}
+		for j := 0; j < i; j++ {
+			if resps[j] == resp {
+				t.Fatalf("Expected each caller to get its own response")
+			}
+		}
+	}
+
+	resp, err := cl.Locate(context.Background(), req)
+	if err != nil || !resp.Cached {
+		t.Fatalf("Expected cached response, got %+v, %v", resp, err)
+	}
+}
+
+func TestMemoryCacheEvictions(t *testing.T) {
+	rs := &storetest.Store{}
+	l, err := New(Config{Provider: "cachestub", MemoryCacheSize: 2,
+		CacheTTL: time.Hour}, rs)
+	if err != nil {
+		t.Fatalf("Got unexpected error: %v", err)
+	}
+	for _, zip := range []string{"1", "2", "3", "1"} {
+		if _, err := l.Locate(context.Background(), types.AddressRequest{
+			StructureNumber: "1", Street: "Main St", Zip: zip}); err != nil {
+			t.Fatalf("Got unexpected error: %v", err)
+		}
+	}
+	for key, exp := range map[string]int{
+		types.CacheKey(MemoryTier, "miss"):     4,
+		types.CacheKey(MemoryTier, "eviction"): 2,
+	} {
+		if rs.Count(key) != exp {
+			t.Fatalf("Expected %d for '%s', got %d", exp, key, rs.Count(key))
+		}
+	}
+}
+
+func TestCoalescedLeaderCanceled(t *testing.T) {
+	c := newCoalescer()
+	ctx, cancel := context.WithCancel(context.Background())
+	started := make(chan struct{})
+	leader := make(chan error, 1)
+	go func() {
+		_, _, err := c.do(ctx, "k", func() (*types.AddressResponse, error) {
+			close(started)
+			<-ctx.Done()
+			return nil, ctx.Err()
+		})
+		leader <- err
+	}()
+	<-started
+
+	waiter := make(chan *types.AddressResponse, 1)
+	go func() {
+		resp, _, err := c.do(context.Background(), "k",
+			func() (*types.AddressResponse, error) {
+				return &types.AddressResponse{Zip: "12345"}, nil
+			})
+		if err != nil {
+			t.Errorf("Got unexpected error: %v", err)
+		}
+		waiter <- resp
+	}()
+	time.Sleep(20 * time.Millisecond)
+	cancel()
+
+	if err := <-leader; err != context.Canceled {
+		t.Fatalf("Expected context canceled for the leader, got %v", err)
+	}
+	if resp := <-waiter; resp == nil || resp.Zip != "12345" {
+		t.Fatalf("Expected zip '12345' for the waiter, got %+v", resp)
+	}
+}
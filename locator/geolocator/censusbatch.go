@@ -0,0 +1,213 @@
+package geolocator
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"io"
+	"log"
+	"mime/multipart"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gdotgordon/locator-demo/locator/geoerr"
+	"github.com/gdotgordon/locator-demo/locator/types"
+)
+
+const (
+	// censusBatchPath is the path of the Census batch geocoder, which
+	// takes a CSV file of addresses as a multipart upload.
+	censusBatchPath = "locations/addressbatch"
+
+	// CensusBatchMax is the most rows the batch geocoder accepts in one
+	// upload.  Larger batches are split into multiple uploads.
+	CensusBatchMax = 10000
+)
+
+// LocateCSVBatch geocodes the addresses using the Census batch service.
+// The addresses are uploaded as CSV, CensusBatchMax rows at a time, and
+// the CSV response is parsed back into one result per address, in the
+// same order as the requests.  Each upload is recorded in the stats as
+// one batch operation, apart from the single lookups.
+func (cl *CensusGeolocator) LocateCSVBatch(ctx context.Context,
+	reqs []types.AddressRequest) ([]types.CensusBatchResult, error) {
+	results := make([]types.CensusBatchResult, 0, len(reqs))
+	for off := 0; off < len(reqs); off += CensusBatchMax {
+		end := off + CensusBatchMax
+		if end > len(reqs) {
+			end = len(reqs)
+		}
+		res, err := cl.uploadBatch(ctx, reqs[off:end], off)
+		if err != nil {
+			return nil, err
+		}
+		results = append(results, res...)
+	}
+	return results, nil
+}
+
+// uploadBatch sends one CSV file to the batch service.  The row ids are
+// the indices of the addresses in the full batch, starting at base.
+func (cl *CensusGeolocator) uploadBatch(ctx context.Context,
+	reqs []types.AddressRequest, base int) ([]types.CensusBatchResult, error) {
+	start := time.Now()
+	var err error
+	defer func() {
+		serr := err
+		cl.rec.sendStats(types.OpBatch, CensusProvider, start, serr)
+	}()
+
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+	if err = mw.WriteField("benchmark", cl.benchmark); err != nil {
+		return nil, err
+	}
+	fw, err := mw.CreateFormFile("addressFile", "addresses.csv")
+	if err != nil {
+		return nil, err
+	}
+	if err = writeBatchCSV(fw, reqs, base); err != nil {
+		return nil, err
+	}
+	if err = mw.Close(); err != nil {
+		return nil, err
+	}
+
+	var rows []types.CensusBatchResult
+	err = cl.retry.do(ctx, func() error {
+		var perr error
+		rows, perr = cl.postBatch(ctx, body.Bytes(),
+			mw.FormDataContentType())
+		return perr
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// The service returns the rows in no particular order, so put them
+	// back in request order using the ids.
+	results := make([]types.CensusBatchResult, len(reqs))
+	seen := make([]bool, len(reqs))
+	for _, row := range rows {
+		ndx, cerr := strconv.Atoi(row.ID)
+		if cerr != nil || ndx < base || ndx >= base+len(reqs) {
+			err = geoerr.Errorf(geoerr.BadPayload,
+				"Unexpected id '%s' in batch response", row.ID)
+			return nil, err
+		}
+		results[ndx-base] = row
+		seen[ndx-base] = true
+	}
+	for i, ok := range seen {
+		if !ok {
+			err = geoerr.Errorf(geoerr.BadPayload,
+				"No result for id %d in batch response", base+i)
+			return nil, err
+		}
+	}
+	return results, nil
+}
+
+// postBatch makes a single attempt at uploading the CSV file, and parses
+// the rows of the response.
+func (cl *CensusGeolocator) postBatch(ctx context.Context, body []byte,
+	contentType string) ([]types.CensusBatchResult, error) {
+	batchURL := cl.baseURL + censusBatchPath
+	req, err := http.NewRequest(http.MethodPost, batchURL,
+		bytes.NewReader(body))
+	if err != nil {
+		log.Printf("error creating request '%s': %v\n", batchURL, err)
+		return nil, err
+	}
+	req.Header.Set("Content-Type", contentType)
+	req = req.WithContext(ctx)
+	resp, err := cl.client.Do(req)
+	if err != nil {
+		log.Printf("error opening '%s': %v\n", batchURL, err)
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, geoerr.FromStatus(resp.StatusCode)
+	}
+	return parseBatchCSV(resp.Body)
+}
+
+// writeBatchCSV writes the addresses in the format the batch service
+// expects: unique id, street address, city, state, zip, with no header.
+// A one line address goes in the street column, with the others empty.
+func writeBatchCSV(w io.Writer, reqs []types.AddressRequest, base int) error {
+	cw := csv.NewWriter(w)
+	for i, r := range reqs {
+		street := strings.TrimSpace(r.StructureNumber + " " + r.Street)
+		if r.OneLine != "" {
+			street = r.OneLine
+		}
+		if err := cw.Write([]string{strconv.Itoa(base + i), street, r.City,
+			r.State, r.Zip}); err != nil {
+			return err
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// parseBatchCSV parses the batch service response.  A matched row looks
+// like:
+//
+//	"1","4600 Silver Hill Rd, Suitland, MD, 20746","Match","Exact",
+//	"4600 SILVER HILL RD, SUITLAND, MD, 20746","-76.92691,38.846542",
+//	"613199520","L"
+//
+// while unmatched and tied rows stop after the match indicator.
+func parseBatchCSV(r io.Reader) ([]types.CensusBatchResult, error) {
+	cr := csv.NewReader(r)
+	cr.FieldsPerRecord = -1
+	cr.LazyQuotes = true
+
+	var results []types.CensusBatchResult
+	for {
+		rec, err := cr.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, geoerr.Errorf(geoerr.BadPayload, "Invalid CSV: %v", err)
+		}
+		if len(rec) < 3 {
+			return nil, geoerr.Errorf(geoerr.BadPayload, "Short CSV row: %q", rec)
+		}
+
+		res := types.CensusBatchResult{ID: rec[0], InputAddress: rec[1],
+			Match: rec[2]}
+		if res.Match != types.CensusMatch {
+			results = append(results, res)
+			continue
+		}
+		if len(rec) < 8 {
+			return nil, geoerr.Errorf(geoerr.BadPayload, "Short CSV row for match: %q", rec)
+		}
+		res.Exact = rec[3] == "Exact"
+		res.MatchedAddress = rec[4]
+		xy := strings.Split(rec[5], ",")
+		if len(xy) != 2 {
+			return nil, geoerr.Errorf(geoerr.BadPayload, "Invalid coordinates '%s'", rec[5])
+		}
+		x, err := strconv.ParseFloat(strings.TrimSpace(xy[0]), 64)
+		if err != nil {
+			return nil, geoerr.Errorf(geoerr.BadPayload, "Invalid coordinates '%s'", rec[5])
+		}
+		y, err := strconv.ParseFloat(strings.TrimSpace(xy[1]), 64)
+		if err != nil {
+			return nil, geoerr.Errorf(geoerr.BadPayload, "Invalid coordinates '%s'", rec[5])
+		}
+		res.Coordinates = &types.Coords{X: x, Y: y}
+		res.TigerLineID = rec[6]
+		res.Side = rec[7]
+		results = append(results, res)
+	}
+	return results, nil
+}
@@ -0,0 +1,142 @@
+package geolocator
+
+import (
+	"context"
+	"encoding/csv"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gdotgordon/locator-demo/locator/store/storetest"
+	"github.com/gdotgordon/locator-demo/locator/types"
+)
+
+// batchStub mimics the Census batch service for a few known streets.  It
+// answers in reverse order, like the real service can.
+func batchStub(t *testing.T) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Path != "/locations/addressbatch" ||
+				r.FormValue("benchmark") != DefaultBenchmark {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			f, _, err := r.FormFile("addressFile")
+			if err != nil {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			recs, err := csv.NewReader(f).ReadAll()
+			if err != nil {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			var lines []string
+			for _, rec := range recs {
+				in := strings.Join(rec[1:], ", ")
+				var line string
+				switch rec[1] {
+				case "4600 Silver Hill Rd":
+					line = fmt.Sprintf(`"%s","%s","Match","Exact",`+
+						`"4600 SILVER HILL RD, SUITLAND, MD, 20746",`+
+						`"-76.92691,38.846542","613199520","L"`, rec[0], in)
+				case "1500 Red Rover St":
+					line = fmt.Sprintf(`"%s","%s","Match","Non_Exact",`+
+						`"1500 RED RIVER ST, AUSTIN, TX, 78701",`+
+						`"-97.73477,30.275732","63950042","R"`, rec[0], in)
+				case "1 Main St":
+					line = fmt.Sprintf(`"%s","%s","Tie"`, rec[0], in)
+				default:
+					line = fmt.Sprintf(`"%s","%s","No_Match"`, rec[0], in)
+				}
+				lines = append([]string{line}, lines...)
+			}
+			w.Header().Set("Content-Type", "text/csv")
+			fmt.Fprint(w, strings.Join(lines, "\n"))
+		}))
+}
+
+func TestLocateCSVBatch(t *testing.T) {
+	srv := batchStub(t)
+	defer srv.Close()
+
+	rs := &storetest.Store{}
+	cl, err := NewCensus(Config{ConnTimeout: 30, CensusURL: srv.URL}, rs)
+	if err != nil {
+		t.Fatalf("Got unexpected error: %v", err)
+	}
+
+	reqs := []types.AddressRequest{
+		{StructureNumber: "4600", Street: "Silver Hill Rd", City: "Suitland",
+			State: "MD", Zip: "20746"},
+		{StructureNumber: "46", Street: "Blue Bayou Ln", City: "San Ramon",
+			State: "CA"},
+		{StructureNumber: "1500", Street: "Red Rover St", City: "Austin",
+			State: "TX"},
+		{StructureNumber: "1", Street: "Main St"},
+	}
+	res, err := cl.LocateCSVBatch(context.Background(), reqs)
+	if err != nil {
+		t.Fatalf("Got unexpected error: %v", err)
+	}
+
+	for i, exp := range []types.CensusBatchResult{
+		{ID: "0", Match: types.CensusMatch, Exact: true,
+			MatchedAddress: "4600 SILVER HILL RD, SUITLAND, MD, 20746",
+			Coordinates:    &types.Coords{X: -76.92691, Y: 38.846542},
+			TigerLineID:    "613199520", Side: "L"},
+		{ID: "1", Match: types.CensusNoMatch},
+		{ID: "2", Match: types.CensusMatch,
+			MatchedAddress: "1500 RED RIVER ST, AUSTIN, TX, 78701",
+			Coordinates:    &types.Coords{X: -97.73477, Y: 30.275732},
+			TigerLineID:    "63950042", Side: "R"},
+		{ID: "3", Match: types.CensusTie},
+	} {
+		got := res[i]
+		if got.ID != exp.ID || got.Match != exp.Match || got.Exact != exp.Exact {
+			t.Fatalf("Expected %+v, got %+v", exp, got)
+		}
+		if got.MatchedAddress != exp.MatchedAddress ||
+			got.TigerLineID != exp.TigerLineID || got.Side != exp.Side {
+			t.Fatalf("Expected %+v, got %+v", exp, got)
+		}
+		if (got.Coordinates == nil) != (exp.Coordinates == nil) {
+			t.Fatalf("Expected coordinates %v, got %v", exp.Coordinates,
+				got.Coordinates)
+		}
+		if exp.Coordinates != nil && *got.Coordinates != *exp.Coordinates {
+			t.Fatalf("Expected coordinates %v, got %v", *exp.Coordinates,
+				*got.Coordinates)
+		}
+	}
+
+	// The upload is counted as a batch, not as a single lookup.
+	if n := rs.Count(types.StatsKey(types.OpBatch, "success")); n != 1 {
+		t.Fatalf("Expected 1 batch success, got %d", n)
+	}
+	if n := rs.Count(types.SuccessKey); n != 0 {
+		t.Fatalf("Expected no lookup successes, got %d", n)
+	}
+}
+
+func TestParseBatchCSVErrors(t *testing.T) {
+	for _, test := range []struct {
+		body string
+		e    string
+	}{
+		{body: `"1","x"`, e: `Short CSV row: ["1" "x"]`},
+		{body: `"1","x","Match","Exact","y"`,
+			e: `Short CSV row for match: ["1" "x" "Match" "Exact" "y"]`},
+		{body: `"1","x","Match","Exact","y","abc","1","L"`,
+			e: "Invalid coordinates 'abc'"},
+	} {
+		_, err := parseBatchCSV(strings.NewReader(test.body))
+		if err == nil {
+			t.Fatalf("Did not get expected error: %s", test.e)
+		} else if test.e != err.Error() {
+			t.Fatalf("Expected error '%s'', got '%s'", test.e, err.Error())
+		}
+	}
+}
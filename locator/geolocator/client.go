@@ -0,0 +1,145 @@
+package geolocator
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net"
+	"net/http"
+	"time"
+)
+
+// Defaults for the TransportConfig settings that are left zero.
+const (
+	DefaultMaxIdleConns        = 100
+	DefaultMaxIdleConnsPerHost = 10
+	DefaultIdleConnTimeout     = 90 * time.Second
+	DefaultKeepAlive           = 30 * time.Second
+)
+
+// TLSConfig controls how the connections to a provider's service are
+// secured.  Certificates are verified against the system roots, plus those
+// in the CAFile bundle if there is one.  CertFile and KeyFile are the
+// client certificate, for services that want one.  MinVersion is the
+// lowest TLS version allowed: "1.0", "1.1", "1.2" (the default) or "1.3".
+// Insecure turns off certificate verification altogether, and is only
+// meant for talking to local stubs.
+type TLSConfig struct {
+	CAFile     string
+	CertFile   string
+	KeyFile    string
+	MinVersion string
+	Insecure   bool
+}
+
+// TransportConfig tunes the connection handling of the HTTP client.
+// DisableHTTP2 keeps the client to HTTP/1.1.
+type TransportConfig struct {
+	MaxIdleConns        int
+	MaxIdleConnsPerHost int
+	IdleConnTimeout     time.Duration
+	KeepAlive           time.Duration
+	DisableHTTP2        bool
+}
+
+// tlsVersions maps the configured versions to the crypto/tls ones.
+var tlsVersions = map[string]uint16{
+	"1.0": tls.VersionTLS10,
+	"1.1": tls.VersionTLS11,
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+// newHTTPClient creates the client for calling a provider's service.
+// The one client is thread safe for use by the scanners.
+func newHTTPClient(cfg Config) (*http.Client, error) {
+	tc, err := newTLSConfig(cfg.TLS)
+	if err != nil {
+		return nil, err
+	}
+
+	trc := cfg.Transport
+	if trc.MaxIdleConns <= 0 {
+		trc.MaxIdleConns = DefaultMaxIdleConns
+	}
+	if trc.MaxIdleConnsPerHost <= 0 {
+		trc.MaxIdleConnsPerHost = DefaultMaxIdleConnsPerHost
+	}
+	if trc.IdleConnTimeout <= 0 {
+		trc.IdleConnTimeout = DefaultIdleConnTimeout
+	}
+	if trc.KeepAlive <= 0 {
+		trc.KeepAlive = DefaultKeepAlive
+	}
+	tr := &http.Transport{
+		Proxy: http.ProxyFromEnvironment,
+		DialContext: (&net.Dialer{
+			Timeout:   30 * time.Second,
+			KeepAlive: trc.KeepAlive,
+		}).DialContext,
+		TLSClientConfig:     tc,
+		TLSHandshakeTimeout: 10 * time.Second,
+		MaxIdleConns:        trc.MaxIdleConns,
+		MaxIdleConnsPerHost: trc.MaxIdleConnsPerHost,
+		IdleConnTimeout:     trc.IdleConnTimeout,
+
+		// Setting our own TLS config turns HTTP/2 off unless we ask.
+		ForceAttemptHTTP2: !trc.DisableHTTP2,
+	}
+	if trc.DisableHTTP2 {
+		tr.TLSNextProto = map[string]func(string,
+			*tls.Conn) http.RoundTripper{}
+	}
+
+	client := &http.Client{Transport: tr}
+	if cfg.ConnTimeout > 0 {
+		client.Timeout = time.Duration(cfg.ConnTimeout) * time.Second
+	}
+	return client, nil
+}
+
+// newTLSConfig builds the TLS settings of the client.
+func newTLSConfig(cfg TLSConfig) (*tls.Config, error) {
+	tc := &tls.Config{MinVersion: tls.VersionTLS12}
+	if cfg.MinVersion != "" {
+		v, ok := tlsVersions[cfg.MinVersion]
+		if !ok {
+			return nil, fmt.Errorf("Unknown TLS version '%s'", cfg.MinVersion)
+		}
+		tc.MinVersion = v
+	}
+
+	if cfg.CAFile != "" {
+		pem, err := ioutil.ReadFile(cfg.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("Error reading CA bundle: %v", err)
+		}
+		pool, err := x509.SystemCertPool()
+		if err != nil || pool == nil {
+			pool = x509.NewCertPool()
+		}
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("No certificates in CA bundle '%s'",
+				cfg.CAFile)
+		}
+		tc.RootCAs = pool
+	}
+
+	if cfg.CertFile != "" || cfg.KeyFile != "" {
+		cert, err := tls.LoadX509KeyPair(cfg.CertFile, cfg.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("Error loading client certificate: %v",
+				err)
+		}
+		tc.Certificates = []tls.Certificate{cert}
+	}
+
+	if cfg.Insecure {
+		log.Println("WARNING: TLS certificate verification is OFF. " +
+			"This is only safe for local stubs, never in production!")
+		tc.InsecureSkipVerify = true
+	}
+	return tc, nil
+}
@@ -0,0 +1,72 @@
+package geolocator
+
+import (
+	"context"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/gdotgordon/locator-demo/locator/types"
+)
+
+func TestTLSConfig(t *testing.T) {
+	srv := httptest.NewTLSServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "application/json")
+			fmt.Fprint(w, matchesJSON)
+		}))
+	defer srv.Close()
+
+	dir, err := ioutil.TempDir("", "tls")
+	if err != nil {
+		t.Fatalf("Got unexpected error: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	caFile := filepath.Join(dir, "ca.pem")
+	ca := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE",
+		Bytes: srv.Certificate().Raw})
+	if err := ioutil.WriteFile(caFile, ca, 0600); err != nil {
+		t.Fatalf("Got unexpected error: %v", err)
+	}
+	emptyFile := filepath.Join(dir, "empty.pem")
+	if err := ioutil.WriteFile(emptyFile, nil, 0600); err != nil {
+		t.Fatalf("Got unexpected error: %v", err)
+	}
+
+	req := types.AddressRequest{StructureNumber: "100", Street: "Main St"}
+	for i, test := range []struct {
+		tls    TLSConfig
+		newErr string
+		ok     bool
+	}{
+		{tls: TLSConfig{}, ok: false},
+		{tls: TLSConfig{CAFile: caFile}, ok: true},
+		{tls: TLSConfig{CAFile: caFile, MinVersion: "1.3"}, ok: true},
+		{tls: TLSConfig{Insecure: true}, ok: true},
+		{tls: TLSConfig{MinVersion: "2.0"},
+			newErr: "Unknown TLS version '2.0'"},
+		{tls: TLSConfig{CAFile: emptyFile},
+			newErr: "No certificates in CA bundle '" + emptyFile + "'"},
+	} {
+		l, err := NewCensus(Config{ConnTimeout: 30, CensusURL: srv.URL,
+			TLS: test.tls, Retry: RetryConfig{MaxRetries: -1}}, NoOpStore{})
+		if test.newErr != "" {
+			if err == nil || err.Error() != test.newErr {
+				t.Fatalf("%d: Expected error '%s', got %v", i, test.newErr, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("%d: Got unexpected error: %v", i, err)
+		}
+		_, err = l.Locate(context.Background(), req)
+		if ok := err == nil; ok != test.ok {
+			t.Fatalf("%d: Expected success %t, got error %v", i, test.ok, err)
+		}
+	}
+}
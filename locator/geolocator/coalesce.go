@@ -0,0 +1,82 @@
+package geolocator
+
+import (
+	"context"
+	"errors"
+	"sync"
+
+	"github.com/gdotgordon/locator-demo/locator/types"
+)
+
+// coalescer collapses concurrent lookups of the same key into one call.
+// The first caller for a key does the lookup, and the callers arriving
+// while it is in flight wait for it and share the result.
+type coalescer struct {
+	mu      sync.Mutex
+	flights map[string]*flight
+}
+
+// flight is a lookup in progress.  done is closed when it completes.
+type flight struct {
+	done chan struct{}
+	resp *types.AddressResponse
+	err  error
+}
+
+func newCoalescer() *coalescer {
+	return &coalescer{flights: make(map[string]*flight)}
+}
+
+// do calls fn for the key, unless a call for the key is already in
+// flight, in which case it waits for that call instead.  shared is true
+// if the result came from another caller's call, and each waiter gets
+// its own copy of the response.  A waiter whose context ends stops
+// waiting, while the call carries on for the others.  The call runs with
+// the context of the caller that made it, so if that caller goes away,
+// the waiters that haven't try again rather than fail with it.
+func (c *coalescer) do(ctx context.Context, key string,
+	fn func() (*types.AddressResponse, error)) (resp *types.AddressResponse,
+	shared bool, err error) {
+	for {
+		c.mu.Lock()
+		f, ok := c.flights[key]
+		if !ok {
+			break
+		}
+		c.mu.Unlock()
+		select {
+		case <-f.done:
+		case <-ctx.Done():
+			return nil, false, ctx.Err()
+		}
+		if isContextErr(f.err) && ctx.Err() == nil {
+			continue
+		}
+		if f.err != nil {
+			return nil, true, f.err
+		}
+		r := *f.resp
+		return &r, true, nil
+	}
+	f := &flight{done: make(chan struct{})}
+	c.flights[key] = f
+	c.mu.Unlock()
+
+	f.resp, f.err = fn()
+	c.mu.Lock()
+	delete(c.flights, key)
+	c.mu.Unlock()
+	close(f.done)
+
+	if f.err != nil {
+		return nil, false, f.err
+	}
+	r := *f.resp
+	return &r, false, nil
+}
+
+// isContextErr says whether the call ended because its context did.
+func isContextErr(err error) bool {
+	return errors.Is(err, context.Canceled) ||
+		errors.Is(err, context.DeadlineExceeded)
+}
@@ -0,0 +1,184 @@
+package geolocator
+
+import (
+	"context"
+	"errors"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/gdotgordon/locator-demo/locator/types"
+)
+
+// Defaults for the HealthConfig settings that are left zero.
+const (
+	DefaultHealthWindow = 10
+	DefaultMaxFailures  = 3
+	DefaultCooldown     = 30 * time.Second
+)
+
+// HealthConfig controls how a failover chain tracks the health of its
+// providers.  A provider with MaxFailures failures among its last Window
+// calls is skipped for the Cooldown period, after which it gets a fresh
+// start.  Each attempt is limited to AttemptTimeout, if set, so that a
+// slow provider fails over rather than using up the caller's deadline.
+type HealthConfig struct {
+	Window         int
+	MaxFailures    int
+	Cooldown       time.Duration
+	AttemptTimeout time.Duration
+}
+
+// FailoverLocator is an ordered chain of providers.  Each call goes to
+// the first healthy provider, and on to the next if that one fails.
+type FailoverLocator struct {
+	cfg   HealthConfig
+	links []*link
+	now   func() time.Time
+}
+
+// link is a provider in the chain along with its recent outcomes.
+type link struct {
+	name      string
+	loc       Geolocator
+	mu        sync.Mutex
+	outcomes  []bool
+	next      int
+	coolUntil time.Time
+}
+
+// NewFailover creates an empty failover chain.
+func NewFailover(cfg HealthConfig) *FailoverLocator {
+	if cfg.Window <= 0 {
+		cfg.Window = DefaultHealthWindow
+	}
+	if cfg.MaxFailures <= 0 {
+		cfg.MaxFailures = DefaultMaxFailures
+	}
+	if cfg.Cooldown <= 0 {
+		cfg.Cooldown = DefaultCooldown
+	}
+	return &FailoverLocator{cfg: cfg, now: time.Now}
+}
+
+// Add appends a named provider to the end of the chain.
+func (fl *FailoverLocator) Add(name string, loc Geolocator) {
+	fl.links = append(fl.links, &link{name: name, loc: loc})
+}
+
+// Locate tries each healthy provider in turn until one answers.  Invalid
+// requests are rejected up front, as no provider would do any better.
+func (fl *FailoverLocator) Locate(ctx context.Context,
+	reqAddr types.AddressRequest) (*types.AddressResponse, error) {
+	if err := validate(reqAddr); err != nil {
+		return nil, err
+	}
+
+	var resp *types.AddressResponse
+	err := fl.try(ctx, func(ctx context.Context, l *link) error {
+		var err error
+		resp, err = l.loc.Locate(ctx, reqAddr)
+		if err == nil {
+			resp.Provider = l.name
+		}
+		return err
+	})
+	return resp, err
+}
+
+// Reverse tries each healthy provider in turn until one answers.  The
+// providers that don't do reverse lookups are passed over.
+func (fl *FailoverLocator) Reverse(ctx context.Context,
+	coords types.Coords) (*types.ReverseResponse, error) {
+	if err := validateCoords(coords); err != nil {
+		return nil, err
+	}
+
+	var resp *types.ReverseResponse
+	err := fl.try(ctx, func(ctx context.Context, l *link) error {
+		var err error
+		resp, err = l.loc.Reverse(ctx, coords)
+		if err == nil {
+			resp.Provider = l.name
+		}
+		return err
+	})
+	return resp, err
+}
+
+// try calls the function for each provider in order, until it succeeds.
+// Providers that are cooling down are skipped, unless all of them are,
+// in which case we try them all anyway rather than fail outright.  The
+// error returned is the last provider's.
+func (fl *FailoverLocator) try(ctx context.Context,
+	call func(context.Context, *link) error) error {
+	var healthy []*link
+	for _, l := range fl.links {
+		if l.available(fl.now()) {
+			healthy = append(healthy, l)
+		}
+	}
+	if len(healthy) == 0 {
+		healthy = fl.links
+	}
+
+	err := errors.New("No geocoding providers configured")
+	for _, l := range healthy {
+		actx := ctx
+		cancel := func() {}
+		if fl.cfg.AttemptTimeout > 0 {
+			actx, cancel = context.WithTimeout(ctx, fl.cfg.AttemptTimeout)
+		}
+		err = call(actx, l)
+		cancel()
+
+		switch {
+		case err == nil:
+			l.record(true, fl.now(), fl.cfg)
+			return nil
+		case ctx.Err() != nil:
+			// The caller gave up, which says nothing about the provider.
+			return err
+		case err == ErrReverseUnsupported:
+			continue
+		}
+		l.record(false, fl.now(), fl.cfg)
+		log.Printf("provider '%s' failed, trying next: %v", l.name, err)
+	}
+	return err
+}
+
+// available says whether the provider is out of its cool-down period.
+func (l *link) available(now time.Time) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return !now.Before(l.coolUntil)
+}
+
+// record adds an outcome to the provider's window.  Once there are too
+// many failures in the window, the provider cools down, and its window is
+// cleared so it starts afresh afterwards.
+func (l *link) record(ok bool, now time.Time, cfg HealthConfig) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if len(l.outcomes) < cfg.Window {
+		l.outcomes = append(l.outcomes, ok)
+	} else {
+		l.outcomes[l.next] = ok
+		l.next = (l.next + 1) % cfg.Window
+	}
+
+	failures := 0
+	for _, o := range l.outcomes {
+		if !o {
+			failures++
+		}
+	}
+	if failures >= cfg.MaxFailures {
+		log.Printf("provider '%s' failing, skipping it for %s", l.name,
+			cfg.Cooldown)
+		l.coolUntil = now.Add(cfg.Cooldown)
+		l.outcomes = l.outcomes[:0]
+		l.next = 0
+	}
+}
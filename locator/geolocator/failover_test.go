@@ -0,0 +1,131 @@
+package geolocator
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/gdotgordon/locator-demo/locator/types"
+)
+
+// flakyLocator fails while down is set, and counts its calls.
+type flakyLocator struct {
+	down    bool
+	slow    time.Duration
+	calls   int
+	reverse bool
+}
+
+func (fl *flakyLocator) Locate(ctx context.Context,
+	req types.AddressRequest) (*types.AddressResponse, error) {
+	fl.calls++
+	if fl.slow > 0 {
+		select {
+		case <-time.After(fl.slow):
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+	if fl.down {
+		return nil, errors.New("HTTP status 503 : Service Unavailable")
+	}
+	return &types.AddressResponse{Zip: req.Zip, MatchCount: 1}, nil
+}
+
+func (fl *flakyLocator) Reverse(ctx context.Context,
+	coords types.Coords) (*types.ReverseResponse, error) {
+	fl.calls++
+	if !fl.reverse {
+		return nil, ErrReverseUnsupported
+	}
+	return &types.ReverseResponse{Coordinates: coords}, nil
+}
+
+func TestFailover(t *testing.T) {
+	primary, backup := &flakyLocator{}, &flakyLocator{reverse: true}
+	now := time.Now()
+	fl := NewFailover(HealthConfig{Window: 5, MaxFailures: 2,
+		Cooldown: time.Minute})
+	fl.now = func() time.Time { return now }
+	fl.Add("primary", primary)
+	fl.Add("backup", backup)
+
+	req := types.AddressRequest{StructureNumber: "1", Street: "Main St",
+		Zip: "12345"}
+	for _, test := range []struct {
+		down     bool
+		advance  time.Duration
+		provider string
+		primary  int
+	}{
+		// Healthy primary answers.
+		{down: false, provider: "primary", primary: 1},
+		// Primary fails, backup answers, but one failure is not enough
+		// to cool down.
+		{down: true, provider: "backup", primary: 2},
+		// Second failure cools the primary down.
+		{down: true, provider: "backup", primary: 3},
+		// Primary is skipped, even though it is back up.
+		{down: false, provider: "backup", primary: 3},
+		// After the cool-down, it is tried again.
+		{down: false, advance: time.Minute, provider: "primary", primary: 4},
+	} {
+		primary.down = test.down
+		now = now.Add(test.advance)
+		resp, err := fl.Locate(context.Background(), req)
+		if err != nil {
+			t.Fatalf("Got unexpected error: %v", err)
+		}
+		if resp.Provider != test.provider {
+			t.Fatalf("Expected provider '%s', got '%s'", test.provider,
+				resp.Provider)
+		}
+		if primary.calls != test.primary {
+			t.Fatalf("Expected %d primary calls, got %d", test.primary,
+				primary.calls)
+		}
+	}
+
+	// Invalid requests fail without calling anyone.
+	calls := primary.calls + backup.calls
+	if _, err := fl.Locate(context.Background(),
+		types.AddressRequest{Street: "Main St"}); err == nil {
+		t.Fatalf("Expected validation error")
+	}
+	if primary.calls+backup.calls != calls {
+		t.Fatalf("Expected no provider calls for invalid request")
+	}
+
+	// The primary doesn't do reverse lookups, so they go to the backup.
+	rr, err := fl.Reverse(context.Background(), types.Coords{X: 1, Y: 2})
+	if err != nil {
+		t.Fatalf("Got unexpected error: %v", err)
+	}
+	if rr.Provider != "backup" {
+		t.Fatalf("Expected provider 'backup', got '%s'", rr.Provider)
+	}
+
+	// When everything is down, we get the last error.
+	primary.down, backup.down = true, true
+	if _, err := fl.Locate(context.Background(), req); err == nil ||
+		err.Error() != "HTTP status 503 : Service Unavailable" {
+		t.Fatalf("Expected last provider error, got %v", err)
+	}
+}
+
+func TestFailoverTimeout(t *testing.T) {
+	slow, fast := &flakyLocator{slow: time.Second}, &flakyLocator{}
+	fl := NewFailover(HealthConfig{AttemptTimeout: 20 * time.Millisecond})
+	fl.Add("slow", slow)
+	fl.Add("fast", fast)
+
+	resp, err := fl.Locate(context.Background(), types.AddressRequest{
+		StructureNumber: "1", Street: "Main St"})
+	if err != nil {
+		t.Fatalf("Got unexpected error: %v", err)
+	}
+	if resp.Provider != "fast" {
+		t.Fatalf("Expected provider 'fast', got '%s'", resp.Provider)
+	}
+}
@@ -1,202 +1,479 @@
 // Package geolocator implements the geocoding lookups.  It reords statistics
 // such as latency and stores them in the store (which at runtime in configured
 // to Redis), and these will be received as events by the Analyzer.  It also
-// defines the generic Geolocator interface, and the implmentaiton we are using
-// here, the one from the US Census Bureau.
+// defines the generic Geolocator interface, and a registry of named providers
+// implementing it, the default being the one from the US Census Bureau.
 package geolocator
 
 import (
-	"bytes"
 	"context"
-	"crypto/tls"
-	"errors"
-	"fmt"
 	"io/ioutil"
 	"log"
 	"net/http"
+	"net/url"
+	"strconv"
 	"strings"
 	"time"
 
-	"github.com/gdotgordon/locator-demo/locator/locking"
+	"github.com/gdotgordon/locator-demo/locator/cache"
+	"github.com/gdotgordon/locator-demo/locator/geoerr"
+	"github.com/gdotgordon/locator-demo/locator/geoindex"
+	"github.com/gdotgordon/locator-demo/locator/ratelimit"
 	"github.com/gdotgordon/locator-demo/locator/store"
 	"github.com/gdotgordon/locator-demo/locator/types"
 	"github.com/tidwall/gjson"
 )
 
-// Geolocator has a single method, namely to look up the x,y for the address.
+// Geolocator looks up the x,y for an address, and in reverse, the Census
+// geographies containing an x,y.
 type Geolocator interface {
 	Locate(context.Context, types.AddressRequest) (*types.AddressResponse, error)
+	Reverse(context.Context, types.Coords) (*types.ReverseResponse, error)
 }
 
 const (
-	// CensusURL is the location of the geolocator service
-	CensusURL = "https://geocoding.geo.census.gov/geocoder/locations/address?"
+	// DefaultCensusURL is the base URL of the Census geocoding services.
+	// The services are at paths under it.
+	DefaultCensusURL = "https://geocoding.geo.census.gov/geocoder/"
 
-	// CensusStdPrm is the query param string for the URL
-	CensusStdPrm = "&benchmark=9&format=json"
+	// Paths of the Census services, relative to the base URL.  The
+	// locations services return the matching addresses, while the
+	// geographies variants also return the geographies containing them.
+	censusAddressPath    = "locations/address"
+	censusOneLinePath    = "locations/onelineaddress"
+	censusGeoAddrPath    = "geographies/address"
+	censusGeoOneLinePath = "geographies/onelineaddress"
+	censusReversePath    = "geographies/coordinates"
+
+	// DefaultBenchmark is the Census benchmark (address snapshot) used if
+	// none is configured.
+	DefaultBenchmark = "Public_AR_Current"
+
+	// DefaultVintage is the geographies vintage used if none is configured.
+	DefaultVintage = "Current_Current"
+
+	// DefaultLayers are the geography layers returned by enriched lookups
+	// if none are configured.
+	DefaultLayers = "all"
 )
 
+// Config has the settings for creating a Geolocator.
+type Config struct {
+	// Provider is the name of the registered provider to use.
+	Provider string
+
+	// Failover are the names of the providers to fall back to, in order,
+	// when the provider fails.
+	Failover []string
+
+	// Health is how the failover chain decides a provider is failing.
+	Health HealthConfig
+
+	// Cache, if set, caches the lookup results.  Found addresses are
+	// cached for CacheTTL, and addresses not found for NegativeCacheTTL.
+	// A zero TTL means that kind of result is not cached.
+	Cache            cache.Cache
+	CacheTTL         time.Duration
+	NegativeCacheTTL time.Duration
+
+	// Limiter, if set, limits the rate of calls to the provider.  Calls
+	// over the limit wait up to RateLimitWait for their turn, and then
+	// fail with ratelimit.ErrThrottled.
+	Limiter       ratelimit.Limiter
+	RateLimitWait time.Duration
+
+	// MemoryCacheSize, if positive, is the number of results kept in an
+	// in-process cache in front of Cache, with the same TTLs.  Concurrent
+	// identical lookups that miss it share one call to the provider.
+	MemoryCacheSize int
+
+	// Index, if set, is the geospatial index the located addresses are
+	// added to.
+	Index geoindex.Index
+
+	// NominatimURL is the base URL of the Nominatim service, for the
+	// nominatim provider.
+	NominatimURL string
+
+	// OfflineData is the path of the address range file, for the
+	// offline provider.
+	OfflineData string
+
+	// CensusURL is the base URL of the Census services, for the census
+	// provider.  It may point at a mirror or a stub.
+	CensusURL string
+
+	// Benchmark is the Census benchmark to geocode against, such as
+	// "Public_AR_Current" or "Public_AR_Census2020".
+	Benchmark string
+
+	// ConnTimeout is the timeout in seconds for calls to the service.
+	ConnTimeout int
+
+	// TLS and Transport configure the HTTP client of the service.
+	TLS       TLSConfig
+	Transport TransportConfig
+
+	// Retry controls the retries and circuit breaker of the calls to the
+	// Census service.
+	Retry RetryConfig
+
+	// Enrich adds the geographies to every lookup, not just the ones
+	// that ask for it.
+	Enrich bool
+
+	// Vintage is the Census vintage of the geographies, such as
+	// "Current_Current" or "Census2020_Current".
+	Vintage string
+
+	// Layers is a comma separated list of the geography layer names or
+	// ids to return in enriched lookups.
+	Layers string
+}
+
 // CensusGeolocator uses the free service at the US Census bureau.
 type CensusGeolocator struct {
-	client     *http.Client
-	store      store.Store
-	useLocking bool
+	client    *http.Client
+	retry     *retrier
+	rec       recorder
+	enrich    bool
+	baseURL   string
+	benchmark string
+	vintage   string
+	layers    string
 }
 
-// New creates a new CensusGeolocator
-func New(connTimeout int, store store.Store) Geolocator {
-	// The one client is thread safe for use by the scanners.
-	// Postman seems to complain about certificates, but no one else!
-	tr := &http.Transport{
-		TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
+func init() {
+	Register(CensusProvider, func(cfg Config,
+		store store.Store) (Geolocator, error) {
+		return NewCensus(cfg, store)
+	})
+}
+
+// NewCensus creates a new CensusGeolocator.  The store is only used for
+// the stats of the batch uploads and the circuit breaker events, as the
+// Geolocator returned by New sends the stats for the lookups.
+func NewCensus(cfg Config, store store.Store) (*CensusGeolocator, error) {
+	client, err := newHTTPClient(cfg)
+	if err != nil {
+		return nil, err
+	}
+	cl := &CensusGeolocator{client: client,
+		rec: recorder{store: store}, enrich: cfg.Enrich,
+		baseURL: cfg.CensusURL, benchmark: cfg.Benchmark,
+		vintage: cfg.Vintage, layers: cfg.Layers}
+	cl.retry = newRetrier(CensusProvider, cfg.Retry, func(name, state string) {
+		if err := store.Incr(types.BreakerKey(name, state)); err != nil {
+			log.Printf("error storing breaker event, skipped: %v", err)
+		}
+	})
+	if cl.baseURL == "" {
+		cl.baseURL = DefaultCensusURL
 	}
-	client := &http.Client{Transport: tr}
-	if connTimeout > 0 {
-		client.Timeout = time.Duration(connTimeout) * time.Second
+	if !strings.HasSuffix(cl.baseURL, "/") {
+		cl.baseURL += "/"
 	}
-	return &CensusGeolocator{client: client, store: store}
+	if cl.benchmark == "" {
+		cl.benchmark = DefaultBenchmark
+	}
+	if cl.vintage == "" {
+		cl.vintage = DefaultVintage
+	}
+	if cl.layers == "" {
+		cl.layers = DefaultLayers
+	}
+	return cl, nil
 }
 
-// Locate does a geolocation lookup.  At the end (in defer) gather up some
-// stats and store them in redis, triggering key events.
+// Locate does a geolocation lookup.  The benchmark and vintage of the
+// request, if set, override the configured ones.
 func (cl *CensusGeolocator) Locate(ctx context.Context,
 	reqAddr types.AddressRequest) (*types.AddressResponse, error) {
-	start := time.Now()
-	var err error
+	if err := validate(reqAddr); err != nil {
+		log.Printf("request invalid: %v", err)
+		return nil, err
+	}
+
+	// Set up the request URL based on the request objects passed in.
+	// A one line address is a single param for a different endpoint.
+	prm := url.Values{}
+	path, geoPath := censusAddressPath, censusGeoAddrPath
+	if reqAddr.OneLine != "" {
+		path, geoPath = censusOneLinePath, censusGeoOneLinePath
+		prm.Set("address", reqAddr.OneLine)
+	} else {
+		prm.Set("street", reqAddr.StructureNumber+" "+reqAddr.Street)
+		if reqAddr.City != "" {
+			prm.Set("city", reqAddr.City)
+		}
+		if reqAddr.State != "" {
+			prm.Set("state", reqAddr.State)
+		}
+		if reqAddr.Zip != "" {
+			prm.Set("zip", reqAddr.Zip)
+		}
+	}
 
-	// Here we invoke the function that sets the redis keys that
-	// will trigger notifications in the analyzer.
-	defer func() {
-		serr := err
-		cl.sendStats(start, serr)
-	}()
+	// Enriched lookups go to the geographies variant of the service,
+	// which takes the same address params, plus the vintage and layers.
+	prm.Set("benchmark", pick(reqAddr.Benchmark, cl.benchmark))
+	if reqAddr.Enrich || cl.enrich {
+		path = geoPath
+		prm.Set("vintage", pick(reqAddr.Vintage, cl.vintage))
+		prm.Set("layers", cl.layers)
+	}
+	prm.Set("format", "json")
 
+	js, err := cl.getJSON(ctx, cl.serviceURL(path, prm))
+	if err != nil {
+		return nil, err
+	}
+
+	return parseMatches(js, reqAddr), nil
+}
+
+// serviceURL is the URL of the service at the path, with the params.
+func (cl *CensusGeolocator) serviceURL(path string, prm url.Values) string {
+	return cl.baseURL + path + "?" + prm.Encode()
+}
+
+// pick returns the override if it is set, and the default otherwise.
+func pick(override, def string) string {
+	if override != "" {
+		return override
+	}
+	return def
+}
+
+// validate checks the request has either a one line address, or the
+// structure number and street of a structured one.
+func validate(reqAddr types.AddressRequest) error {
+	if reqAddr.OneLine != "" {
+		if reqAddr.StructureNumber != "" || reqAddr.Street != "" ||
+			reqAddr.City != "" || reqAddr.State != "" || reqAddr.Zip != "" {
+			return geoerr.New(geoerr.Validation,
+				"Oneline address cannot be combined with structured fields")
+		}
+		return nil
+	}
 	if reqAddr.StructureNumber == "" || reqAddr.Street == "" {
-		log.Printf("request invalid: missing unit number")
-		err = errors.New("Structure number and Street are required")
+		return geoerr.New(geoerr.Validation,
+			"Structure number and Street are required")
+	}
+	return nil
+}
+
+// validateCoords checks the coordinates are a valid longitude, latitude.
+func validateCoords(coords types.Coords) error {
+	if coords.X < -180 || coords.X > 180 || coords.Y < -90 || coords.Y > 90 {
+		return geoerr.Errorf(geoerr.Validation,
+			"Coordinates (%g, %g) out of range", coords.X, coords.Y)
+	}
+	return nil
+}
+
+// parseMatches extracts all the address matches from the Census JSON,
+// and fills in the response from the chosen one.  If there are no
+// matches, we just return an empty struct, which signifies "not found".
+// This is not a system malfunction (and we get HTTP 200), so no error.
+func parseMatches(js string, reqAddr types.AddressRequest) *types.AddressResponse {
+	var ar types.AddressResponse
+	gjson.Get(js, "result.addressMatches").ForEach(
+		func(_, m gjson.Result) bool {
+			ac := m.Get("addressComponents")
+			ar.Matches = append(ar.Matches, types.AddressMatch{
+				MatchedAddress: m.Get("matchedAddress").String(),
+				Coordinates: types.Coords{
+					X: m.Get("coordinates.x").Float(),
+					Y: m.Get("coordinates.y").Float(),
+				},
+				Components: types.AddressComponents{
+					FromAddress:     ac.Get("fromAddress").String(),
+					ToAddress:       ac.Get("toAddress").String(),
+					PreQualifier:    ac.Get("preQualifier").String(),
+					PreDirection:    ac.Get("preDirection").String(),
+					PreType:         ac.Get("preType").String(),
+					StreetName:      ac.Get("streetName").String(),
+					SuffixType:      ac.Get("suffixType").String(),
+					SuffixDirection: ac.Get("suffixDirection").String(),
+					SuffixQualifier: ac.Get("suffixQualifier").String(),
+					City:            ac.Get("city").String(),
+					State:           ac.Get("state").String(),
+					Zip:             ac.Get("zip").String(),
+				},
+				TigerLineID: m.Get("tigerLine.tigerLineId").String(),
+				Side:        m.Get("tigerLine.side").String(),
+			})
+			if geos := m.Get("geographies"); geos.Exists() {
+				g := parseGeographies(geos)
+				ar.Matches[len(ar.Matches)-1].Geographies = &g
+			}
+			return true
+		})
+	ar.MatchCount = len(ar.Matches)
+	if ar.MatchCount == 0 {
+		ar.Matches = nil
+		return &ar
+	}
+
+	best := ar.Matches[chooseMatch(ar.Matches, reqAddr)]
+	ar.Zip = best.Components.Zip
+	ar.Coordinates = best.Coordinates
+	ar.MatchedAddress = best.MatchedAddress
+	ar.Components = &best.Components
+	ar.TigerLineID = best.TigerLineID
+	ar.Side = best.Side
+	ar.Geographies = best.Geographies
+	return &ar
+}
+
+// chooseMatch returns the index of the match to use.  By default this is
+// the first, as listed by the service.  If the request prefers a match
+// that agrees with the input, we pick the one agreeing with the most of
+// the zip, city and state supplied, with the earliest winning ties.
+func chooseMatch(matches []types.AddressMatch,
+	reqAddr types.AddressRequest) int {
+	if !reqAddr.PreferInputMatch {
+		return 0
+	}
+
+	best, bestScore := 0, -1
+	for i, m := range matches {
+		score := 0
+		if reqAddr.Zip != "" && reqAddr.Zip == m.Components.Zip {
+			score++
+		}
+		if reqAddr.City != "" && strings.EqualFold(reqAddr.City,
+			m.Components.City) {
+			score++
+		}
+		if reqAddr.State != "" && strings.EqualFold(reqAddr.State,
+			m.Components.State) {
+			score++
+		}
+		if score > bestScore {
+			best, bestScore = i, score
+		}
+	}
+	return best
+}
+
+// Reverse finds the Census geographies (state, county, tract and block)
+// containing the coordinates.  A point outside of any Census block (say
+// in the ocean) returns empty geographies.
+func (cl *CensusGeolocator) Reverse(ctx context.Context,
+	coords types.Coords) (*types.ReverseResponse, error) {
+	if err := validateCoords(coords); err != nil {
 		return nil, err
 	}
 
-	// Set up the request URL based on the request objects passed in.
-	var buf bytes.Buffer
-	buf.WriteString("street=")
-	buf.WriteString(reqAddr.StructureNumber)
-	buf.WriteByte(' ')
-	buf.WriteString(reqAddr.Street)
-	if reqAddr.City != "" {
-		buf.WriteString("&city=")
-		buf.WriteString(reqAddr.City)
-	}
-	if reqAddr.State != "" {
-		buf.WriteString("&state=")
-		buf.WriteString(reqAddr.State)
-	}
-	if reqAddr.Zip != "" {
-		buf.WriteString("&zip=")
-		buf.WriteString(reqAddr.Zip)
-	}
-	buf.WriteString(CensusStdPrm)
-
-	// Bleh: the web site doesn't like Go's escaped '='
-	// qs = url.QueryEscape(buf.String())
-	qs := strings.Replace(buf.String(), " ", "+", -1)
-	reqURL := CensusURL + qs
+	prm := url.Values{}
+	prm.Set("x", strconv.FormatFloat(coords.X, 'f', -1, 64))
+	prm.Set("y", strconv.FormatFloat(coords.Y, 'f', -1, 64))
+	prm.Set("benchmark", cl.benchmark)
+	prm.Set("vintage", cl.vintage)
+	prm.Set("format", "json")
+	reqURL := cl.serviceURL(censusReversePath, prm)
+	js, err := cl.getJSON(ctx, reqURL)
+	if err != nil {
+		return nil, err
+	}
+
+	rr := types.ReverseResponse{Coordinates: coords,
+		Geographies: parseGeographies(gjson.Get(js, "result.geographies"))}
+	return &rr, nil
+}
+
+// parseGeographies pulls the FIPS codes out of the geography layers.
+// Each layer is an array of the areas containing the point, of which
+// there is normally just one.  Some layer names have the year in them,
+// for example "2020 Census Blocks" or "119th Congressional Districts",
+// so we match on the end of the name.
+func parseGeographies(geos gjson.Result) types.Geographies {
+	var g types.Geographies
+	set := func(field *string, val gjson.Result) {
+		if *field == "" {
+			*field = val.String()
+		}
+	}
+	geos.ForEach(func(layer, val gjson.Result) bool {
+		name := layer.String()
+		area := val.Get("0")
+		switch {
+		case strings.HasSuffix(name, "Census Blocks"):
+			set(&g.State, area.Get("STATE"))
+			set(&g.County, area.Get("COUNTY"))
+			set(&g.Tract, area.Get("TRACT"))
+			set(&g.BlockGroup, area.Get("BLKGRP"))
+			set(&g.Block, area.Get("BLOCK"))
+		case strings.HasSuffix(name, "Census Block Groups"):
+			set(&g.BlockGroup, area.Get("BLKGRP"))
+		case strings.HasSuffix(name, "Census Tracts"):
+			set(&g.Tract, area.Get("TRACT"))
+		case name == "Counties":
+			set(&g.County, area.Get("COUNTY"))
+		case name == "States":
+			set(&g.State, area.Get("STATE"))
+		case strings.HasSuffix(name, "Congressional Districts"):
+			set(&g.CongressionalDistrict, area.Get("BASENAME"))
+		}
+		return true
+	})
+	return g
+}
+
+// getJSON does the GET request to the Census service and returns the
+// validated JSON body, retrying transient failures.
+func (cl *CensusGeolocator) getJSON(ctx context.Context,
+	reqURL string) (string, error) {
+	var js string
+	err := cl.retry.do(ctx, func() error {
+		var err error
+		js, err = cl.getOnce(ctx, reqURL)
+		return err
+	})
+	return js, err
+}
+
+// getOnce makes a single attempt at the GET request.
+func (cl *CensusGeolocator) getOnce(ctx context.Context,
+	reqURL string) (string, error) {
 	req, err := http.NewRequest(http.MethodGet, reqURL, nil)
 	if err != nil {
 		log.Printf("error creating request '%s': %v\n", reqURL, err)
-		return nil, err
+		return "", err
 	}
 	req.Header.Add("Content-type", "application/json")
 	req = req.WithContext(ctx)
 	resp, err := cl.client.Do(req)
 	if err != nil {
 		log.Printf("error opening '%s': %v\n", reqURL, err)
-		return nil, err
+		return "", err
 	}
 	defer resp.Body.Close()
 
 	if resp.StatusCode != http.StatusOK {
-		log.Printf("location lookup failed '%s': %v\n", reqURL, err)
-		err = fmt.Errorf("HTTP status %d : %s", resp.StatusCode,
-			http.StatusText(resp.StatusCode))
-		return nil, err
+		log.Printf("location lookup failed '%s': %d\n", reqURL,
+			resp.StatusCode)
+		return "", geoerr.FromStatus(resp.StatusCode)
 	}
 	ct := resp.Header.Get("Content-type")
 	if !strings.HasPrefix(ct, "application/json") {
-		err = fmt.Errorf("Unexpected content type '%s,", ct)
-		return nil, err
+		return "", geoerr.Errorf(geoerr.BadPayload,
+			"Unexpected content type '%s,", ct)
 	}
 
-	var ar types.AddressResponse
 	b, err := ioutil.ReadAll(resp.Body)
 	if err != nil {
-		err = fmt.Errorf("Error reading repsonse '%v,", err)
-		return nil, err
+		return "", geoerr.Errorf(geoerr.UpstreamUnavailable,
+			"Error reading repsonse '%v,", err)
 	}
 
 	// The gjson package turns out to be far less cumbersome in extracting
 	// fields from a complex JSON object, compared to encoding/json.
 	js := string(b)
 	if !gjson.Valid(js) {
-		err = fmt.Errorf("Invalid JSON")
-		return nil, err
-	}
-
-	// If no matches, just return an empty struct, which sifgnifies "not found".
-	// This is not a system malfucntion (and we get HTTP 200), so no error.
-	rj := gjson.Get(js, "result.addressMatches.#")
-	if rj.Int() == 0 {
-		return &ar, nil
-	}
-
-	// Use the first match.
-	rj = gjson.Get(js, "result.addressMatches.0.addressComponents.zip")
-	ar.Zip = rj.String()
-	rj = gjson.Get(js, "result.addressMatches.0.coordinates")
-	m := rj.Map()
-	ar.Coordinates.X = m["x"].Float()
-	ar.Coordinates.Y = m["y"].Float()
-	return &ar, nil
-}
-
-// Here is where all the redis keys are set.  The store object (cl.store)
-// is the encapsulation of the actual redis calls (see store/store.go).
-//
-// Note, the object locking, discussed in the writeup, is not enabled
-// here, due to the weakness of the Redis-suggested algorithm, plus it
-// is not needed given the semantics of the parameters in terms of
-// the order thy are received.  But you may enable it, and it will
-// work fine for reasonably small numbers of concurrent requests.
-func (cl *CensusGeolocator) sendStats(start time.Time, gerr error) {
-	var err error
-	var lock *locking.Lock
-	if cl.useLocking {
-		lock, err = cl.store.AcquireLock()
-		if err != nil {
-			log.Printf("error acquiring lock, stats not saved: %v", err)
-			return
-		}
-	}
-
-	// Store the parameters of interest.
-	if err = cl.store.StoreLatency(time.Now().Sub(start)); err != nil {
-		log.Printf("error storing latency, skipped: %v", err)
-	}
-
-	if gerr != nil {
-		if err = cl.store.AddError(); err != nil {
-			log.Printf("error storing error, skipped: %v", err)
-		}
-	} else {
-		if err = cl.store.AddSuccess(); err != nil {
-			log.Printf("error storing error, skipped: %v", err)
-		}
-	}
-
-	// Meh.
-	if cl.useLocking {
-		if err = cl.store.Unlock(lock); err != nil {
-			log.Printf("error unlocking, stats not saved: %v", err)
-		}
+		return "", geoerr.New(geoerr.BadPayload, "Invalid JSON")
 	}
+	return js, nil
 }
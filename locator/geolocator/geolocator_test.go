@@ -2,7 +2,12 @@ package geolocator
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
 	"testing"
 	"time"
 
@@ -13,16 +18,22 @@ import (
 type NoOpStore struct {
 }
 
-func (nos NoOpStore) StoreLatency(d time.Duration) error {
-	fmt.Printf("Storing duration: %s\n", d.String())
+func (nos NoOpStore) StoreLatency(op string, d time.Duration) error {
+	fmt.Printf("Storing %s duration: %s\n", op, d.String())
 	return nil
 }
 
-func (nos NoOpStore) AddSuccess() error {
+func (nos NoOpStore) StoreProviderLatency(provider string,
+	d time.Duration) error {
 	return nil
 }
 
-func (nos NoOpStore) AddError() error {
+func (nos NoOpStore) StoreStepLatency(stepType string,
+	d time.Duration) error {
+	return nil
+}
+
+func (nos NoOpStore) Incr(key string) error {
 	return nil
 }
 
@@ -38,8 +49,25 @@ func (nos NoOpStore) Unlock(lock *locking.Lock) error {
 	return nil
 }
 
+// TestLookup calls the Census service, so it is skipped with -short, or
+// when the service can't be reached.  TestLookupOffline does the same
+// lookups against the fixture data.
 func TestLookup(t *testing.T) {
-	l := New(30, NoOpStore{})
+	if testing.Short() {
+		t.Skip("Skipping the Census lookups in short mode")
+	}
+	u, err := url.Parse(DefaultCensusURL)
+	if err != nil {
+		t.Fatalf("Got unexpected error: %v", err)
+	}
+	if _, err := net.LookupHost(u.Hostname()); err != nil {
+		t.Skipf("Skipping the Census lookups, no network: %v", err)
+	}
+
+	l, err := New(Config{ConnTimeout: 30}, NoOpStore{})
+	if err != nil {
+		t.Fatalf("Got unexpected error: %v", err)
+	}
 
 	for _, test := range []struct {
 		rq types.AddressRequest
@@ -93,3 +121,285 @@ func TestLookup(t *testing.T) {
 		}
 	}
 }
+
+const reverseJSON = `{"result": {
+  "input": {"location": {"x": -76.92691, "y": 38.846542}},
+  "geographies": {
+    "States": [{"STATE": "24", "NAME": "Maryland"}],
+    "2020 Census Blocks": [{"GEOID": "240338024051010", "STATE": "24",
+      "COUNTY": "033", "TRACT": "802405", "BLOCK": "1010"}]
+  }}}`
+
+func TestReverse(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "application/json")
+			if r.FormValue("x") == "-76.92691" && r.FormValue("y") == "38.846542" {
+				fmt.Fprint(w, reverseJSON)
+				return
+			}
+			fmt.Fprint(w, `{"result": {"geographies": {}}}`)
+		}))
+	defer srv.Close()
+
+	l, err := NewCensus(Config{ConnTimeout: 30, CensusURL: srv.URL}, NoOpStore{})
+	if err != nil {
+		t.Fatalf("Got unexpected error: %v", err)
+	}
+
+	for _, test := range []struct {
+		rq types.Coords
+		rs types.Geographies
+		e  string
+	}{
+		{
+			rq: types.Coords{X: -76.92691, Y: 38.846542},
+			rs: types.Geographies{State: "24", County: "033", Tract: "802405",
+				Block: "1010"},
+		},
+		{
+			rq: types.Coords{X: -40, Y: 30},
+			rs: types.Geographies{},
+		},
+		{
+			rq: types.Coords{X: -200, Y: 30},
+			e:  "Coordinates (-200, 30) out of range",
+		},
+	} {
+		resp, err := l.Reverse(context.Background(), test.rq)
+		if test.e != "" {
+			if err == nil {
+				t.Fatalf("Did not get expected error: %s", test.e)
+			} else if test.e != err.Error() {
+				t.Fatalf("Expected error '%s'', got '%s'", test.e, err.Error())
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("Got unexpected error: %v", err)
+		}
+		if resp.Geographies != test.rs {
+			t.Fatalf("Expected geographies %+v, got %+v", test.rs,
+				resp.Geographies)
+		}
+		if resp.Coordinates != test.rq {
+			t.Fatalf("Expected coordinates %+v, got %+v", test.rq,
+				resp.Coordinates)
+		}
+	}
+}
+
+const matchesJSON = `{"result": {"addressMatches": [
+  {"tigerLine": {"side": "R", "tigerLineId": "76218380"},
+   "coordinates": {"x": -77.12345, "y": 39.01234},
+   "addressComponents": {"zip": "20814", "streetName": "MAIN",
+     "city": "BETHESDA", "fromAddress": "100", "toAddress": "198",
+     "state": "MD", "suffixType": "ST"},
+   "matchedAddress": "100 MAIN ST, BETHESDA, MD, 20814"},
+  {"tigerLine": {"side": "L", "tigerLineId": "618331507"},
+   "coordinates": {"x": -76.5, "y": 39.5},
+   "addressComponents": {"zip": "21014", "streetName": "MAIN",
+     "city": "BEL AIR", "fromAddress": "99", "toAddress": "199",
+     "state": "MD", "suffixType": "ST"},
+   "matchedAddress": "100 MAIN ST, BEL AIR, MD, 21014"}
+]}}`
+
+func TestParseMatches(t *testing.T) {
+	for _, test := range []struct {
+		js  string
+		rq  types.AddressRequest
+		cnt int
+		ma  string
+		tl  string
+		sd  string
+	}{
+		{
+			js:  matchesJSON,
+			rq:  types.AddressRequest{StructureNumber: "100", Street: "Main St", City: "Bel Air", State: "MD"},
+			cnt: 2, ma: "100 MAIN ST, BETHESDA, MD, 20814", tl: "76218380", sd: "R",
+		},
+		{
+			js: matchesJSON,
+			rq: types.AddressRequest{StructureNumber: "100", Street: "Main St", City: "Bel Air", State: "MD",
+				PreferInputMatch: true},
+			cnt: 2, ma: "100 MAIN ST, BEL AIR, MD, 21014", tl: "618331507", sd: "L",
+		},
+		{
+			js: matchesJSON,
+			rq: types.AddressRequest{StructureNumber: "100", Street: "Main St", State: "MD",
+				PreferInputMatch: true},
+			cnt: 2, ma: "100 MAIN ST, BETHESDA, MD, 20814", tl: "76218380", sd: "R",
+		},
+		{
+			js:  `{"result": {"addressMatches": []}}`,
+			rq:  types.AddressRequest{StructureNumber: "46", Street: "Blue Bayou Ln"},
+			cnt: 0,
+		},
+	} {
+		resp := parseMatches(test.js, test.rq)
+		if resp.MatchCount != test.cnt || len(resp.Matches) != test.cnt {
+			t.Fatalf("Expected %d matches, got %d (%d)", test.cnt,
+				resp.MatchCount, len(resp.Matches))
+		}
+		if test.cnt == 0 {
+			if resp.Zip != "" || resp.Components != nil {
+				t.Fatalf("Expected empty response, got %+v", resp)
+			}
+			continue
+		}
+		if resp.MatchedAddress != test.ma {
+			t.Fatalf("Expected matched address '%s', got '%s'", test.ma,
+				resp.MatchedAddress)
+		}
+		if resp.TigerLineID != test.tl || resp.Side != test.sd {
+			t.Fatalf("Expected TIGER line %s/%s, got %s/%s", test.tl, test.sd,
+				resp.TigerLineID, resp.Side)
+		}
+		if resp.Zip != resp.Components.Zip {
+			t.Fatalf("Expected zip '%s', got '%s'", resp.Components.Zip, resp.Zip)
+		}
+		if resp.Components.StreetName != "MAIN" || resp.Components.SuffixType != "ST" {
+			t.Fatalf("Unexpected address components: %+v", *resp.Components)
+		}
+	}
+
+	v1, err := json.Marshal(parseMatches(matchesJSON, types.AddressRequest{}).V1())
+	if err != nil {
+		t.Fatalf("Got unexpected error: %v", err)
+	}
+	if exp := `{"zip":"20814","coordinates":{"x":-77.12345,"y":39.01234}}`; string(v1) != exp {
+		t.Fatalf("Expected v1 response '%s', got '%s'", exp, v1)
+	}
+}
+
+const enrichedJSON = `{"result": {"addressMatches": [
+  {"tigerLine": {"side": "L", "tigerLineId": "613199520"},
+   "coordinates": {"x": -76.92691, "y": 38.846542},
+   "addressComponents": {"zip": "20746", "streetName": "SILVER HILL",
+     "city": "SUITLAND", "state": "MD", "suffixType": "RD"},
+   "matchedAddress": "4600 SILVER HILL RD, SUITLAND, MD, 20746",
+   "geographies": {
+     "States": [{"STATE": "24", "BASENAME": "Maryland"}],
+     "Counties": [{"STATE": "24", "COUNTY": "033"}],
+     "Census Tracts": [{"STATE": "24", "COUNTY": "033", "TRACT": "802405"}],
+     "Census Block Groups": [{"TRACT": "802405", "BLKGRP": "1"}],
+     "2020 Census Blocks": [{"STATE": "24", "COUNTY": "033",
+       "TRACT": "802405", "BLKGRP": "1", "BLOCK": "1010"}],
+     "119th Congressional Districts": [{"STATE": "24", "BASENAME": "5",
+       "CD119": "05"}]
+   }}
+]}}`
+
+func TestEnrichedLookup(t *testing.T) {
+	var query url.Values
+	srv := httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			query = r.URL.Query()
+			w.Header().Set("Content-Type", "application/json")
+			fmt.Fprint(w, enrichedJSON)
+		}))
+	defer srv.Close()
+
+	l, err := NewCensus(Config{ConnTimeout: 30, CensusURL: srv.URL,
+		Vintage: "Census2020_Current", Layers: "States,Counties"}, NoOpStore{})
+	if err != nil {
+		t.Fatalf("Got unexpected error: %v", err)
+	}
+
+	resp, err := l.Locate(context.Background(), types.AddressRequest{
+		StructureNumber: "4600", Street: "Silver Hill Rd", City: "Suitland",
+		State: "MD", Zip: "20746", Enrich: true})
+	if err != nil {
+		t.Fatalf("Got unexpected error: %v", err)
+	}
+	if query.Get("vintage") != "Census2020_Current" ||
+		query.Get("layers") != "States,Counties" ||
+		query.Get("benchmark") != DefaultBenchmark {
+		t.Fatalf("Unexpected query: %v", query)
+	}
+	if resp.Geographies == nil {
+		t.Fatalf("Expected geographies in response")
+	}
+	exp := types.Geographies{State: "24", County: "033", Tract: "802405",
+		BlockGroup: "1", Block: "1010", CongressionalDistrict: "5"}
+	if *resp.Geographies != exp {
+		t.Fatalf("Expected geographies %+v, got %+v", exp, *resp.Geographies)
+	}
+	if resp.Matches[0].Geographies == nil {
+		t.Fatalf("Expected geographies in match")
+	}
+
+	// The request may override the benchmark and vintage.
+	if _, err := l.Locate(context.Background(), types.AddressRequest{
+		StructureNumber: "4600", Street: "Silver Hill Rd", Enrich: true,
+		Benchmark: "Public_AR_Census2020", Vintage: "Census2020_Census2020",
+	}); err != nil {
+		t.Fatalf("Got unexpected error: %v", err)
+	}
+	if query.Get("benchmark") != "Public_AR_Census2020" ||
+		query.Get("vintage") != "Census2020_Census2020" ||
+		query.Get("street") != "4600 Silver Hill Rd" {
+		t.Fatalf("Unexpected query: %v", query)
+	}
+}
+
+func TestOneLineLookup(t *testing.T) {
+	var path string
+	var query url.Values
+	srv := httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			path, query = r.URL.Path, r.URL.Query()
+			w.Header().Set("Content-Type", "application/json")
+			fmt.Fprint(w, enrichedJSON)
+		}))
+	defer srv.Close()
+
+	l, err := NewCensus(Config{ConnTimeout: 30, CensusURL: srv.URL + "/"},
+		NoOpStore{})
+	if err != nil {
+		t.Fatalf("Got unexpected error: %v", err)
+	}
+
+	for _, test := range []struct {
+		rq   types.AddressRequest
+		path string
+		e    string
+	}{
+		{
+			rq:   types.AddressRequest{OneLine: "4600 Silver Hill Rd, Suitland, MD 20746"},
+			path: "/locations/onelineaddress",
+		},
+		{
+			rq:   types.AddressRequest{OneLine: "4600 Silver Hill Rd #2, Suitland, MD", Enrich: true},
+			path: "/geographies/onelineaddress",
+		},
+		{
+			rq: types.AddressRequest{OneLine: "4600 Silver Hill Rd, Suitland, MD",
+				Zip: "20746"},
+			e: "Oneline address cannot be combined with structured fields",
+		},
+	} {
+		resp, err := l.Locate(context.Background(), test.rq)
+		if test.e != "" {
+			if err == nil {
+				t.Fatalf("Did not get expected error: %s", test.e)
+			} else if test.e != err.Error() {
+				t.Fatalf("Expected error '%s'', got '%s'", test.e, err.Error())
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("Got unexpected error: %v", err)
+		}
+		if path != test.path {
+			t.Fatalf("Expected path '%s', got '%s'", test.path, path)
+		}
+		if query.Get("address") != test.rq.OneLine {
+			t.Fatalf("Expected address '%s', got '%s'", test.rq.OneLine,
+				query.Get("address"))
+		}
+		if resp.Zip != "20746" {
+			t.Fatalf("Expected zip '20746', got '%s'", resp.Zip)
+		}
+	}
+}
@@ -0,0 +1,39 @@
+package geolocator
+
+import (
+	"context"
+	"log"
+
+	"github.com/gdotgordon/locator-demo/locator/geoindex"
+	"github.com/gdotgordon/locator-demo/locator/types"
+)
+
+// indexingLocator adds every address it locates to the geospatial index,
+// so that it can be found by searching around it later.  Cached responses
+// are indexed again too, which keeps the time they were last located up
+// to date.  Failing to index an address doesn't fail the lookup.
+type indexingLocator struct {
+	loc   Geolocator
+	index geoindex.Index
+}
+
+func (il *indexingLocator) Locate(ctx context.Context,
+	reqAddr types.AddressRequest) (*types.AddressResponse, error) {
+	resp, err := il.loc.Locate(ctx, reqAddr)
+	if err != nil {
+		return nil, err
+	}
+	if resp.MatchCount > 0 {
+		if p := geoindex.PlaceOf(resp); p.Address != "" {
+			if err := il.index.Add(p); err != nil {
+				log.Printf("error indexing address, skipped: %v", err)
+			}
+		}
+	}
+	return resp, nil
+}
+
+func (il *indexingLocator) Reverse(ctx context.Context,
+	coords types.Coords) (*types.ReverseResponse, error) {
+	return il.loc.Reverse(ctx, coords)
+}
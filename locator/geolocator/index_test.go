@@ -0,0 +1,49 @@
+package geolocator
+
+import (
+	"context"
+	"sync"
+	"testing"
+
+	"github.com/gdotgordon/locator-demo/locator/geoindex"
+	"github.com/gdotgordon/locator-demo/locator/types"
+)
+
+// memIndex keeps the places it is given.
+type memIndex struct {
+	mu     sync.Mutex
+	places map[string]types.Place
+}
+
+func (mi *memIndex) Add(p types.Place) error {
+	mi.mu.Lock()
+	defer mi.mu.Unlock()
+	mi.places[p.Address] = p
+	return nil
+}
+
+func (mi *memIndex) Search(q geoindex.Query) (*types.PlacesResponse, error) {
+	return &types.PlacesResponse{}, nil
+}
+
+func TestIndexing(t *testing.T) {
+	idx := &memIndex{places: make(map[string]types.Place)}
+	l := &indexingLocator{loc: &normalizingLocator{loc: &stubLocator{}},
+		index: idx}
+	for _, street := range []string{"Main Street", "nowhere", "bad"} {
+		l.Locate(context.Background(), types.AddressRequest{
+			StructureNumber: "1", Street: street, Zip: "12345"})
+	}
+
+	if len(idx.places) != 1 {
+		t.Fatalf("Expected 1 indexed address, got %d", len(idx.places))
+	}
+	p, ok := idx.places["1 MAIN ST, 12345"]
+	if !ok {
+		t.Fatalf("Expected '1 MAIN ST, 12345' to be indexed, got %v",
+			idx.places)
+	}
+	if p.Coordinates != (types.Coords{X: 1, Y: 2}) || p.Located.IsZero() {
+		t.Fatalf("Expected coordinates and time located, got %+v", p)
+	}
+}
@@ -0,0 +1,179 @@
+package geolocator
+
+import (
+	"context"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/gdotgordon/locator-demo/locator/geoerr"
+	"github.com/gdotgordon/locator-demo/locator/store"
+	"github.com/gdotgordon/locator-demo/locator/types"
+	"github.com/tidwall/gjson"
+)
+
+const (
+	// DefaultNominatimURL is the public OpenStreetMap Nominatim service.
+	// Its usage policy allows very light use only, so for anything more,
+	// point the provider at a self-hosted instance.
+	DefaultNominatimURL = "https://nominatim.openstreetmap.org"
+
+	// nominatimLimit is the most candidates we ask Nominatim for.
+	nominatimLimit = "10"
+
+	// userAgent identifies us to the services, which Nominatim requires.
+	userAgent = "locator-demo"
+)
+
+// ErrReverseUnsupported is returned by providers that cannot find the
+// Census geographies for coordinates.
+var ErrReverseUnsupported error = geoerr.New(geoerr.Unsupported,
+	"Reverse lookups are not supported by this provider")
+
+// NominatimGeolocator looks up addresses with a service speaking the
+// Nominatim JSON search API, such as OpenStreetMap's.
+type NominatimGeolocator struct {
+	client  *http.Client
+	baseURL string
+}
+
+func init() {
+	Register(NominatimProvider, func(cfg Config,
+		store store.Store) (Geolocator, error) {
+		return NewNominatim(cfg)
+	})
+}
+
+// NewNominatim creates a new NominatimGeolocator for the configured URL.
+func NewNominatim(cfg Config) (*NominatimGeolocator, error) {
+	base := cfg.NominatimURL
+	if base == "" {
+		base = DefaultNominatimURL
+	}
+	client, err := newHTTPClient(cfg)
+	if err != nil {
+		return nil, err
+	}
+	return &NominatimGeolocator{client: client,
+		baseURL: strings.TrimSuffix(base, "/")}, nil
+}
+
+// Locate does a geolocation lookup using the Nominatim search endpoint.
+// Structured addresses use the structured query params, and one line
+// addresses the free-form "q" param.  Nominatim has no geographies, so
+// enrichment is ignored.
+func (nl *NominatimGeolocator) Locate(ctx context.Context,
+	reqAddr types.AddressRequest) (*types.AddressResponse, error) {
+	if err := validate(reqAddr); err != nil {
+		log.Printf("request invalid: %v", err)
+		return nil, err
+	}
+
+	prm := url.Values{}
+	if reqAddr.OneLine != "" {
+		prm.Set("q", reqAddr.OneLine)
+	} else {
+		prm.Set("street", reqAddr.StructureNumber+" "+reqAddr.Street)
+		if reqAddr.City != "" {
+			prm.Set("city", reqAddr.City)
+		}
+		if reqAddr.State != "" {
+			prm.Set("state", reqAddr.State)
+		}
+		if reqAddr.Zip != "" {
+			prm.Set("postalcode", reqAddr.Zip)
+		}
+	}
+	prm.Set("countrycodes", "us")
+	prm.Set("addressdetails", "1")
+	prm.Set("limit", nominatimLimit)
+	prm.Set("format", "jsonv2")
+	reqURL := nl.baseURL + "/search?" + prm.Encode()
+
+	req, err := http.NewRequest(http.MethodGet, reqURL, nil)
+	if err != nil {
+		log.Printf("error creating request '%s': %v\n", reqURL, err)
+		return nil, err
+	}
+	req.Header.Set("User-Agent", userAgent)
+	req = req.WithContext(ctx)
+	resp, err := nl.client.Do(req)
+	if err != nil {
+		log.Printf("error opening '%s': %v\n", reqURL, err)
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, geoerr.FromStatus(resp.StatusCode)
+	}
+	b, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, geoerr.Errorf(geoerr.UpstreamUnavailable,
+			"Error reading repsonse '%v,", err)
+	}
+	js := string(b)
+	if !gjson.Valid(js) || !gjson.Parse(js).IsArray() {
+		return nil, geoerr.New(geoerr.BadPayload, "Invalid JSON")
+	}
+	return parsePlaces(js, reqAddr), nil
+}
+
+// Reverse is not supported, as Nominatim knows nothing of Census
+// geographies.
+func (nl *NominatimGeolocator) Reverse(ctx context.Context,
+	coords types.Coords) (*types.ReverseResponse, error) {
+	return nil, ErrReverseUnsupported
+}
+
+// parsePlaces converts the array of Nominatim places into matches.  The
+// address details vary by place, so the city may be a town or village,
+// and we take the state code from the ISO 3166-2 subdivision if present,
+// for example "US-MD".  Nominatim has no TIGER line data.
+func parsePlaces(js string, reqAddr types.AddressRequest) *types.AddressResponse {
+	var ar types.AddressResponse
+	gjson.Parse(js).ForEach(func(_, p gjson.Result) bool {
+		addr := p.Get("address")
+		city := addr.Get("city").String()
+		if city == "" {
+			city = addr.Get("town").String()
+		}
+		if city == "" {
+			city = addr.Get("village").String()
+		}
+		state := addr.Get("state").String()
+		if iso := addr.Get("ISO3166-2-lvl4").String(); strings.HasPrefix(iso, "US-") {
+			state = strings.TrimPrefix(iso, "US-")
+		}
+		ar.Matches = append(ar.Matches, types.AddressMatch{
+			MatchedAddress: p.Get("display_name").String(),
+			Coordinates: types.Coords{
+				X: p.Get("lon").Float(),
+				Y: p.Get("lat").Float(),
+			},
+			Components: types.AddressComponents{
+				FromAddress: addr.Get("house_number").String(),
+				ToAddress:   addr.Get("house_number").String(),
+				StreetName:  addr.Get("road").String(),
+				City:        city,
+				State:       state,
+				Zip:         addr.Get("postcode").String(),
+			},
+		})
+		return true
+	})
+	ar.MatchCount = len(ar.Matches)
+	if ar.MatchCount == 0 {
+		ar.Matches = nil
+		return &ar
+	}
+
+	best := ar.Matches[chooseMatch(ar.Matches, reqAddr)]
+	ar.Zip = best.Components.Zip
+	ar.Coordinates = best.Coordinates
+	ar.MatchedAddress = best.MatchedAddress
+	ar.Components = &best.Components
+	return &ar
+}
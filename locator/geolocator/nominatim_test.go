@@ -0,0 +1,98 @@
+package geolocator
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gdotgordon/locator-demo/locator/store/storetest"
+	"github.com/gdotgordon/locator-demo/locator/types"
+)
+
+const placesJSON = `[
+  {"place_id": 1, "lat": "38.8465420", "lon": "-76.9269100",
+   "display_name": "4600, Silver Hill Road, Suitland, Prince George's County, Maryland, 20746, United States",
+   "address": {"house_number": "4600", "road": "Silver Hill Road",
+     "town": "Suitland", "state": "Maryland", "ISO3166-2-lvl4": "US-MD",
+     "postcode": "20746", "country_code": "us"}}
+]`
+
+func TestNominatimLookup(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "application/json")
+			if r.URL.Path != "/search" || r.FormValue("format") != "jsonv2" {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			if strings.EqualFold(r.FormValue("street"), "4600 Silver Hill Rd") ||
+				strings.EqualFold(r.FormValue("q"),
+					"4600 Silver Hill Rd, Suitland, MD") {
+				fmt.Fprint(w, placesJSON)
+				return
+			}
+			fmt.Fprint(w, `[]`)
+		}))
+	defer srv.Close()
+
+	ms := &storetest.Store{}
+	l, err := New(Config{Provider: NominatimProvider, NominatimURL: srv.URL + "/"}, ms)
+	if err != nil {
+		t.Fatalf("Got unexpected error: %v", err)
+	}
+
+	for _, test := range []struct {
+		rq types.AddressRequest
+		rs types.AddressResponse
+	}{
+		{
+			rq: types.AddressRequest{StructureNumber: "4600", Street: "Silver Hill Rd",
+				City: "Suitland", State: "MD", Zip: "20746"},
+			rs: types.AddressResponse{Zip: "20746", MatchCount: 1,
+				Coordinates: types.Coords{X: -76.92691, Y: 38.846542}},
+		},
+		{
+			rq: types.AddressRequest{OneLine: "4600 Silver Hill Rd, Suitland, MD"},
+			rs: types.AddressResponse{Zip: "20746", MatchCount: 1,
+				Coordinates: types.Coords{X: -76.92691, Y: 38.846542}},
+		},
+		{
+			rq: types.AddressRequest{StructureNumber: "46", Street: "Blue Bayou Ln",
+				City: "San Ramon", State: "CA"},
+			rs: types.AddressResponse{},
+		},
+	} {
+		resp, err := l.Locate(context.Background(), test.rq)
+		if err != nil {
+			t.Fatalf("Got unexpected error: %v", err)
+		}
+		if resp.MatchCount != test.rs.MatchCount || resp.Zip != test.rs.Zip {
+			t.Fatalf("Expected %d matches in '%s', got %d in '%s'",
+				test.rs.MatchCount, test.rs.Zip, resp.MatchCount, resp.Zip)
+		}
+		if resp.Coordinates != test.rs.Coordinates {
+			t.Fatalf("Expected coordinates %+v, got %+v", test.rs.Coordinates,
+				resp.Coordinates)
+		}
+		if resp.Provider != NominatimProvider {
+			t.Fatalf("Expected provider '%s', got '%s'", NominatimProvider,
+				resp.Provider)
+		}
+		if resp.MatchCount > 0 && resp.Components.State != "MD" {
+			t.Fatalf("Expected state 'MD', got '%s'", resp.Components.State)
+		}
+	}
+
+	if n := ms.Count(types.ProviderKey(NominatimProvider, "success")); n != 3 {
+		t.Fatalf("Expected 3 provider successes, got %d", n)
+	}
+	if _, err := l.Reverse(context.Background(), types.Coords{}); err != ErrReverseUnsupported {
+		t.Fatalf("Expected unsupported error, got %v", err)
+	}
+	if n := ms.Count(types.StatsKey(types.OpReverse, "error")); n != 1 {
+		t.Fatalf("Expected 1 reverse error, got %d", n)
+	}
+}
@@ -0,0 +1,30 @@
+package geolocator
+
+import (
+	"context"
+
+	"github.com/gdotgordon/locator-demo/locator/normalize"
+	"github.com/gdotgordon/locator-demo/locator/types"
+)
+
+// normalizingLocator puts each address into USPS standard form before it
+// is looked up, and echoes the normalized address back in the response.
+type normalizingLocator struct {
+	loc Geolocator
+}
+
+func (nl *normalizingLocator) Locate(ctx context.Context,
+	reqAddr types.AddressRequest) (*types.AddressResponse, error) {
+	norm, echo := normalize.Request(reqAddr)
+	resp, err := nl.loc.Locate(ctx, norm)
+	if err != nil {
+		return nil, err
+	}
+	resp.Normalized = echo
+	return resp, nil
+}
+
+func (nl *normalizingLocator) Reverse(ctx context.Context,
+	coords types.Coords) (*types.ReverseResponse, error) {
+	return nl.loc.Reverse(ctx, coords)
+}
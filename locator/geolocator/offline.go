@@ -0,0 +1,323 @@
+package geolocator
+
+import (
+	"context"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/gdotgordon/locator-demo/locator/geoerr"
+	"github.com/gdotgordon/locator-demo/locator/normalize"
+	"github.com/gdotgordon/locator-demo/locator/store"
+	"github.com/gdotgordon/locator-demo/locator/types"
+)
+
+const (
+	// OfflineProvider is the name of the provider backed by a local
+	// address range file.
+	OfflineProvider = "offline"
+
+	// DefaultOfflineData is the bundled fixture dataset.
+	DefaultOfflineData = "data/addrfeat.csv"
+)
+
+// OfflineGeolocator geocodes without any network access, from a local
+// TIGER style address range file.  Each row is a street segment, with
+// the house number ranges and zips for the left and right sides, and
+// the segment's geometry as a WKT line string.  The coordinates of an
+// address are interpolated along the segment from its house number:
+//
+//	tlid,fullname,lfromhn,ltohn,rfromhn,rtohn,zipl,zipr,city,state,geometry
+//	613199520,Silver Hill Rd,4600,4698,4601,4699,20746,20746,Suitland,MD,
+//	  "LINESTRING (-76.92691 38.846542, -76.925502 38.847953)"
+//
+// The ranges may run in either direction, and the from and to numbers
+// give the side's parity, as with TIGER.
+type OfflineGeolocator struct {
+	streets map[string][]*segment
+}
+
+// segment is one row of the address range file.
+type segment struct {
+	tlid   string
+	name   string
+	sides  [2]side
+	city   string
+	state  string
+	points []types.Coords
+}
+
+// side is the house number range and zip for one side of a segment.
+type side struct {
+	name     string
+	from, to int
+	zip      string
+}
+
+func init() {
+	Register(OfflineProvider, func(cfg Config,
+		store store.Store) (Geolocator, error) {
+		return NewOffline(cfg.OfflineData)
+	})
+}
+
+// NewOffline loads the address range file and creates the geolocator.
+func NewOffline(path string) (*OfflineGeolocator, error) {
+	if path == "" {
+		path = DefaultOfflineData
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return loadOffline(f)
+}
+
+// loadOffline reads the segments and indexes them by street name.
+func loadOffline(r io.Reader) (*OfflineGeolocator, error) {
+	cr := csv.NewReader(r)
+	cr.FieldsPerRecord = 11
+	if _, err := cr.Read(); err != nil {
+		return nil, fmt.Errorf("Reading address range header: %v", err)
+	}
+
+	ol := &OfflineGeolocator{streets: make(map[string][]*segment)}
+	for line := 2; ; line++ {
+		rec, err := cr.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("Reading address ranges: %v", err)
+		}
+		seg, err := parseSegment(rec)
+		if err != nil {
+			return nil, fmt.Errorf("Address ranges line %d: %v", line, err)
+		}
+		key := canonStreet(seg.name)
+		ol.streets[key] = append(ol.streets[key], seg)
+	}
+	return ol, nil
+}
+
+func parseSegment(rec []string) (*segment, error) {
+	nums := make([]int, 4)
+	for i := range nums {
+		n, err := strconv.Atoi(rec[2+i])
+		if err != nil {
+			return nil, fmt.Errorf("Invalid house number '%s'", rec[2+i])
+		}
+		nums[i] = n
+	}
+	pts, err := parseLineString(rec[10])
+	if err != nil {
+		return nil, err
+	}
+	return &segment{
+		tlid: rec[0],
+		name: rec[1],
+		sides: [2]side{
+			{name: "L", from: nums[0], to: nums[1], zip: rec[6]},
+			{name: "R", from: nums[2], to: nums[3], zip: rec[7]},
+		},
+		city:   rec[8],
+		state:  rec[9],
+		points: pts,
+	}, nil
+}
+
+// parseLineString parses "LINESTRING (x1 y1, x2 y2, ...)".
+func parseLineString(wkt string) ([]types.Coords, error) {
+	s := strings.TrimSpace(wkt)
+	if !strings.HasPrefix(strings.ToUpper(s), "LINESTRING") {
+		return nil, fmt.Errorf("Invalid geometry '%s'", wkt)
+	}
+	s = strings.TrimSpace(s[len("LINESTRING"):])
+	if !strings.HasPrefix(s, "(") || !strings.HasSuffix(s, ")") {
+		return nil, fmt.Errorf("Invalid geometry '%s'", wkt)
+	}
+
+	var pts []types.Coords
+	for _, p := range strings.Split(s[1:len(s)-1], ",") {
+		xy := strings.Fields(p)
+		if len(xy) != 2 {
+			return nil, fmt.Errorf("Invalid point '%s'", p)
+		}
+		x, err := strconv.ParseFloat(xy[0], 64)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid point '%s'", p)
+		}
+		y, err := strconv.ParseFloat(xy[1], 64)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid point '%s'", p)
+		}
+		pts = append(pts, types.Coords{X: x, Y: y})
+	}
+	if len(pts) < 2 {
+		return nil, fmt.Errorf("Geometry needs at least two points '%s'", wkt)
+	}
+	return pts, nil
+}
+
+// Locate finds the segments of the street whose ranges contain the
+// house number, and interpolates the coordinates along each.  A one line
+// address must be in the form "number street, city, state zip".
+func (ol *OfflineGeolocator) Locate(ctx context.Context,
+	reqAddr types.AddressRequest) (*types.AddressResponse, error) {
+	if err := validate(reqAddr); err != nil {
+		return nil, err
+	}
+	if reqAddr.OneLine != "" {
+		var err error
+		if reqAddr, err = splitOneLine(reqAddr); err != nil {
+			return nil, err
+		}
+	}
+	hn, err := strconv.Atoi(strings.TrimSpace(reqAddr.StructureNumber))
+	if err != nil {
+		return nil, geoerr.Errorf(geoerr.Validation,
+			"Invalid structure number '%s'",
+			reqAddr.StructureNumber)
+	}
+
+	var ar types.AddressResponse
+	for _, seg := range ol.streets[canonStreet(reqAddr.Street)] {
+		if reqAddr.City != "" && !strings.EqualFold(reqAddr.City, seg.city) {
+			continue
+		}
+		if reqAddr.State != "" && !strings.EqualFold(reqAddr.State, seg.state) {
+			continue
+		}
+		for _, sd := range seg.sides {
+			if !sd.contains(hn) || (reqAddr.Zip != "" && reqAddr.Zip != sd.zip) {
+				continue
+			}
+			ar.Matches = append(ar.Matches, seg.match(hn, sd))
+		}
+	}
+	ar.MatchCount = len(ar.Matches)
+	if ar.MatchCount == 0 {
+		return &ar, nil
+	}
+
+	best := ar.Matches[chooseMatch(ar.Matches, reqAddr)]
+	ar.Zip = best.Components.Zip
+	ar.Coordinates = best.Coordinates
+	ar.MatchedAddress = best.MatchedAddress
+	ar.Components = &best.Components
+	ar.TigerLineID = best.TigerLineID
+	ar.Side = best.Side
+	return &ar, nil
+}
+
+// Reverse is not supported, as the address ranges have no geographies.
+func (ol *OfflineGeolocator) Reverse(ctx context.Context,
+	coords types.Coords) (*types.ReverseResponse, error) {
+	return nil, ErrReverseUnsupported
+}
+
+// contains says whether the house number is in the side's range, and
+// has the side's parity.
+func (sd side) contains(hn int) bool {
+	lo, hi := sd.from, sd.to
+	if lo > hi {
+		lo, hi = hi, lo
+	}
+	return hn >= lo && hn <= hi && hn%2 == sd.from%2
+}
+
+// match builds the match for the house number on the side of the segment.
+func (seg *segment) match(hn int, sd side) types.AddressMatch {
+	frac := 0.0
+	if sd.to != sd.from {
+		frac = float64(hn-sd.from) / float64(sd.to-sd.from)
+	}
+	street := strings.ToUpper(seg.name)
+	return types.AddressMatch{
+		MatchedAddress: fmt.Sprintf("%d %s, %s, %s, %s", hn, street,
+			strings.ToUpper(seg.city), strings.ToUpper(seg.state), sd.zip),
+		Coordinates: interpolate(seg.points, frac),
+		Components: types.AddressComponents{
+			FromAddress: strconv.Itoa(sd.from),
+			ToAddress:   strconv.Itoa(sd.to),
+			StreetName:  street,
+			City:        strings.ToUpper(seg.city),
+			State:       strings.ToUpper(seg.state),
+			Zip:         sd.zip,
+		},
+		TigerLineID: seg.tlid,
+		Side:        sd.name,
+	}
+}
+
+// interpolate finds the point the fraction of the way along the line.
+// The segments are short, so we treat them as flat, just scaling the
+// longitude by the cosine of the latitude so distances come out right.
+func interpolate(pts []types.Coords, frac float64) types.Coords {
+	if frac <= 0 {
+		return pts[0]
+	}
+	scale := math.Cos(pts[0].Y * math.Pi / 180)
+	lens := make([]float64, len(pts)-1)
+	total := 0.0
+	for i := range lens {
+		dx := (pts[i+1].X - pts[i].X) * scale
+		dy := pts[i+1].Y - pts[i].Y
+		lens[i] = math.Sqrt(dx*dx + dy*dy)
+		total += lens[i]
+	}
+
+	want := frac * total
+	for i, l := range lens {
+		if want <= l && l > 0 {
+			f := want / l
+			return types.Coords{
+				X: pts[i].X + f*(pts[i+1].X-pts[i].X),
+				Y: pts[i].Y + f*(pts[i+1].Y-pts[i].Y),
+			}
+		}
+		want -= l
+	}
+	return pts[len(pts)-1]
+}
+
+// canonStreet is the street name in the form used to index segments.
+func canonStreet(name string) string {
+	return normalize.Street(name)
+}
+
+// splitOneLine splits a one line address of the form "number street,
+// city, state zip" into the structured fields.
+func splitOneLine(reqAddr types.AddressRequest) (types.AddressRequest, error) {
+	parts := strings.Split(reqAddr.OneLine, ",")
+	first := strings.Fields(parts[0])
+	if len(first) < 2 {
+		return reqAddr, geoerr.Errorf(geoerr.Validation,
+			"Unable to parse address '%s'",
+			reqAddr.OneLine)
+	}
+	out := types.AddressRequest{
+		StructureNumber:  first[0],
+		Street:           strings.Join(first[1:], " "),
+		PreferInputMatch: reqAddr.PreferInputMatch,
+		Enrich:           reqAddr.Enrich,
+	}
+	if len(parts) > 1 {
+		out.City = strings.TrimSpace(parts[1])
+	}
+	if len(parts) > 2 {
+		last := strings.Fields(parts[2])
+		if len(last) > 0 {
+			out.State = last[0]
+		}
+		if len(last) > 1 {
+			out.Zip = last[1]
+		}
+	}
+	return out, nil
+}
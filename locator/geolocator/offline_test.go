@@ -0,0 +1,181 @@
+package geolocator
+
+import (
+	"context"
+	"math"
+	"strings"
+	"testing"
+
+	"github.com/gdotgordon/locator-demo/locator/types"
+)
+
+// The same lookups as TestLookup, but against the bundled fixture data,
+// so they run without network access.
+func TestLookupOffline(t *testing.T) {
+	l, err := New(Config{Provider: OfflineProvider,
+		OfflineData: "../" + DefaultOfflineData}, NoOpStore{})
+	if err != nil {
+		t.Fatalf("Got unexpected error: %v", err)
+	}
+
+	for _, test := range []struct {
+		rq types.AddressRequest
+		rs types.AddressResponse
+		e  string
+	}{
+		{
+			rq: types.AddressRequest{StructureNumber: "4600", Street: "Silver Hill Rd",
+				City: "Suitland", State: "MD", Zip: "20746"},
+			rs: types.AddressResponse{Zip: "20746",
+				Coordinates: types.Coords{X: -76.92691, Y: 38.846542}},
+		},
+		{
+			rq: types.AddressRequest{StructureNumber: "1500", Street: "Red Rover St",
+				City: "Austin", State: "TX"},
+			rs: types.AddressResponse{Zip: "78701",
+				Coordinates: types.Coords{X: -97.734770, Y: 30.275732}},
+		},
+		{
+			rq: types.AddressRequest{StructureNumber: "46", Street: "Blue Bayou Ln",
+				City: "San Ramon", State: "CA"},
+			rs: types.AddressResponse{},
+		},
+		{
+			rq: types.AddressRequest{Street: "Blue Bayou Ln",
+				City: "San Ramon", State: "CA"},
+			rs: types.AddressResponse{},
+			e:  "Structure number and Street are required",
+		},
+		{
+			rq: types.AddressRequest{OneLine: "4600 silver hill rd., Suitland, MD 20746"},
+			rs: types.AddressResponse{Zip: "20746",
+				Coordinates: types.Coords{X: -76.92691, Y: 38.846542}},
+		},
+	} {
+		resp, err := l.Locate(context.Background(), test.rq)
+		if test.e != "" {
+			if err == nil {
+				t.Fatalf("Did not get expected error: %s", test.e)
+			} else if test.e != err.Error() {
+				t.Fatalf("Expected error '%s'', got '%s'", test.e, err.Error())
+			}
+			continue
+		}
+		if test.e == "" && err != nil {
+			t.Fatalf("Got unexpected error: %v", err)
+		}
+		if resp.Zip != test.rs.Zip {
+			t.Fatalf("Expected zip '%s'', got '%s'", test.rs.Zip, resp.Zip)
+		}
+		if resp.Coordinates.X != test.rs.Coordinates.X {
+			t.Fatalf("Expected X %f, got %f", test.rs.Coordinates.X, resp.Coordinates.X)
+		}
+		if resp.Coordinates.Y != test.rs.Coordinates.Y {
+			t.Fatalf("Expected Y %f, got %f", test.rs.Coordinates.Y, resp.Coordinates.Y)
+		}
+		if resp.MatchCount > 0 && resp.Provider != OfflineProvider {
+			t.Fatalf("Expected provider '%s', got '%s'", OfflineProvider,
+				resp.Provider)
+		}
+	}
+}
+
+func TestOfflineInterpolation(t *testing.T) {
+	l, err := NewOffline("../" + DefaultOfflineData)
+	if err != nil {
+		t.Fatalf("Got unexpected error: %v", err)
+	}
+
+	for _, test := range []struct {
+		rq    types.AddressRequest
+		cnt   int
+		side  string
+		tlid  string
+		coord types.Coords
+	}{
+		// The first segment bends, and the end of the range is at the
+		// end of the line, not the corner.
+		{
+			rq:  types.AddressRequest{StructureNumber: "199", Street: "Main St", City: "Bethesda"},
+			cnt: 1, side: "R", tlid: "76218380",
+			coord: types.Coords{X: -77.099, Y: 39.001},
+		},
+		{
+			rq:  types.AddressRequest{StructureNumber: "250", Street: "Main St", City: "Bethesda"},
+			cnt: 1, side: "L", tlid: "76218381",
+			coord: types.Coords{X: -77.099 + 50.0/98*0.001, Y: 39.001},
+		},
+		{
+			rq:  types.AddressRequest{StructureNumber: "298", Street: "MAIN ST", Zip: "20814"},
+			cnt: 1, side: "L", tlid: "76218381",
+			coord: types.Coords{X: -77.098, Y: 39.001},
+		},
+		// The right side of this one has a different zip.
+		{
+			rq:  types.AddressRequest{StructureNumber: "299", Street: "Main St", Zip: "20814"},
+			cnt: 0,
+		},
+		// Both Bethesda and Bel Air have a 100 Main St, with the Bel Air
+		// range running backwards.
+		{
+			rq:  types.AddressRequest{StructureNumber: "100", Street: "Main St", State: "MD"},
+			cnt: 2, side: "L", tlid: "76218380",
+			coord: types.Coords{X: -77.1, Y: 39.0},
+		},
+		{
+			rq: types.AddressRequest{StructureNumber: "100", Street: "Main St", City: "Bel Air",
+				State: "MD"},
+			cnt: 1, side: "R", tlid: "618331507",
+			coord: types.Coords{X: -76.5 + 0.98*0.01, Y: 39.5},
+		},
+	} {
+		resp, err := l.Locate(context.Background(), test.rq)
+		if err != nil {
+			t.Fatalf("Got unexpected error: %v", err)
+		}
+		if resp.MatchCount != test.cnt {
+			t.Fatalf("Expected %d matches for %+v, got %d", test.cnt, test.rq,
+				resp.MatchCount)
+		}
+		if test.cnt == 0 {
+			continue
+		}
+		if resp.Side != test.side || resp.TigerLineID != test.tlid {
+			t.Fatalf("Expected %s/%s, got %s/%s", test.tlid, test.side,
+				resp.TigerLineID, resp.Side)
+		}
+		if math.Abs(resp.Coordinates.X-test.coord.X) > 1e-9 ||
+			math.Abs(resp.Coordinates.Y-test.coord.Y) > 1e-9 {
+			t.Fatalf("Expected coordinates %+v, got %+v", test.coord,
+				resp.Coordinates)
+		}
+	}
+}
+
+func TestLoadOfflineErrors(t *testing.T) {
+	const header = "tlid,fullname,lfromhn,ltohn,rfromhn,rtohn,zipl,zipr,city,state,geometry\n"
+	for _, test := range []struct {
+		data string
+		e    string
+	}{
+		{
+			data: header + `1,Main St,1,9,x,10,1,1,A,B,"LINESTRING (1 2, 3 4)"`,
+			e:    "Address ranges line 2: Invalid house number 'x'",
+		},
+		{
+			data: header + `1,Main St,1,9,2,10,1,1,A,B,"POINT (1 2)"`,
+			e:    "Address ranges line 2: Invalid geometry 'POINT (1 2)'",
+		},
+		{
+			data: header + `1,Main St,1,9,2,10,1,1,A,B,"LINESTRING (1 2)"`,
+			e:    "Address ranges line 2: Geometry needs at least two points 'LINESTRING (1 2)'",
+		},
+	} {
+		_, err := loadOffline(strings.NewReader(test.data))
+		if err == nil {
+			t.Fatalf("Did not get expected error: %s", test.e)
+		} else if test.e != err.Error() {
+			t.Fatalf("Expected error '%s'', got '%s'", test.e, err.Error())
+		}
+	}
+}
@@ -0,0 +1,130 @@
+package geolocator
+
+import (
+	"fmt"
+	"log"
+	"sort"
+	"sync"
+
+	"github.com/gdotgordon/locator-demo/locator/cache"
+	"github.com/gdotgordon/locator-demo/locator/store"
+	"github.com/gdotgordon/locator-demo/locator/types"
+)
+
+// Names of the built-in providers.
+const (
+	CensusProvider    = "census"
+	NominatimProvider = "nominatim"
+
+	// DefaultProvider is used when the configuration doesn't name one.
+	DefaultProvider = CensusProvider
+
+	// FailoverProvider labels the stats of lookups that every provider
+	// in a failover chain failed.
+	FailoverProvider = "failover"
+)
+
+// Factory creates a provider from the configuration.  The store is for
+// any provider specific stats, as the stats for every lookup are sent by
+// the Geolocator returned from New.
+type Factory func(cfg Config, store store.Store) (Geolocator, error)
+
+var (
+	registryMu sync.RWMutex
+	registry   = make(map[string]Factory)
+)
+
+// Register makes a provider available by name.  It is meant to be called
+// from the init function of the file implementing the provider, and
+// panics if the name is already taken.
+func Register(name string, f Factory) {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+	if _, ok := registry[name]; ok {
+		panic("geolocator: provider registered twice: " + name)
+	}
+	registry[name] = f
+}
+
+// Providers returns the sorted names of the registered providers.
+func Providers() []string {
+	registryMu.RLock()
+	defer registryMu.RUnlock()
+	var names []string
+	for name := range registry {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// New creates the Geolocator for the provider named in the configuration.
+// If there are failover providers, they are chained after it.  The rate
+// limiter, then the Redis and in-process caches, if configured, go in
+// front, in that order, so only calls that reach a provider are limited.
+// Addresses are normalized before any of them see the request, and the
+// addresses found are added to the index, if there is one.  The result
+// is wrapped so that every call sends its stats, labelled with the name
+// of the provider that answered, to the store.
+func New(cfg Config, store store.Store) (Geolocator, error) {
+	name := cfg.Provider
+	if name == "" {
+		name = DefaultProvider
+	}
+	loc, err := newProvider(name, cfg, store)
+	if err != nil {
+		return nil, err
+	}
+	if len(cfg.Failover) > 0 {
+		chain := NewFailover(cfg.Health)
+		chain.Add(name, loc)
+		for _, fname := range cfg.Failover {
+			floc, err := newProvider(fname, cfg, store)
+			if err != nil {
+				return nil, err
+			}
+			chain.Add(fname, floc)
+		}
+		loc, name = chain, FailoverProvider
+	}
+	if cfg.Limiter != nil {
+		wait := cfg.RateLimitWait
+		if wait <= 0 {
+			wait = DefaultRateLimitWait
+		}
+		loc = &throttledLocator{loc: loc, lim: cfg.Limiter, maxWait: wait,
+			store: store}
+	}
+	if cfg.Cache != nil {
+		loc = &cachingLocator{loc: loc, cache: cfg.Cache, tier: RedisTier,
+			ttl: cfg.CacheTTL, negTTL: cfg.NegativeCacheTTL, store: store}
+	}
+	if cfg.MemoryCacheSize > 0 {
+		lru := cache.NewLRU(cfg.MemoryCacheSize, func() {
+			if err := store.Incr(types.CacheKey(MemoryTier, "eviction")); err != nil {
+				log.Printf("error storing cache event, skipped: %v", err)
+			}
+		})
+		loc = &cachingLocator{loc: loc, cache: lru, tier: MemoryTier,
+			ttl: cfg.CacheTTL, negTTL: cfg.NegativeCacheTTL, store: store,
+			flights: newCoalescer()}
+	}
+	loc = &normalizingLocator{loc: loc}
+	if cfg.Index != nil {
+		loc = &indexingLocator{loc: loc, index: cfg.Index}
+	}
+	return &statsLocator{loc: loc, provider: name,
+		rec: recorder{store: store}}, nil
+}
+
+// newProvider creates the named provider using its registered factory.
+func newProvider(name string, cfg Config,
+	store store.Store) (Geolocator, error) {
+	registryMu.RLock()
+	f, ok := registry[name]
+	registryMu.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("Unknown geocoding provider '%s'", name)
+	}
+	return f(cfg, store)
+}
@@ -0,0 +1,242 @@
+package geolocator
+
+import (
+	"context"
+	"errors"
+	"log"
+	"math/rand"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gdotgordon/locator-demo/locator/geoerr"
+)
+
+// Defaults for the RetryConfig settings that are left zero.
+const (
+	DefaultMaxRetries       = 2
+	DefaultRetryBaseDelay   = 200 * time.Millisecond
+	DefaultRetryMaxDelay    = 2 * time.Second
+	DefaultBreakerThreshold = 5
+	DefaultBreakerCooldown  = 30 * time.Second
+)
+
+// Circuit breaker states, which label the transition events.
+const (
+	BreakerClosed   = "closed"
+	BreakerOpen     = "open"
+	BreakerHalfOpen = "half_open"
+)
+
+// ErrCircuitOpen is returned without calling the service while its
+// circuit breaker is open.
+var ErrCircuitOpen error = geoerr.New(geoerr.UpstreamUnavailable,
+	"Circuit breaker open")
+
+// RetryConfig controls the retries of failed calls to a service, and its
+// circuit breaker.  A retryable failure is retried up to MaxRetries times,
+// waiting an exponentially growing delay, starting at BaseDelay and capped
+// at MaxDelay, with random jitter.  After BreakerThreshold calls in a row
+// have failed, having used up their retries, the breaker opens, and calls
+// fail fast with ErrCircuitOpen for BreakerCooldown.  Then one trial call
+// is let through, which closes the breaker if it succeeds.  A negative
+// MaxRetries or BreakerThreshold turns off retries or the breaker.
+type RetryConfig struct {
+	MaxRetries       int
+	BaseDelay        time.Duration
+	MaxDelay         time.Duration
+	BreakerThreshold int
+	BreakerCooldown  time.Duration
+}
+
+// retryable says whether a failed call might succeed if tried again:
+// timeouts, transport errors, server errors and throttling.  Anything
+// else is not retried, and doesn't count against the breaker, as the
+// service did answer.  That includes the caller giving up, the breaker
+// failing fast, a request that can never succeed, and an answer that
+// couldn't be parsed, which would most likely be the same the next time.
+func retryable(err error) bool {
+	if err == nil || err == ErrCircuitOpen {
+		return false
+	}
+	switch geoerr.ClassOf(err) {
+	case geoerr.UpstreamTimeout, geoerr.UpstreamUnavailable:
+		return true
+	case geoerr.UpstreamStatus:
+		var ge *geoerr.Error
+		errors.As(err, &ge)
+		return ge.Status >= 500 || ge.Status == http.StatusTooManyRequests
+	}
+	return false
+}
+
+// retrier calls a service with retries, behind a circuit breaker.
+type retrier struct {
+	cfg     RetryConfig
+	breaker *breaker
+}
+
+func newRetrier(name string, cfg RetryConfig,
+	onChange func(name, state string)) *retrier {
+	if cfg.MaxRetries == 0 {
+		cfg.MaxRetries = DefaultMaxRetries
+	}
+	if cfg.BaseDelay <= 0 {
+		cfg.BaseDelay = DefaultRetryBaseDelay
+	}
+	if cfg.MaxDelay <= 0 {
+		cfg.MaxDelay = DefaultRetryMaxDelay
+	}
+	if cfg.BreakerThreshold == 0 {
+		cfg.BreakerThreshold = DefaultBreakerThreshold
+	}
+	if cfg.BreakerCooldown <= 0 {
+		cfg.BreakerCooldown = DefaultBreakerCooldown
+	}
+	return &retrier{cfg: cfg, breaker: &breaker{name: name,
+		threshold: cfg.BreakerThreshold, cooldown: cfg.BreakerCooldown,
+		state: BreakerClosed, onChange: onChange, now: time.Now}}
+}
+
+// do calls fn until it succeeds, fails in a way that isn't retryable, or
+// runs out of retries, returning the last error.  The breaker is told the
+// outcome of the call as a whole, so its threshold counts calls rather
+// than attempts.
+func (r *retrier) do(ctx context.Context, fn func() error) error {
+	if !r.breaker.allow() {
+		return ErrCircuitOpen
+	}
+	for attempt := 0; ; attempt++ {
+		err := fn()
+		if err != nil && ctx.Err() != nil {
+			// The caller gave up, which says nothing about the service.
+			r.breaker.release()
+			return ctx.Err()
+		}
+		if !retryable(err) || attempt >= r.cfg.MaxRetries {
+			r.breaker.record(!retryable(err))
+			return err
+		}
+
+		delay := r.backoff(attempt)
+		log.Printf("call to '%s' failed, retrying in %v: %v",
+			r.breaker.name, delay, err)
+		t := time.NewTimer(delay)
+		select {
+		case <-t.C:
+		case <-ctx.Done():
+			t.Stop()
+			r.breaker.release()
+			return ctx.Err()
+		}
+	}
+}
+
+// backoff is the delay before the retry following the attempt.  It
+// doubles each time up to the maximum, and then half of it is randomized.
+func (r *retrier) backoff(attempt int) time.Duration {
+	d := r.cfg.MaxDelay
+	if attempt < 30 {
+		if exp := r.cfg.BaseDelay << uint(attempt); exp < d {
+			d = exp
+		}
+	}
+	half := d / 2
+	return half + time.Duration(rand.Int63n(int64(half)+1))
+}
+
+// breaker is a circuit breaker.  It counts the failures in a row, and
+// opens when they reach the threshold.  After the cooldown it goes half
+// open, letting a single trial call through to decide whether to close
+// again or reopen.
+type breaker struct {
+	mu        sync.Mutex
+	name      string
+	threshold int
+	cooldown  time.Duration
+	state     string
+	failures  int
+	openedAt  time.Time
+	trial     bool
+	onChange  func(name, state string)
+	now       func() time.Time
+}
+
+// allow says whether a call may go ahead.
+func (b *breaker) allow() bool {
+	if b.threshold < 0 {
+		return true
+	}
+	b.mu.Lock()
+	var changed string
+	defer func() {
+		b.mu.Unlock()
+		b.notify(changed)
+	}()
+
+	switch b.state {
+	case BreakerOpen:
+		if b.now().Sub(b.openedAt) < b.cooldown {
+			return false
+		}
+		changed = b.setState(BreakerHalfOpen)
+		b.trial = true
+		return true
+	case BreakerHalfOpen:
+		if b.trial {
+			return false
+		}
+		b.trial = true
+		return true
+	}
+	return true
+}
+
+// release gives up the trial of a half open breaker without a verdict.
+func (b *breaker) release() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.trial = false
+}
+
+// record counts the outcome of an allowed call.
+func (b *breaker) record(ok bool) {
+	if b.threshold < 0 {
+		return
+	}
+	b.mu.Lock()
+	var changed string
+	defer func() {
+		b.mu.Unlock()
+		b.notify(changed)
+	}()
+
+	b.trial = false
+	if ok {
+		b.failures = 0
+		if b.state != BreakerClosed {
+			changed = b.setState(BreakerClosed)
+		}
+		return
+	}
+	b.failures++
+	if b.state == BreakerHalfOpen ||
+		(b.state == BreakerClosed && b.failures >= b.threshold) {
+		b.openedAt = b.now()
+		changed = b.setState(BreakerOpen)
+	}
+}
+
+// setState changes the state, and returns it for notify.
+func (b *breaker) setState(state string) string {
+	log.Printf("circuit breaker for '%s' is now %s", b.name, state)
+	b.state = state
+	return state
+}
+
+// notify reports a state change, if there was one, outside the lock.
+func (b *breaker) notify(state string) {
+	if state != "" && b.onChange != nil {
+		b.onChange(b.name, state)
+	}
+}
@@ -0,0 +1,196 @@
+package geolocator
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gdotgordon/locator-demo/locator/geoerr"
+	"github.com/gdotgordon/locator-demo/locator/store/storetest"
+	"github.com/gdotgordon/locator-demo/locator/types"
+)
+
+// failingStub fails the first failures calls with the status, and then
+// answers with the matches.
+func failingStub(failures int32, status int, calls *int32) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			if atomic.AddInt32(calls, 1) <= failures {
+				w.WriteHeader(status)
+				return
+			}
+			w.Header().Set("Content-Type", "application/json")
+			fmt.Fprint(w, matchesJSON)
+		}))
+}
+
+func TestRetries(t *testing.T) {
+	req := types.AddressRequest{StructureNumber: "100", Street: "Main St"}
+	for _, test := range []struct {
+		failures int32
+		status   int
+		retries  int
+		calls    int32
+		e        string
+	}{
+		{failures: 2, status: http.StatusServiceUnavailable, retries: 3, calls: 3},
+		{failures: 1, status: http.StatusTooManyRequests, retries: 3, calls: 2},
+		{failures: 5, status: http.StatusBadGateway, retries: 2, calls: 3,
+			e: "HTTP status 502 : Bad Gateway"},
+		{failures: 5, status: http.StatusBadRequest, retries: 3, calls: 1,
+			e: "HTTP status 400 : Bad Request"},
+		{failures: 5, status: http.StatusServiceUnavailable, retries: -1, calls: 1,
+			e: "HTTP status 503 : Service Unavailable"},
+	} {
+		var calls int32
+		srv := failingStub(test.failures, test.status, &calls)
+		l, err := NewCensus(Config{ConnTimeout: 30, CensusURL: srv.URL,
+			Retry: RetryConfig{MaxRetries: test.retries,
+				BaseDelay: time.Millisecond, MaxDelay: 2 * time.Millisecond,
+				BreakerThreshold: -1}}, NoOpStore{})
+		if err != nil {
+			t.Fatalf("Got unexpected error: %v", err)
+		}
+		resp, err := l.Locate(context.Background(), req)
+		srv.Close()
+		if test.e != "" {
+			if err == nil || err.Error() != test.e {
+				t.Fatalf("Expected error '%s', got %v", test.e, err)
+			}
+			if class := geoerr.ClassOf(err); class != geoerr.UpstreamStatus {
+				t.Fatalf("Expected class '%s', got '%s'",
+					geoerr.UpstreamStatus, class)
+			}
+		} else if err != nil || resp.MatchCount != 2 {
+			t.Fatalf("Expected 2 matches, got %+v, %v", resp, err)
+		}
+		if calls != test.calls {
+			t.Fatalf("Expected %d calls, got %d", test.calls, calls)
+		}
+	}
+}
+
+func TestBreaker(t *testing.T) {
+	var states []string
+	now := time.Now()
+	b := &breaker{name: "test", threshold: 2, cooldown: time.Minute,
+		state: BreakerClosed, now: func() time.Time { return now },
+		onChange: func(name, state string) { states = append(states, state) }}
+
+	for _, step := range []struct {
+		advance time.Duration
+		allow   bool
+		ok      bool
+		state   string
+	}{
+		{allow: true, ok: false, state: BreakerClosed},
+		{allow: true, ok: true, state: BreakerClosed},
+		{allow: true, ok: false, state: BreakerClosed},
+		{allow: true, ok: false, state: BreakerOpen},
+		{allow: false, state: BreakerOpen},
+		{advance: time.Minute, allow: true, ok: false, state: BreakerOpen},
+		{advance: 30 * time.Second, allow: false, state: BreakerOpen},
+		{advance: 30 * time.Second, allow: true, ok: true, state: BreakerClosed},
+	} {
+		now = now.Add(step.advance)
+		if allow := b.allow(); allow != step.allow {
+			t.Fatalf("Expected allow %t in state '%s', got %t", step.allow,
+				b.state, allow)
+		}
+		if step.allow {
+			b.record(step.ok)
+		}
+		if b.state != step.state {
+			t.Fatalf("Expected state '%s', got '%s'", step.state, b.state)
+		}
+	}
+
+	exp := []string{BreakerOpen, BreakerHalfOpen, BreakerOpen,
+		BreakerHalfOpen, BreakerClosed}
+	if fmt.Sprint(states) != fmt.Sprint(exp) {
+		t.Fatalf("Expected transitions %v, got %v", exp, states)
+	}
+}
+
+func TestCircuitOpen(t *testing.T) {
+	var calls int32
+	srv := failingStub(100, http.StatusServiceUnavailable, &calls)
+	defer srv.Close()
+
+	rs := &storetest.Store{}
+	l, err := NewCensus(Config{ConnTimeout: 30, CensusURL: srv.URL,
+		Retry: RetryConfig{MaxRetries: -1, BreakerThreshold: 2}}, rs)
+	if err != nil {
+		t.Fatalf("Got unexpected error: %v", err)
+	}
+	req := types.AddressRequest{StructureNumber: "100", Street: "Main St"}
+	for i := 0; i < 4; i++ {
+		_, err := l.Locate(context.Background(), req)
+		if i >= 2 && err != ErrCircuitOpen {
+			t.Fatalf("Expected circuit open error, got %v", err)
+		}
+	}
+	if calls != 2 {
+		t.Fatalf("Expected 2 calls, got %d", calls)
+	}
+	if c := rs.Count(types.BreakerKey(CensusProvider, BreakerOpen)); c != 1 {
+		t.Fatalf("Expected 1 open transition, got %d", c)
+	}
+}
+
+func TestRetryable(t *testing.T) {
+	for _, test := range []struct {
+		err       error
+		retryable bool
+	}{
+		{err: nil, retryable: false},
+		{err: geoerr.FromStatus(http.StatusServiceUnavailable), retryable: true},
+		{err: geoerr.FromStatus(http.StatusTooManyRequests), retryable: true},
+		{err: geoerr.FromStatus(http.StatusBadRequest), retryable: false},
+		{err: geoerr.New(geoerr.UpstreamTimeout, "timeout"), retryable: true},
+		{err: geoerr.New(geoerr.UpstreamUnavailable, "reset"), retryable: true},
+		{err: &net.OpError{Op: "dial", Err: errors.New("refused")},
+			retryable: true},
+		{err: ErrCircuitOpen, retryable: false},
+		{err: geoerr.New(geoerr.BadPayload, "Invalid JSON"), retryable: false},
+		{err: geoerr.New(geoerr.Validation, "bad"), retryable: false},
+		{err: context.Canceled, retryable: false},
+		{err: errors.New("unclassified"), retryable: false},
+	} {
+		if r := retryable(test.err); r != test.retryable {
+			t.Fatalf("Expected retryable %t for %v, got %t", test.retryable,
+				test.err, r)
+		}
+	}
+}
+
+// The breaker counts failed calls, not the attempts each one makes.
+func TestBreakerCountsCalls(t *testing.T) {
+	var calls int32
+	srv := failingStub(100, http.StatusServiceUnavailable, &calls)
+	defer srv.Close()
+
+	l, err := NewCensus(Config{ConnTimeout: 30, CensusURL: srv.URL,
+		Retry: RetryConfig{MaxRetries: 2, BaseDelay: time.Millisecond,
+			MaxDelay: 2 * time.Millisecond, BreakerThreshold: 2}}, NoOpStore{})
+	if err != nil {
+		t.Fatalf("Got unexpected error: %v", err)
+	}
+	req := types.AddressRequest{StructureNumber: "100", Street: "Main St"}
+	for i, e := range []error{geoerr.FromStatus(http.StatusServiceUnavailable),
+		geoerr.FromStatus(http.StatusServiceUnavailable), ErrCircuitOpen} {
+		_, err := l.Locate(context.Background(), req)
+		if err == nil || err.Error() != e.Error() {
+			t.Fatalf("Expected error '%v' for call %d, got %v", e, i, err)
+		}
+	}
+	if calls != 6 {
+		t.Fatalf("Expected 6 calls, got %d", calls)
+	}
+}
@@ -0,0 +1,147 @@
+package geolocator
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/gdotgordon/locator-demo/locator/geoerr"
+	"github.com/gdotgordon/locator-demo/locator/locking"
+	"github.com/gdotgordon/locator-demo/locator/store"
+	"github.com/gdotgordon/locator-demo/locator/types"
+)
+
+// statsLocator wraps a provider, and at the end of each call (in defer)
+// gathers up some stats and stores them in redis, triggering key events.
+type statsLocator struct {
+	loc      Geolocator
+	provider string
+	rec      recorder
+}
+
+// Locate does the lookup with the wrapped provider.  The response is
+// labelled with the name of the provider that answered, if the provider
+// has not already done so.  An address that isn't found still counts as a
+// success, but is counted in the not found class too.
+func (sl *statsLocator) Locate(ctx context.Context,
+	reqAddr types.AddressRequest) (*types.AddressResponse, error) {
+	start := time.Now()
+	resp, err := sl.loc.Locate(ctx, reqAddr)
+	if resp != nil && resp.Provider == "" {
+		resp.Provider = sl.provider
+	}
+
+	// Here we invoke the function that sets the redis keys that
+	// will trigger notifications in the analyzer.
+	sl.rec.sendStats(types.OpLookup, sl.answeredBy(resp), start, err)
+	if err == nil && resp.MatchCount == 0 {
+		sl.rec.addErrorClass(geoerr.NotFound)
+	}
+	return resp, err
+}
+
+// Reverse does the reverse lookup with the wrapped provider.
+func (sl *statsLocator) Reverse(ctx context.Context,
+	coords types.Coords) (*types.ReverseResponse, error) {
+	start := time.Now()
+	resp, err := sl.loc.Reverse(ctx, coords)
+	provider := sl.provider
+	if resp != nil {
+		if resp.Provider == "" {
+			resp.Provider = sl.provider
+		}
+		provider = resp.Provider
+	}
+	sl.rec.sendStats(types.OpReverse, provider, start, err)
+	if err == nil && resp.Geographies.State == "" {
+		sl.rec.addErrorClass(geoerr.NotFound)
+	}
+	return resp, err
+}
+
+func (sl *statsLocator) answeredBy(resp *types.AddressResponse) string {
+	if resp != nil && resp.Cached {
+		return CacheProvider
+	}
+	if resp != nil && resp.Provider != "" {
+		return resp.Provider
+	}
+	return sl.provider
+}
+
+// recorder sends the stats events to the store.
+type recorder struct {
+	store      store.Store
+	useLocking bool
+}
+
+// Here is where all the redis keys are set.  The store object (rec.store)
+// is the encapsulation of the actual redis calls (see store/store.go).
+// Each event is stored twice, once for the operation and once for the
+// provider, so the analyzer can break the results down either way.
+// Errors are counted by their class as well, while calls cancelled by
+// the caller are counted as neither a success nor an error.
+//
+// Note, the object locking, discussed in the writeup, is not enabled
+// here, due to the weakness of the Redis-suggested algorithm, plus it
+// is not needed given the semantics of the parameters in terms of
+// the order thy are received.  But you may enable it, and it will
+// work fine for reasonably small numbers of concurrent requests.
+func (rec recorder) sendStats(op, provider string, start time.Time,
+	gerr error) {
+	var err error
+	var lock *locking.Lock
+	if rec.useLocking {
+		lock, err = rec.store.AcquireLock()
+		if err != nil {
+			log.Printf("error acquiring lock, stats not saved: %v", err)
+			return
+		}
+	}
+
+	// Store the parameters of interest.
+	d := time.Now().Sub(start)
+	if err = rec.store.StoreLatency(op, d); err != nil {
+		log.Printf("error storing latency, skipped: %v", err)
+	}
+	if err = rec.store.StoreProviderLatency(provider, d); err != nil {
+		log.Printf("error storing provider latency, skipped: %v", err)
+	}
+
+	switch {
+	case geoerr.ClassOf(gerr) == geoerr.Canceled:
+		// The caller gave up, which says nothing about the service.
+		if err = rec.store.Incr(types.StatsKey(op, "canceled")); err != nil {
+			log.Printf("error storing canceled, skipped: %v", err)
+		}
+	case gerr != nil:
+		if err = rec.store.Incr(types.StatsKey(op, "error")); err != nil {
+			log.Printf("error storing error, skipped: %v", err)
+		}
+		if err = rec.store.Incr(types.ProviderKey(provider, "error")); err != nil {
+			log.Printf("error storing provider error, skipped: %v", err)
+		}
+		rec.addErrorClass(geoerr.ClassOf(gerr))
+	default:
+		if err = rec.store.Incr(types.StatsKey(op, "success")); err != nil {
+			log.Printf("error storing error, skipped: %v", err)
+		}
+		if err = rec.store.Incr(types.ProviderKey(provider, "success")); err != nil {
+			log.Printf("error storing provider success, skipped: %v", err)
+		}
+	}
+
+	// Meh.
+	if rec.useLocking {
+		if err = rec.store.Unlock(lock); err != nil {
+			log.Printf("error unlocking, stats not saved: %v", err)
+		}
+	}
+}
+
+// addErrorClass counts a failure of the class.
+func (rec recorder) addErrorClass(class string) {
+	if err := rec.store.Incr(types.ErrorClassKey(class)); err != nil {
+		log.Printf("error storing error class, skipped: %v", err)
+	}
+}
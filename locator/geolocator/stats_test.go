@@ -0,0 +1,79 @@
+package geolocator
+
+import (
+	"context"
+	"testing"
+
+	"github.com/gdotgordon/locator-demo/locator/geoerr"
+	"github.com/gdotgordon/locator-demo/locator/store"
+	"github.com/gdotgordon/locator-demo/locator/store/storetest"
+	"github.com/gdotgordon/locator-demo/locator/types"
+)
+
+func init() {
+	Register("stub", func(cfg Config, store store.Store) (Geolocator, error) {
+		return &stubLocator{}, nil
+	})
+}
+
+func TestRegistry(t *testing.T) {
+	names := Providers()
+	found := map[string]bool{}
+	for _, n := range names {
+		found[n] = true
+	}
+	for _, n := range []string{CensusProvider, NominatimProvider, "stub"} {
+		if !found[n] {
+			t.Fatalf("Expected provider '%s' in %v", n, names)
+		}
+	}
+
+	if _, err := New(Config{Provider: "nope"}, NoOpStore{}); err == nil ||
+		err.Error() != "Unknown geocoding provider 'nope'" {
+		t.Fatalf("Expected unknown provider error, got %v", err)
+	}
+
+	rs := &storetest.Store{}
+	l, err := New(Config{Provider: "stub"}, rs)
+	if err != nil {
+		t.Fatalf("Got unexpected error: %v", err)
+	}
+	resp, err := l.Locate(context.Background(), types.AddressRequest{
+		StructureNumber: "1", Street: "Main St", Zip: "12345"})
+	if err != nil {
+		t.Fatalf("Got unexpected error: %v", err)
+	}
+	if resp.Provider != "stub" {
+		t.Fatalf("Expected provider 'stub', got '%s'", resp.Provider)
+	}
+	if resp.Normalized == nil || resp.Normalized.Street != "MAIN ST" {
+		t.Fatalf("Expected normalized street 'MAIN ST', got %+v",
+			resp.Normalized)
+	}
+	l.Locate(context.Background(), types.AddressRequest{Street: "bad"})
+	l.Locate(context.Background(), types.AddressRequest{Street: "nowhere"})
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := l.Locate(ctx, types.AddressRequest{
+		StructureNumber: "1", Street: "Main St"}); err != context.Canceled {
+		t.Fatalf("Expected context canceled, got %v", err)
+	}
+
+	for key, exp := range map[string]int{
+		types.LatencyKey:                           4,
+		types.SuccessKey:                           2,
+		types.ErrorKey:                             1,
+		types.ProviderKey("stub", "latency"):       4,
+		types.ProviderKey("stub", "success"):       2,
+		types.ProviderKey("stub", "error"):         1,
+		types.ErrorClassKey(geoerr.Validation):     1,
+		types.ErrorClassKey(geoerr.NotFound):       1,
+		types.ErrorClassKey(geoerr.UpstreamStatus): 0,
+		types.ErrorClassKey(geoerr.Canceled):       0,
+		types.StatsKey(types.OpLookup, "canceled"): 1,
+	} {
+		if rs.Count(key) != exp {
+			t.Fatalf("Expected %d for '%s', got %d", exp, key, rs.Count(key))
+		}
+	}
+}
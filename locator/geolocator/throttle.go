@@ -0,0 +1,58 @@
+package geolocator
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/gdotgordon/locator-demo/locator/ratelimit"
+	"github.com/gdotgordon/locator-demo/locator/store"
+	"github.com/gdotgordon/locator-demo/locator/types"
+)
+
+// DefaultRateLimitWait is how long a call waits for the rate limiter if
+// no wait is configured.
+const DefaultRateLimitWait = 5 * time.Second
+
+// throttledLocator takes a token from the rate limiter before each call
+// to the wrapped Geolocator.  A call that can't get a token in time fails
+// with ratelimit.ErrThrottled, and is counted as throttled.
+type throttledLocator struct {
+	loc     Geolocator
+	lim     ratelimit.Limiter
+	maxWait time.Duration
+	store   store.Store
+}
+
+func (tl *throttledLocator) Locate(ctx context.Context,
+	reqAddr types.AddressRequest) (*types.AddressResponse, error) {
+	if err := tl.wait(ctx, types.OpLookup); err != nil {
+		return nil, err
+	}
+	return tl.loc.Locate(ctx, reqAddr)
+}
+
+func (tl *throttledLocator) Reverse(ctx context.Context,
+	coords types.Coords) (*types.ReverseResponse, error) {
+	if err := tl.wait(ctx, types.OpReverse); err != nil {
+		return nil, err
+	}
+	return tl.loc.Reverse(ctx, coords)
+}
+
+// wait waits for a token.  If the limiter itself fails, say because Redis
+// is down, we let the call through rather than fail it.
+func (tl *throttledLocator) wait(ctx context.Context, op string) error {
+	err := ratelimit.Wait(ctx, tl.lim, tl.maxWait)
+	if err == nil || err == ctx.Err() {
+		return err
+	}
+	if err != ratelimit.ErrThrottled {
+		log.Printf("error taking rate limit token, skipped: %v", err)
+		return nil
+	}
+	if serr := tl.store.Incr(types.StatsKey(op, "throttled")); serr != nil {
+		log.Printf("error storing throttled event, skipped: %v", serr)
+	}
+	return err
+}
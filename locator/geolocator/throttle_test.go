@@ -0,0 +1,43 @@
+package geolocator
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/gdotgordon/locator-demo/locator/ratelimit"
+	"github.com/gdotgordon/locator-demo/locator/store/storetest"
+	"github.com/gdotgordon/locator-demo/locator/types"
+)
+
+func TestThrottledLookup(t *testing.T) {
+	rs := &storetest.Store{}
+	l, err := New(Config{Provider: "cachestub",
+		Limiter:       ratelimit.NewBucket(1, 2),
+		RateLimitWait: 10 * time.Millisecond}, rs)
+	if err != nil {
+		t.Fatalf("Got unexpected error: %v", err)
+	}
+
+	req := types.AddressRequest{StructureNumber: "1", Street: "Main St",
+		Zip: "12345"}
+	for i, exp := range []error{nil, nil, ratelimit.ErrThrottled} {
+		if _, err := l.Locate(context.Background(), req); err != exp {
+			t.Fatalf("%d: Expected error %v, got %v", i, exp, err)
+		}
+	}
+	if _, err := l.Reverse(context.Background(),
+		types.Coords{X: 1, Y: 2}); err != ratelimit.ErrThrottled {
+		t.Fatalf("Expected error %v, got %v", ratelimit.ErrThrottled, err)
+	}
+
+	for key, exp := range map[string]int{
+		types.StatsKey(types.OpLookup, "throttled"):  1,
+		types.StatsKey(types.OpReverse, "throttled"): 1,
+		types.ErrorKey: 1,
+	} {
+		if rs.Count(key) != exp {
+			t.Fatalf("Expected %d for '%s', got %d", exp, key, rs.Count(key))
+		}
+	}
+}
@@ -0,0 +1,253 @@
+// Package jobs runs asynchronous lookup jobs.  A job is a list of address
+// lookups, which is saved and queued in Redis, to be worked through by a
+// pool of workers, either in the locator itself or in separate worker
+// processes.  The job keys are outside of the "locator:" keyspace, so
+// only the job lifecycle events are seen by the analyzer.
+package jobs
+
+import (
+	"encoding/json"
+	"strconv"
+	"time"
+
+	"github.com/gdotgordon/locator-demo/locator/lease"
+	"github.com/gdotgordon/locator-demo/locator/types"
+	"github.com/go-redis/redis"
+)
+
+const (
+	// KeyPrefix is the prefix of the Redis job keys.
+	KeyPrefix = "job:"
+
+	// DefaultTTL is how long a job is kept after it is created, and again
+	// after it finishes.
+	DefaultTTL = 24 * time.Hour
+)
+
+// Queue stores the jobs, and the queue of jobs waiting for a worker,
+// which the workers claim as described by lease.Claims.  The claim on a
+// job ends when it is finished.  Start returns how many of the lookups of
+// a resumed job were already done, and how many of those failed.  Get
+// returns nil, and no error, for a job that doesn't exist.
+type Queue interface {
+	lease.Claims
+	Push(job *types.Job, reqs []types.AddressRequest) error
+	Requests(id string) ([]types.AddressRequest, error)
+	Start(id string) (done, failed int, err error)
+	AddResults(id string, results []types.BatchResult) error
+	Finish(id, status, msg string) error
+	Get(id string) (*types.Job, error)
+}
+
+// RedisQueue implements the Queue interface for the Redis client.  Each
+// job is a hash of its state, with its requests and results kept under
+// their own keys, all of which expire after the ttl.
+type RedisQueue struct {
+	*lease.Queue
+	cli *redis.Client
+	ttl time.Duration
+}
+
+// NewRedisQueue creates a job queue using the Redis client, whose claims
+// last for the leaseTTL.
+func NewRedisQueue(cli *redis.Client, ttl,
+	leaseTTL time.Duration) *RedisQueue {
+	if ttl <= 0 {
+		ttl = DefaultTTL
+	}
+	q := lease.New(cli, KeyPrefix, types.JobQueued, leaseTTL)
+	return &RedisQueue{Queue: q, cli: cli, ttl: ttl}
+}
+
+func jobKey(id string) string      { return KeyPrefix + id }
+func requestsKey(id string) string { return KeyPrefix + id + ":requests" }
+func resultsKey(id string) string  { return KeyPrefix + id + ":results" }
+
+// Push saves the job and its requests, and queues it.
+func (rq *RedisQueue) Push(job *types.Job, reqs []types.AddressRequest) error {
+	b, err := json.Marshal(reqs)
+	if err != nil {
+		return err
+	}
+	_, err = rq.cli.TxPipelined(func(p redis.Pipeliner) error {
+		p.HMSet(jobKey(job.ID), map[string]interface{}{
+			"status":  job.Status,
+			"total":   job.Total,
+			"created": job.Created.Format(time.RFC3339Nano),
+		})
+		p.Expire(jobKey(job.ID), rq.ttl)
+		p.Set(requestsKey(job.ID), b, rq.ttl)
+		rq.Add(p, job.ID)
+		return nil
+	})
+	return err
+}
+
+// Requests returns the address requests of the job.
+func (rq *RedisQueue) Requests(id string) ([]types.AddressRequest, error) {
+	b, err := rq.cli.Get(requestsKey(id)).Bytes()
+	if err != nil {
+		return nil, err
+	}
+	var reqs []types.AddressRequest
+	if err := json.Unmarshal(b, &reqs); err != nil {
+		return nil, err
+	}
+	return reqs, nil
+}
+
+// Start marks the job as running.  A resumed job keeps the time it was
+// first started.
+func (rq *RedisQueue) Start(id string) (done, failed int, err error) {
+	var counts *redis.SliceCmd
+	_, err = rq.cli.TxPipelined(func(p redis.Pipeliner) error {
+		p.HSet(jobKey(id), "status", types.JobRunning)
+		p.HSetNX(jobKey(id), "started", time.Now().Format(time.RFC3339Nano))
+		counts = p.HMGet(jobKey(id), "done", "failed")
+		return nil
+	})
+	if err != nil {
+		return 0, 0, err
+	}
+	n := make([]int, 2)
+	for i, v := range counts.Val() {
+		if v == nil {
+			continue
+		}
+		if n[i], err = strconv.Atoi(v.(string)); err != nil {
+			return 0, 0, err
+		}
+	}
+	return n[0], n[1], nil
+}
+
+// AddResults appends the next results of the job, and counts them in its
+// progress.
+func (rq *RedisQueue) AddResults(id string,
+	results []types.BatchResult) error {
+	if len(results) == 0 {
+		return nil
+	}
+	vals := make([]interface{}, len(results))
+	for i, res := range results {
+		b, err := json.Marshal(res)
+		if err != nil {
+			return err
+		}
+		vals[i] = b
+	}
+	found, notFound, failed := tally(results)
+	_, err := rq.cli.TxPipelined(func(p redis.Pipeliner) error {
+		p.RPush(resultsKey(id), vals...)
+		p.Expire(resultsKey(id), rq.ttl)
+		p.HIncrBy(jobKey(id), "done", int64(len(results)))
+		p.HIncrBy(jobKey(id), "found", int64(found))
+		p.HIncrBy(jobKey(id), "not_found", int64(notFound))
+		p.HIncrBy(jobKey(id), "failed", int64(failed))
+		return nil
+	})
+	return err
+}
+
+// Finish records the final status of the job, ends the claim on it, and
+// keeps it for another ttl so the results can be collected.
+func (rq *RedisQueue) Finish(id, status, msg string) error {
+	_, err := rq.cli.TxPipelined(func(p redis.Pipeliner) error {
+		p.HMSet(jobKey(id), map[string]interface{}{
+			"status":   status,
+			"error":    msg,
+			"finished": time.Now().Format(time.RFC3339Nano),
+		})
+		p.Expire(jobKey(id), rq.ttl)
+		p.Del(requestsKey(id))
+		p.Expire(resultsKey(id), rq.ttl)
+		rq.Done(p, id)
+		return nil
+	})
+	return err
+}
+
+// Get returns the job, with the results so far.
+func (rq *RedisQueue) Get(id string) (*types.Job, error) {
+	fields, err := rq.cli.HGetAll(jobKey(id)).Result()
+	if err != nil {
+		return nil, err
+	}
+	if len(fields) == 0 {
+		return nil, nil
+	}
+	job := types.Job{ID: id, Status: fields["status"],
+		Error: fields["error"]}
+	for name, n := range map[string]*int{
+		"total":     &job.Total,
+		"done":      &job.Done,
+		"found":     &job.Found,
+		"not_found": &job.NotFound,
+		"failed":    &job.Failed,
+	} {
+		if v, ok := fields[name]; ok {
+			if *n, err = strconv.Atoi(v); err != nil {
+				return nil, err
+			}
+		}
+	}
+	if job.Created, err = time.Parse(time.RFC3339Nano,
+		fields["created"]); err != nil {
+		return nil, err
+	}
+	if job.Started, err = parseTime(fields["started"]); err != nil {
+		return nil, err
+	}
+	if job.Finished, err = parseTime(fields["finished"]); err != nil {
+		return nil, err
+	}
+
+	vals, err := rq.cli.LRange(resultsKey(id), 0, -1).Result()
+	if err != nil {
+		return nil, err
+	}
+	for _, v := range vals {
+		var res types.BatchResult
+		if err := json.Unmarshal([]byte(v), &res); err != nil {
+			return nil, err
+		}
+		job.Results = append(job.Results, res)
+	}
+	setProgress(&job)
+	return &job, nil
+}
+
+// parseTime parses an optional time field.
+func parseTime(v string) (*time.Time, error) {
+	if v == "" {
+		return nil, nil
+	}
+	t, err := time.Parse(time.RFC3339Nano, v)
+	if err != nil {
+		return nil, err
+	}
+	return &t, nil
+}
+
+// tally counts the outcomes of the results.
+func tally(results []types.BatchResult) (found, notFound, failed int) {
+	for _, res := range results {
+		switch res.Status {
+		case types.BatchFound:
+			found++
+		case types.BatchNotFound:
+			notFound++
+		default:
+			failed++
+		}
+	}
+	return found, notFound, failed
+}
+
+// setProgress works out the fraction of the job that is done.
+func setProgress(job *types.Job) {
+	job.Progress = 0
+	if job.Total > 0 {
+		job.Progress = float64(job.Done) / float64(job.Total)
+	}
+}
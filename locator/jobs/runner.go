@@ -0,0 +1,142 @@
+package jobs
+
+import (
+	"context"
+	"errors"
+	"log"
+	"time"
+
+	"github.com/gdotgordon/locator-demo/locator/geolocator"
+	"github.com/gdotgordon/locator-demo/locator/lease"
+	"github.com/gdotgordon/locator-demo/locator/store"
+	"github.com/gdotgordon/locator-demo/locator/types"
+	"github.com/rs/xid"
+)
+
+// DefaultWorkers is the number of jobs a process works on at once.
+const DefaultWorkers = 4
+
+// Submit creates a job for the address requests, and queues it.
+func Submit(q Queue, st store.Store,
+	reqs []types.AddressRequest) (*types.Job, error) {
+	job := &types.Job{ID: xid.New().String(), Status: types.JobQueued,
+		Total: len(reqs), Created: time.Now()}
+	if err := q.Push(job, reqs); err != nil {
+		return nil, err
+	}
+	event(st, types.JobQueued)
+	return job, nil
+}
+
+// Runner works through the queued jobs.  Each of its workers runs one job
+// at a time, looking up the addresses a chunk at a time with a pool of
+// batchWorkers goroutines, and saving the results of each chunk, so the
+// progress of the job can be followed, and a job that is cut short can be
+// resumed after the last chunk saved.
+type Runner struct {
+	q            Queue
+	loc          geolocator.Geolocator
+	store        store.Store
+	workers      int
+	batchWorkers int
+	leaseTTL     time.Duration
+}
+
+// NewRunner creates a runner with the number of workers, each looking up
+// batchWorkers addresses at once.  The leaseTTL should match that of the
+// queue, so that claims are renewed in time, and abandoned jobs are
+// looked for as often as they can expire.
+func NewRunner(q Queue, loc geolocator.Geolocator, st store.Store, workers,
+	batchWorkers int, leaseTTL time.Duration) *Runner {
+	if workers <= 0 {
+		workers = DefaultWorkers
+	}
+	if batchWorkers <= 0 {
+		batchWorkers = 1
+	}
+	return &Runner{q: q, loc: loc, store: st, workers: workers,
+		batchWorkers: batchWorkers, leaseTTL: leaseTTL}
+}
+
+// Run runs the workers until the context is cancelled, and returns once
+// they have stopped.  The jobs that are cut short are released, to be
+// resumed by another worker, or this one when it restarts.  Meanwhile,
+// the jobs abandoned by workers that died are recovered.
+func (r *Runner) Run(ctx context.Context) {
+	lease.Run(ctx, r.q, "job", r.workers, r.leaseTTL, r.runJob)
+}
+
+// runJob does the lookups of the job that aren't done yet, and returns
+// whether it is done with the job, which it isn't if the job couldn't be
+// started, or was cut short.
+func (r *Runner) runJob(ctx context.Context, id string) bool {
+	done, failed, err := r.q.Start(id)
+	if err != nil {
+		log.Printf("error starting job '%s': %v", id, err)
+		return false
+	}
+	event(r.store, types.JobStarted)
+
+	err = r.lookup(ctx, id, done, failed)
+	if ctx.Err() != nil {
+		return false
+	}
+	r.finish(id, err)
+	return true
+}
+
+// lookup does the lookups of the job from the offset on, a chunk at a
+// time, given the failures before it.  The job fails if its requests
+// can't be read, or if not one of its lookups could be done.  A chunk cut
+// short by a shutdown isn't saved, so it is redone when the job resumes.
+func (r *Runner) lookup(ctx context.Context, id string, off,
+	failed int) error {
+	reqs, err := r.q.Requests(id)
+	if err != nil {
+		return err
+	}
+	chunk := r.batchWorkers
+	for ; off < len(reqs); off += chunk {
+		end := off + chunk
+		if end > len(reqs) {
+			end = len(reqs)
+		}
+		results := geolocator.LocateBatch(ctx, r.loc, reqs[off:end],
+			r.batchWorkers)
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		for i := range results {
+			results[i].Index += off
+		}
+		if err := r.q.AddResults(id, results); err != nil {
+			return err
+		}
+		_, _, f := tally(results)
+		failed += f
+	}
+	if len(reqs) > 0 && failed == len(reqs) {
+		return errors.New("Every lookup failed")
+	}
+	return nil
+}
+
+// finish records the outcome of the job.
+func (r *Runner) finish(id string, jerr error) {
+	status, msg := types.JobSucceeded, ""
+	if jerr != nil {
+		status, msg = types.JobFailed, jerr.Error()
+		log.Printf("job '%s' failed: %v", id, jerr)
+	}
+	if err := r.q.Finish(id, status, msg); err != nil {
+		log.Printf("error finishing job '%s': %v", id, err)
+	}
+	event(r.store, status)
+}
+
+// event sends a job lifecycle event.
+func event(st store.Store, state string) {
+	if err := st.Incr(types.JobKey(state)); err != nil {
+		log.Printf("error storing job event, skipped: %v", err)
+	}
+}
@@ -0,0 +1,312 @@
+package jobs
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gdotgordon/locator-demo/locator/store/storetest"
+	"github.com/gdotgordon/locator-demo/locator/types"
+)
+
+// memQueue is an in-memory Queue, so we can run jobs without a redis.
+type memQueue struct {
+	mu      sync.Mutex
+	ids     chan string
+	jobs    map[string]*types.Job
+	reqs    map[string][]types.AddressRequest
+	claimed map[string]bool
+}
+
+func newMemQueue() *memQueue {
+	return &memQueue{ids: make(chan string, 10),
+		jobs:    make(map[string]*types.Job),
+		reqs:    make(map[string][]types.AddressRequest),
+		claimed: make(map[string]bool)}
+}
+
+func (mq *memQueue) Push(job *types.Job, reqs []types.AddressRequest) error {
+	mq.mu.Lock()
+	j := *job
+	mq.jobs[job.ID] = &j
+	mq.reqs[job.ID] = reqs
+	mq.mu.Unlock()
+	mq.ids <- job.ID
+	return nil
+}
+
+func (mq *memQueue) Pop(wait time.Duration) (string, error) {
+	select {
+	case id := <-mq.ids:
+		mq.mu.Lock()
+		mq.claimed[id] = true
+		mq.mu.Unlock()
+		return id, nil
+	case <-time.After(wait):
+		return "", nil
+	}
+}
+
+func (mq *memQueue) Renew(id string) error {
+	return nil
+}
+
+func (mq *memQueue) Release(id string) error {
+	mq.mu.Lock()
+	delete(mq.claimed, id)
+	mq.jobs[id].Status = types.JobQueued
+	mq.mu.Unlock()
+	mq.ids <- id
+	return nil
+}
+
+func (mq *memQueue) Recover() (int, error) {
+	return 0, nil
+}
+
+func (mq *memQueue) Requests(id string) ([]types.AddressRequest, error) {
+	mq.mu.Lock()
+	defer mq.mu.Unlock()
+	return mq.reqs[id], nil
+}
+
+func (mq *memQueue) Start(id string) (int, int, error) {
+	mq.mu.Lock()
+	defer mq.mu.Unlock()
+	job := mq.jobs[id]
+	job.Status = types.JobRunning
+	if job.Started == nil {
+		now := time.Now()
+		job.Started = &now
+	}
+	return job.Done, job.Failed, nil
+}
+
+func (mq *memQueue) AddResults(id string, results []types.BatchResult) error {
+	mq.mu.Lock()
+	defer mq.mu.Unlock()
+	job := mq.jobs[id]
+	found, notFound, failed := tally(results)
+	job.Results = append(job.Results, results...)
+	job.Done += len(results)
+	job.Found += found
+	job.NotFound += notFound
+	job.Failed += failed
+	return nil
+}
+
+func (mq *memQueue) Finish(id, status, msg string) error {
+	mq.mu.Lock()
+	defer mq.mu.Unlock()
+	now := time.Now()
+	mq.jobs[id].Status = status
+	mq.jobs[id].Error = msg
+	mq.jobs[id].Finished = &now
+	delete(mq.claimed, id)
+	return nil
+}
+
+func (mq *memQueue) Get(id string) (*types.Job, error) {
+	mq.mu.Lock()
+	defer mq.mu.Unlock()
+	job, ok := mq.jobs[id]
+	if !ok {
+		return nil, nil
+	}
+	j := *job
+	setProgress(&j)
+	return &j, nil
+}
+
+// streetLocator finds every address, except on the streets "bad", which
+// fails, and "nowhere", which isn't found.
+type streetLocator struct{}
+
+func (sl streetLocator) Locate(ctx context.Context,
+	req types.AddressRequest) (*types.AddressResponse, error) {
+	switch req.Street {
+	case "bad":
+		return nil, errors.New("bad street")
+	case "nowhere":
+		return &types.AddressResponse{}, nil
+	}
+	return &types.AddressResponse{Zip: req.Zip, MatchCount: 1}, nil
+}
+
+func (sl streetLocator) Reverse(ctx context.Context,
+	coords types.Coords) (*types.ReverseResponse, error) {
+	return &types.ReverseResponse{Coordinates: coords}, nil
+}
+
+// waitFor waits for the job to finish.
+func waitFor(t *testing.T, q Queue, id string) *types.Job {
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		job, err := q.Get(id)
+		if err != nil {
+			t.Fatalf("Got unexpected error: %v", err)
+		}
+		if job.Finished != nil {
+			return job
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("Expected job '%s' to finish", id)
+	return nil
+}
+
+func TestJobs(t *testing.T) {
+	q := newMemQueue()
+	es := &storetest.Store{}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		NewRunner(q, streetLocator{}, es, 2, 2, time.Minute).Run(ctx)
+		close(done)
+	}()
+
+	for _, test := range []struct {
+		streets  []string
+		status   string
+		found    int
+		notFound int
+		failed   int
+	}{
+		{streets: []string{"Main St", "bad", "nowhere", "Elm St", "Oak St"},
+			status: types.JobSucceeded, found: 3, notFound: 1, failed: 1},
+		{streets: []string{"bad", "bad", "bad"}, status: types.JobFailed,
+			failed: 3},
+	} {
+		var reqs []types.AddressRequest
+		for i, street := range test.streets {
+			reqs = append(reqs, types.AddressRequest{
+				StructureNumber: "1", Street: street, Zip: strconv.Itoa(i)})
+		}
+		job, err := Submit(q, es, reqs)
+		if err != nil {
+			t.Fatalf("Got unexpected error: %v", err)
+		}
+		if job.Status != types.JobQueued || job.Total != len(reqs) {
+			t.Fatalf("Expected queued job of %d, got %+v", len(reqs), job)
+		}
+
+		job = waitFor(t, q, job.ID)
+		if job.Status != test.status || job.Done != len(reqs) ||
+			job.Progress != 1 || job.Found != test.found ||
+			job.NotFound != test.notFound || job.Failed != test.failed {
+			t.Fatalf("Unexpected job: %+v", job)
+		}
+		if len(job.Results) != len(reqs) {
+			t.Fatalf("Expected %d results, got %d", len(reqs),
+				len(job.Results))
+		}
+		for i, res := range job.Results {
+			if res.Index != i {
+				t.Fatalf("Expected index %d, got %d", i, res.Index)
+			}
+			if res.Status == types.BatchFound &&
+				res.Response.Zip != reqs[i].Zip {
+				t.Fatalf("Expected zip '%s', got '%s'", reqs[i].Zip,
+					res.Response.Zip)
+			}
+		}
+	}
+
+	cancel()
+	<-done
+	for state, exp := range map[string]int{
+		types.JobQueued:    2,
+		types.JobStarted:   2,
+		types.JobSucceeded: 1,
+		types.JobFailed:    1,
+	} {
+		if n := es.Count(types.JobKey(state)); n != exp {
+			t.Fatalf("Expected %d '%s' events, got %d", exp, state, n)
+		}
+	}
+}
+
+// blockingLocator finds every address, except on the street "slow", which
+// it signals and then waits on until the lookup is cancelled.
+type blockingLocator struct {
+	streetLocator
+	slow chan struct{}
+}
+
+func (bl blockingLocator) Locate(ctx context.Context,
+	req types.AddressRequest) (*types.AddressResponse, error) {
+	if req.Street == "slow" {
+		bl.slow <- struct{}{}
+		<-ctx.Done()
+		return nil, ctx.Err()
+	}
+	return bl.streetLocator.Locate(ctx, req)
+}
+
+// A job cut short by a shutdown is handed back to the queue, and resumed
+// after the last chunk saved.
+func TestJobResumed(t *testing.T) {
+	q := newMemQueue()
+	es := &storetest.Store{}
+	var reqs []types.AddressRequest
+	for i, street := range []string{"Main St", "bad", "slow", "Elm St", "Oak St"} {
+		reqs = append(reqs, types.AddressRequest{
+			StructureNumber: "1", Street: street, Zip: strconv.Itoa(i)})
+	}
+	job, err := Submit(q, es, reqs)
+	if err != nil {
+		t.Fatalf("Got unexpected error: %v", err)
+	}
+
+	bl := blockingLocator{slow: make(chan struct{}, 1)}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		NewRunner(q, bl, es, 1, 2, time.Minute).Run(ctx)
+		close(done)
+	}()
+	<-bl.slow
+	cancel()
+	<-done
+
+	job, err = q.Get(job.ID)
+	if err != nil {
+		t.Fatalf("Got unexpected error: %v", err)
+	}
+	if job.Status != types.JobQueued || job.Done != 2 || job.Failed != 1 ||
+		job.Finished != nil || q.claimed[job.ID] {
+		t.Fatalf("Expected queued job with 2 done, got %+v", job)
+	}
+
+	ctx, cancel = context.WithCancel(context.Background())
+	done = make(chan struct{})
+	go func() {
+		NewRunner(q, streetLocator{}, es, 1, 2, time.Minute).Run(ctx)
+		close(done)
+	}()
+	job = waitFor(t, q, job.ID)
+	cancel()
+	<-done
+	if job.Status != types.JobSucceeded || job.Done != len(reqs) ||
+		job.Found != 4 || job.Failed != 1 {
+		t.Fatalf("Unexpected job: %+v", job)
+	}
+	for i, res := range job.Results {
+		if res.Index != i {
+			t.Fatalf("Expected index %d, got %d", i, res.Index)
+		}
+	}
+	for state, exp := range map[string]int{
+		types.JobQueued:    1,
+		types.JobStarted:   2,
+		types.JobSucceeded: 1,
+		types.JobFailed:    0,
+	} {
+		if n := es.Count(types.JobKey(state)); n != exp {
+			t.Fatalf("Expected %d '%s' events, got %d", exp, state, n)
+		}
+	}
+}
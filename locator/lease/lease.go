@@ -0,0 +1,243 @@
+// Package lease is a queue in Redis of the ids of work, such as jobs and
+// workflows, which workers claim with a lease.  A worker renews its lease
+// while it works on an id, and the id of a worker that has gone away is
+// put back on the queue once its lease expires, for another worker to
+// take up.  Each queue keeps its keys under its own prefix, next to the
+// work itself, which may be a hash at the prefix and id, with a status.
+package lease
+
+import (
+	"context"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/go-redis/redis"
+)
+
+const (
+	// DefaultTTL is how long a worker's claim lasts unless it is renewed.
+	DefaultTTL = 30 * time.Second
+
+	// popPoll is how often Pop checks the queue while waiting.
+	popPoll = 100 * time.Millisecond
+
+	// popWait is how long a worker waits for an id before checking
+	// whether it has been stopped.
+	popWait = time.Second
+)
+
+// claimScript moves the next queued id to the claimed list, and takes out
+// a lease on it, all at once so a claimed id is never without a lease.
+var claimScript = redis.NewScript(`
+local id = redis.call("RPOPLPUSH", KEYS[1], KEYS[2])
+if id then
+	redis.call("SET", ARGV[1] .. id .. ":lease", "1", "PX", ARGV[2])
+end
+return id
+`)
+
+// requeueScript puts a claimed id back on the queue, if its lease has
+// expired, or unconditionally if ARGV[3] is set.  The status of the work,
+// if ARGV[4] is set, goes back to it until a worker takes it up again.
+var requeueScript = redis.NewScript(`
+local lease = ARGV[1] .. ARGV[2] .. ":lease"
+if ARGV[3] == "" and redis.call("EXISTS", lease) == 1 then
+	return 0
+end
+if redis.call("LREM", KEYS[2], 1, ARGV[2]) == 0 then
+	return 0
+end
+redis.call("DEL", lease)
+if ARGV[4] ~= "" and redis.call("EXISTS", ARGV[1] .. ARGV[2]) == 1 then
+	redis.call("HSET", ARGV[1] .. ARGV[2], "status", ARGV[4])
+end
+redis.call("RPUSH", KEYS[1], ARGV[2])
+return 1
+`)
+
+// Claims are the workers' claims on the ids of a queue.  A worker claims
+// an id with Pop, which returns an empty id, and no error, if none was
+// queued within the wait.  The claim lasts as long as the worker keeps
+// renewing it, and ends when the work is done or released.  Recover puts
+// back on the queue the ids whose worker has gone away, returning how
+// many there were.
+type Claims interface {
+	Pop(wait time.Duration) (string, error)
+	Renew(id string) error
+	Release(id string) error
+	Recover() (int, error)
+}
+
+// Queue implements Claims for the Redis client.  The ids are pushed on
+// the left and claimed from the right, so they are worked on in order.
+type Queue struct {
+	cli     *redis.Client
+	prefix  string
+	queue   string
+	claimed string
+	queued  string
+	ttl     time.Duration
+}
+
+// New creates a queue of the ids under the prefix, whose claims last for
+// the ttl.  If queued is set, it is the status the hash of the work is
+// given when the work is put back on the queue.
+func New(cli *redis.Client, prefix, queued string,
+	ttl time.Duration) *Queue {
+	if ttl <= 0 {
+		ttl = DefaultTTL
+	}
+	return &Queue{cli: cli, prefix: prefix, queue: prefix + "queue",
+		claimed: prefix + "claimed", queued: queued, ttl: ttl}
+}
+
+func (q *Queue) leaseKey(id string) string { return q.prefix + id + ":lease" }
+
+// Add queues the id, as part of the caller's transaction.
+func (q *Queue) Add(p redis.Pipeliner, id string) {
+	p.LPush(q.queue, id)
+}
+
+// Done ends the claim on the id, as part of the caller's transaction.
+func (q *Queue) Done(p redis.Pipeliner, id string) {
+	p.LRem(q.claimed, 1, id)
+	p.Del(q.leaseKey(id))
+}
+
+// Pop waits for a queued id, and claims it.
+func (q *Queue) Pop(wait time.Duration) (string, error) {
+	deadline := time.Now().Add(wait)
+	for {
+		id, err := claimScript.Run(q.cli, []string{q.queue, q.claimed},
+			q.prefix, int64(q.ttl/time.Millisecond)).String()
+		if err != nil && err != redis.Nil {
+			return "", err
+		}
+		if id != "" || time.Now().After(deadline) {
+			return id, nil
+		}
+		time.Sleep(popPoll)
+	}
+}
+
+// Renew extends the claim on the id.
+func (q *Queue) Renew(id string) error {
+	return q.cli.Set(q.leaseKey(id), "1", q.ttl).Err()
+}
+
+// Release gives up the claim on the id, and puts it back on the queue for
+// another worker to resume.
+func (q *Queue) Release(id string) error {
+	return requeueScript.Run(q.cli, []string{q.queue, q.claimed},
+		q.prefix, id, "1", q.queued).Err()
+}
+
+// Recover puts the claimed ids whose lease has expired back on the queue,
+// returning how many there were.
+func (q *Queue) Recover() (int, error) {
+	ids, err := q.cli.LRange(q.claimed, 0, -1).Result()
+	if err != nil {
+		return 0, err
+	}
+	var n int
+	for _, id := range ids {
+		res, err := requeueScript.Run(q.cli, []string{q.queue, q.claimed},
+			q.prefix, id, "", q.queued).Int64()
+		if err != nil {
+			return n, err
+		}
+		n += int(res)
+	}
+	return n, nil
+}
+
+// Run runs the workers until the context is cancelled, and returns once
+// they have stopped.  Each worker claims an id at a time, and passes it
+// to work, renewing the claim every third of the ttl until work returns.
+// Work returns whether it is done with the id.  If it isn't, say because
+// it was cut short by the context, the claim is released, for another
+// worker, or this one when it restarts, to resume the work.  Meanwhile,
+// the ids abandoned by workers that died are recovered once every ttl,
+// which should match that of the queue.  The kind of work, such as "job",
+// is for the log.
+func Run(ctx context.Context, c Claims, kind string, workers int,
+	ttl time.Duration, work func(ctx context.Context, id string) bool) {
+	if ttl <= 0 {
+		ttl = DefaultTTL
+	}
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for ctx.Err() == nil {
+				id, err := c.Pop(popWait)
+				if err != nil {
+					log.Printf("error claiming %s: %v", kind, err)
+					sleep(ctx, popWait)
+					continue
+				}
+				if id != "" {
+					hold(ctx, c, kind, id, ttl, work)
+				}
+			}
+		}()
+	}
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for {
+			n, err := c.Recover()
+			if err != nil {
+				log.Printf("error recovering %ss: %v", kind, err)
+			} else if n > 0 {
+				log.Printf("recovered %d abandoned %ss", n, kind)
+			}
+			sleep(ctx, ttl)
+			if ctx.Err() != nil {
+				return
+			}
+		}
+	}()
+	wg.Wait()
+}
+
+// hold renews the claim on the id while work runs, and releases it if
+// work isn't done with it.
+func hold(ctx context.Context, c Claims, kind, id string, ttl time.Duration,
+	work func(ctx context.Context, id string) bool) {
+	renewed := make(chan struct{})
+	hctx, stop := context.WithCancel(ctx)
+	go func() {
+		defer close(renewed)
+		for {
+			sleep(hctx, ttl/3)
+			if hctx.Err() != nil {
+				return
+			}
+			if err := c.Renew(id); err != nil {
+				log.Printf("error renewing %s '%s': %v", kind, id, err)
+			}
+		}
+	}()
+	done := work(ctx, id)
+	stop()
+	<-renewed
+
+	if !done {
+		if err := c.Release(id); err != nil {
+			log.Printf("error releasing %s '%s': %v", kind, id, err)
+		}
+	}
+}
+
+// sleep waits for the duration, or until the context is cancelled.
+func sleep(ctx context.Context, d time.Duration) {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-t.C:
+	case <-ctx.Done():
+	}
+}
@@ -0,0 +1,74 @@
+package lease
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+)
+
+// memClaims hands out the queued ids, and records the ones released.
+type memClaims struct {
+	ids      chan string
+	mu       sync.Mutex
+	released []string
+}
+
+func (mc *memClaims) Pop(wait time.Duration) (string, error) {
+	select {
+	case id := <-mc.ids:
+		return id, nil
+	case <-time.After(wait):
+		return "", nil
+	}
+}
+
+func (mc *memClaims) Renew(id string) error {
+	return nil
+}
+
+func (mc *memClaims) Release(id string) error {
+	mc.mu.Lock()
+	defer mc.mu.Unlock()
+	mc.released = append(mc.released, id)
+	return nil
+}
+
+func (mc *memClaims) Recover() (int, error) {
+	return 0, nil
+}
+
+// Work that is done keeps its claim until it is finished with, while work
+// cut short by the context is released.
+func TestRun(t *testing.T) {
+	mc := &memClaims{ids: make(chan string, 2)}
+	mc.ids <- "done"
+	mc.ids <- "cut"
+
+	ctx, cancel := context.WithCancel(context.Background())
+	started := make(chan struct{})
+	finished := make(chan struct{})
+	go func() {
+		Run(ctx, mc, "test", 1, time.Minute,
+			func(ctx context.Context, id string) bool {
+				if id == "done" {
+					return true
+				}
+				close(started)
+				<-ctx.Done()
+				return false
+			})
+		close(finished)
+	}()
+	<-started
+	cancel()
+	select {
+	case <-finished:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Expected the workers to stop")
+	}
+
+	if len(mc.released) != 1 || mc.released[0] != "cut" {
+		t.Fatalf("Expected only 'cut' to be released, got %v", mc.released)
+	}
+}
@@ -4,21 +4,177 @@ package main
 
 import (
 	"context"
+	"flag"
 	"fmt"
 	"log"
 	"net/http"
 	"os"
 	"os/signal"
+	"strings"
+	"sync"
 	"syscall"
 	"time"
 
 	"github.com/gdotgordon/locator-demo/locator/api"
+	"github.com/gdotgordon/locator-demo/locator/cache"
+	"github.com/gdotgordon/locator-demo/locator/geofence"
+	"github.com/gdotgordon/locator-demo/locator/geoindex"
+	"github.com/gdotgordon/locator-demo/locator/geolocator"
+	"github.com/gdotgordon/locator-demo/locator/jobs"
+	"github.com/gdotgordon/locator-demo/locator/lease"
+	"github.com/gdotgordon/locator-demo/locator/ratelimit"
 	"github.com/gdotgordon/locator-demo/locator/store"
+	"github.com/gdotgordon/locator-demo/locator/workflow"
 	"github.com/go-redis/redis"
 	"github.com/gorilla/mux"
 )
 
+// Modes the process can run in.
+const (
+	modeAll    = "all"
+	modeAPI    = "api"
+	modeWorker = "worker"
+)
+
+var (
+	mode = flag.String("mode", modeAll,
+		"What to run: all, api (HTTP API only) or worker (workers only)")
+	jobWorkers = flag.Int("jobWorkers", jobs.DefaultWorkers,
+		"Number of jobs worked on at once")
+	jobTTL = flag.Duration("jobTTL", jobs.DefaultTTL,
+		"How long a job and its results are kept")
+	jobLease = flag.Duration("jobLease", lease.DefaultTTL,
+		"How long a job is left to a worker that stops renewing it")
+	maxJobSize = flag.Int("maxJobSize", 100000,
+		"Maximum number of addresses in a job")
+	workflowWorkers = flag.Int("workflowWorkers", workflow.DefaultWorkers,
+		"Number of workflows run at once")
+	workflowTTL = flag.Duration("workflowTTL", workflow.DefaultTTL,
+		"How long a workflow and its outputs are kept")
+	workflowLease = flag.Duration("workflowLease", lease.DefaultTTL,
+		"How long a workflow is left to a worker that stops renewing it")
+	stepTimeout = flag.Duration("stepTimeout", workflow.DefaultStepTimeout,
+		"Timeout for each workflow step")
+	webhookHosts = flag.String("webhookHosts", "",
+		"Comma separated hosts that workflow webhooks may call")
+	batchWorkers = flag.Int("batchWorkers", 10,
+		"Number of goroutines servicing each batch lookup")
+	maxBatchSize = flag.Int("maxBatchSize", 1000,
+		"Maximum number of addresses in a batch lookup")
+	maxMatrixSize = flag.Int("maxMatrixSize", 2500,
+		"Maximum number of origins times destinations in a distance matrix")
+	requestTimeout = flag.Duration("requestTimeout", 9*time.Second,
+		"Deadline of each API request, less than the server write timeout")
+	bulkAddr = flag.String("bulkAddr", ":8081",
+		"Address of the server for bulk uploads, empty for none")
+	bulkTimeout = flag.Duration("bulkTimeout", time.Hour,
+		"Deadline of each bulk upload")
+	bulkWorkers = flag.Int("bulkWorkers", 10,
+		"Number of goroutines looking up the rows of a bulk upload")
+	bulkWindow = flag.Int("bulkWindow", 1000,
+		"Rows of a bulk upload looked up ahead of the one being written")
+	maxBulkSize = flag.Int64("maxBulkSize", 256<<20,
+		"Largest bulk upload in bytes, each spooled to a temporary file")
+	provider = flag.String("provider", geolocator.DefaultProvider,
+		"Geocoding provider, one of: "+
+			strings.Join(geolocator.Providers(), ", "))
+	failover = flag.String("failover", "",
+		"Comma separated providers to fall back to, in order")
+	maxFailures = flag.Int("maxFailures", geolocator.DefaultMaxFailures,
+		"Failures in the health window that make a provider cool down")
+	cooldown = flag.Duration("cooldown", geolocator.DefaultCooldown,
+		"How long a failing provider is skipped")
+	attemptTimeout = flag.Duration("attemptTimeout", 10*time.Second,
+		"Timeout for each provider attempt in a failover chain")
+	nominatimURL = flag.String("nominatimURL", geolocator.DefaultNominatimURL,
+		"Base URL of the Nominatim service for the nominatim provider")
+	offlineData = flag.String("offlineData", geolocator.DefaultOfflineData,
+		"Address range file for the offline provider")
+	censusURL = flag.String("censusURL", geolocator.DefaultCensusURL,
+		"Base URL of the Census geocoding services")
+	benchmark = flag.String("benchmark", geolocator.DefaultBenchmark,
+		"Census benchmark to geocode against")
+	maxRetries = flag.Int("maxRetries", geolocator.DefaultMaxRetries,
+		"Retries of failed calls to the Census service, -1 for none")
+	retryBaseDelay = flag.Duration("retryBaseDelay",
+		geolocator.DefaultRetryBaseDelay, "Delay before the first retry")
+	retryMaxDelay = flag.Duration("retryMaxDelay",
+		geolocator.DefaultRetryMaxDelay, "Longest delay between retries")
+	breakerThreshold = flag.Int("breakerThreshold",
+		geolocator.DefaultBreakerThreshold,
+		"Failures in a row that open the circuit breaker, -1 for no breaker")
+	breakerCooldown = flag.Duration("breakerCooldown",
+		geolocator.DefaultBreakerCooldown,
+		"How long the circuit breaker stays open")
+	caFile = flag.String("caFile", "",
+		"PEM bundle of extra CAs trusted for the geocoding service")
+	certFile = flag.String("certFile", "",
+		"Client certificate for the geocoding service")
+	keyFile = flag.String("keyFile", "",
+		"Key of the client certificate")
+	tlsMinVersion = flag.String("tlsMinVersion", "1.2",
+		"Lowest TLS version allowed: 1.0, 1.1, 1.2 or 1.3")
+	insecure = flag.Bool("insecure", false,
+		"Skip TLS certificate verification, ONLY for local stubs")
+	maxIdleConns = flag.Int("maxIdleConns", geolocator.DefaultMaxIdleConns,
+		"Idle connections kept to the geocoding service")
+	maxIdleConnsPerHost = flag.Int("maxIdleConnsPerHost",
+		geolocator.DefaultMaxIdleConnsPerHost,
+		"Idle connections kept to each host of the geocoding service")
+	idleConnTimeout = flag.Duration("idleConnTimeout",
+		geolocator.DefaultIdleConnTimeout,
+		"How long an idle connection is kept")
+	keepAlive = flag.Duration("keepAlive", geolocator.DefaultKeepAlive,
+		"TCP keep-alive period of the connections")
+	disableHTTP2 = flag.Bool("disableHTTP2", false,
+		"Use only HTTP/1.1 with the geocoding service")
+	connTimeout = flag.Int("connTimeout", 30,
+		"Timeout in seconds for calls to the geocoding service")
+	enrich = flag.Bool("enrich", false,
+		"Add the Census geographies to every lookup")
+	vintage = flag.String("vintage", geolocator.DefaultVintage,
+		"Census vintage of the geographies")
+	layers = flag.String("layers", geolocator.DefaultLayers,
+		"Comma separated Census geography layers for enriched lookups")
+	useIndex = flag.Bool("index", true,
+		"Index the located addresses in Redis, for the nearby and within searches")
+	indexMaxAge = flag.Duration("indexMaxAge", geoindex.DefaultMaxAge,
+		"How long an indexed address is kept after it was last located, negative for ever")
+	indexMaxSize = flag.Int64("indexMaxSize", geoindex.DefaultMaxSize,
+		"Most addresses kept in the index, negative for no limit")
+	useCache = flag.Bool("cache", false,
+		"Cache the lookup results in Redis")
+	cacheTTL = flag.Duration("cacheTTL", geolocator.DefaultCacheTTL,
+		"How long found addresses are cached")
+	negativeCacheTTL = flag.Duration("negativeCacheTTL",
+		geolocator.DefaultNegativeCacheTTL,
+		"How long addresses that were not found are cached")
+	rateLimit = flag.Float64("rateLimit", 0,
+		"Calls a second allowed to the geocoding service, 0 for no limit")
+	rateBurst = flag.Int("rateBurst", 10,
+		"Calls allowed in a burst above the rate limit")
+	rateLimitWait = flag.Duration("rateLimitWait",
+		geolocator.DefaultRateLimitWait,
+		"How long a call waits for the rate limit before it is rejected")
+	sharedRateLimit = flag.Bool("sharedRateLimit", false,
+		"Share the rate limit through Redis with all the replicas")
+	clientRate = flag.Float64("clientRate", 0,
+		"Requests a second allowed for each client, 0 for no limit")
+	clientBurst = flag.Int("clientBurst", 20,
+		"Requests allowed in a burst above the client rate")
+	clientMaxInFlight = flag.Int("clientMaxInFlight", 0,
+		"Requests each client may have in flight, 0 for no limit")
+	memoryCacheSize = flag.Int("memoryCacheSize", 0,
+		"Number of lookup results kept in memory, 0 to disable")
+)
+
 func main() {
+	flag.Parse()
+	if *mode != modeAll && *mode != modeAPI && *mode != modeWorker {
+		fmt.Fprintf(os.Stderr, "Unknown mode '%s'\n", *mode)
+		os.Exit(1)
+	}
+
 	var err error
 	cli, err := NewClient()
 	if err != nil {
@@ -36,28 +192,168 @@ func main() {
 	// set up the routes, as we don't need to know the details in the
 	// main program.
 	r := mux.NewRouter()
-	if err = api.Init(ctx, r, store.NewRedisStore(cli)); err != nil {
-		fmt.Fprintf(os.Stderr, "Error setting api: '%s'\n", err)
+	var failovers []string
+	if *failover != "" {
+		failovers = strings.Split(*failover, ",")
+	}
+	cfg := api.Config{
+		BatchWorkers:   *batchWorkers,
+		MaxBatchSize:   *maxBatchSize,
+		MaxMatrixSize:  *maxMatrixSize,
+		RequestTimeout: *requestTimeout,
+		BulkWorkers:    *bulkWorkers,
+		BulkWindow:     *bulkWindow,
+		MaxBulkSize:    *maxBulkSize,
+		BulkTimeout:    *bulkTimeout,
+		Geo: geolocator.Config{
+			Provider: *provider,
+			Failover: failovers,
+			Health: geolocator.HealthConfig{
+				MaxFailures:    *maxFailures,
+				Cooldown:       *cooldown,
+				AttemptTimeout: *attemptTimeout,
+			},
+			NominatimURL: *nominatimURL,
+			OfflineData:  *offlineData,
+			CensusURL:    *censusURL,
+			Benchmark:    *benchmark,
+			ConnTimeout:  *connTimeout,
+			TLS: geolocator.TLSConfig{
+				CAFile:     *caFile,
+				CertFile:   *certFile,
+				KeyFile:    *keyFile,
+				MinVersion: *tlsMinVersion,
+				Insecure:   *insecure,
+			},
+			Transport: geolocator.TransportConfig{
+				MaxIdleConns:        *maxIdleConns,
+				MaxIdleConnsPerHost: *maxIdleConnsPerHost,
+				IdleConnTimeout:     *idleConnTimeout,
+				KeepAlive:           *keepAlive,
+				DisableHTTP2:        *disableHTTP2,
+			},
+			Retry: geolocator.RetryConfig{
+				MaxRetries:       *maxRetries,
+				BaseDelay:        *retryBaseDelay,
+				MaxDelay:         *retryMaxDelay,
+				BreakerThreshold: *breakerThreshold,
+				BreakerCooldown:  *breakerCooldown,
+			},
+			Enrich:  *enrich,
+			Vintage: *vintage,
+			Layers:  *layers,
+
+			CacheTTL:         *cacheTTL,
+			NegativeCacheTTL: *negativeCacheTTL,
+			MemoryCacheSize:  *memoryCacheSize,
+		},
+	}
+	if *useCache {
+		cfg.Geo.Cache = cache.NewRedisCache(cli)
+	}
+	if *useIndex {
+		cfg.Geo.Index = geoindex.NewRedisIndex(cli, *indexMaxAge,
+			*indexMaxSize)
+	}
+	if *rateLimit > 0 {
+		if *sharedRateLimit {
+			cfg.Geo.Limiter = ratelimit.NewRedisBucket(cli, *provider,
+				*rateLimit, *rateBurst)
+		} else {
+			cfg.Geo.Limiter = ratelimit.NewBucket(*rateLimit, *rateBurst)
+		}
+		cfg.Geo.RateLimitWait = *rateLimitWait
+	}
+	if *clientRate > 0 || *clientMaxInFlight > 0 {
+		cfg.Clients = ratelimit.NewClientLimits(cli, *clientRate,
+			*clientBurst, *clientMaxInFlight)
+	}
+	st := store.NewRedisStore(cli)
+	cfg.Locator, err = geolocator.New(cfg.Geo, st)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error creating geolocator: '%s'\n", err)
 		os.Exit(1)
 	}
+	cfg.Jobs = jobs.NewRedisQueue(cli, *jobTTL, *jobLease)
+	cfg.MaxJobSize = *maxJobSize
+	cfg.Workflows = workflow.NewRedisQueue(cli, *workflowTTL, *workflowLease)
+	cfg.Geofences = geofence.NewRedisStore(cli)
+	if *webhookHosts != "" {
+		cfg.WebhookHosts = strings.Split(*webhookHosts, ",")
+	}
 
-	srv := &http.Server{
-		Handler:      r,
-		Addr:         ":8080",
-		ReadTimeout:  10 * time.Second,
-		WriteTimeout: 10 * time.Second,
+	// The job and workflow workers run in the background until we shut
+	// down.
+	var workers sync.WaitGroup
+	if *mode != modeAPI {
+		runner := jobs.NewRunner(cfg.Jobs, cfg.Locator, st, *jobWorkers,
+			*batchWorkers, *jobLease)
+		wfRunner := workflow.NewRunner(cfg.Workflows, cfg.Locator, st,
+			workflow.Config{
+				Workers:     *workflowWorkers,
+				StepTimeout: *stepTimeout,
+				LeaseTTL:    *workflowLease,
+			})
+		workers.Add(2)
+		go func() {
+			defer workers.Done()
+			log.Printf("Starting %d job workers", *jobWorkers)
+			runner.Run(ctx)
+		}()
+		go func() {
+			defer workers.Done()
+			log.Printf("Starting %d workflow workers", *workflowWorkers)
+			wfRunner.Run(ctx)
+		}()
 	}
 
-	// Start Server
-	go func() {
-		log.Println("Starting Server")
-		if err := srv.ListenAndServe(); err != nil {
-			log.Fatal(err)
+	var srvs []*http.Server
+	if *mode != modeWorker {
+		if err = api.Init(ctx, r, st, cfg); err != nil {
+			fmt.Fprintf(os.Stderr, "Error setting api: '%s'\n", err)
+			os.Exit(1)
+		}
+
+		srvs = append(srvs, &http.Server{
+			Handler:      r,
+			Addr:         ":8080",
+			ReadTimeout:  10 * time.Second,
+			WriteTimeout: 10 * time.Second,
+		})
+
+		// Bulk uploads take as long as they take to send and to look up,
+		// so they have a server of their own, without the read and write
+		// timeouts, and only the deadline of the upload.
+		if *bulkAddr != "" {
+			br := mux.NewRouter()
+			if err = api.InitBulk(ctx, br, st, cfg); err != nil {
+				fmt.Fprintf(os.Stderr, "Error setting bulk api: '%s'\n", err)
+				os.Exit(1)
+			}
+			srvs = append(srvs, &http.Server{
+				Handler:           br,
+				Addr:              *bulkAddr,
+				ReadHeaderTimeout: 10 * time.Second,
+			})
+		}
+
+		// Start Servers
+		for _, srv := range srvs {
+			go func(srv *http.Server) {
+				log.Printf("Starting Server on %s", srv.Addr)
+				err := srv.ListenAndServe()
+				if err != nil && err != http.ErrServerClosed {
+					log.Fatal(err)
+				}
+			}(srv)
 		}
-	}()
+	}
 
-	// Block until we shutdown.
-	waitForShutdown(ctx, srv)
+	// Block until we shutdown, then stop the workers, letting them hand
+	// back the jobs and workflows they were working on.
+	waitForShutdown(ctx, srvs)
+	cancel()
+	workers.Wait()
 }
 
 func NewClient() (*redis.Client, error) {
@@ -74,7 +370,7 @@ func NewClient() (*redis.Client, error) {
 	return client, nil
 }
 
-func waitForShutdown(ctx context.Context, srv *http.Server) {
+func waitForShutdown(ctx context.Context, srvs []*http.Server) {
 	interruptChan := make(chan os.Signal, 1)
 	signal.Notify(interruptChan, os.Interrupt, syscall.SIGINT, syscall.SIGTERM)
 
@@ -84,7 +380,9 @@ func waitForShutdown(ctx context.Context, srv *http.Server) {
 	// Create a deadline to wait for.
 	ctx, cancel := context.WithTimeout(ctx, time.Second*10)
 	defer cancel()
-	srv.Shutdown(ctx)
+	for _, srv := range srvs {
+		srv.Shutdown(ctx)
+	}
 
 	log.Println("Shutting down")
 }
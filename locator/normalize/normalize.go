@@ -0,0 +1,177 @@
+// Package normalize puts addresses into a standard form before they are
+// looked up, so that different spellings of the same address are treated
+// alike.  It follows USPS Publication 28: street suffixes and directionals
+// are abbreviated, state names are replaced by their codes, and ZIP+4
+// codes are split into the ZIP and the add-on.  Everything is upper cased,
+// with the punctuation and extra whitespace removed.
+package normalize
+
+import (
+	"strings"
+	"unicode"
+
+	"github.com/gdotgordon/locator-demo/locator/types"
+)
+
+// Request returns the normalized form of the request, which is what should
+// be looked up, along with the normalized address to echo back to the
+// client.  The lookup only uses the 5 digit ZIP, while the echo has the
+// ZIP+4 add-on too.  The options of the request are unchanged.
+func Request(req types.AddressRequest) (types.AddressRequest,
+	*types.NormalizedAddress) {
+	var na types.NormalizedAddress
+	if req.OneLine != "" {
+		na.OneLine, na.Zip4 = OneLine(req.OneLine)
+	} else {
+		na.StructureNumber = Clean(req.StructureNumber)
+		na.Street = Street(req.Street)
+		na.City = Clean(req.City)
+		na.State = State(req.State)
+		na.Zip, na.Zip4 = Zip(req.Zip)
+	}
+
+	out := req
+	out.OneLine = na.OneLine
+	out.StructureNumber = na.StructureNumber
+	out.Street = na.Street
+	out.City = na.City
+	out.State = na.State
+	out.Zip = na.Zip
+	return out, &na
+}
+
+// Clean upper cases the string, drops periods and apostrophes, turns the
+// rest of the punctuation other than the '-' and '/' found in house
+// numbers and fractions into spaces, and collapses the whitespace.
+func Clean(s string) string {
+	return strings.Join(fields(s), " ")
+}
+
+func fields(s string) []string {
+	s = strings.Map(func(r rune) rune {
+		switch {
+		case r == '.' || r == '\'':
+			return -1
+		case r == '-' || r == '/':
+			return r
+		case unicode.IsPunct(r) || unicode.IsSymbol(r):
+			return ' '
+		}
+		return unicode.ToUpper(r)
+	}, s)
+	return strings.Fields(s)
+}
+
+// Street normalizes a street name, such as "silver hill road" to
+// "SILVER HILL RD".  Only the last word of the name is taken as the
+// suffix, and leading and trailing directionals are only abbreviated when
+// the rest is more than a bare suffix, so "North St" is left alone.
+func Street(s string) string {
+	return strings.Join(street(fields(s)), " ")
+}
+
+func street(words []string) []string {
+	n := len(words)
+	if n == 0 {
+		return words
+	}
+	out := make([]string, n)
+	copy(out, words)
+
+	start, end := 0, n
+	if n > 2 {
+		if d, ok := directionals[out[0]]; ok {
+			out[0] = d
+			start = 1
+		}
+		if d, ok := directionals[out[n-1]]; ok {
+			out[n-1] = d
+			end = n - 1
+		}
+	}
+	if end-start > 1 {
+		if sfx, ok := suffixes[out[end-1]]; ok {
+			out[end-1] = sfx
+		}
+	}
+	return out
+}
+
+// State returns the two letter code for a state name, such as "MD" for
+// "Maryland".  Anything else is just cleaned.
+func State(s string) string {
+	s = Clean(s)
+	if code, ok := states[s]; ok {
+		return code
+	}
+	return s
+}
+
+// Zip splits a ZIP or ZIP+4 code, such as "20746-1234" or "207461234",
+// into the 5 digit ZIP and the 4 digit add-on.  A code that is neither is
+// returned cleaned, with no add-on.
+func Zip(s string) (zip, plus4 string) {
+	s = Clean(s)
+	digits := strings.NewReplacer("-", "", " ", "").Replace(s)
+	if !isDigits(digits) {
+		return s, ""
+	}
+	switch len(digits) {
+	case 5:
+		return digits, ""
+	case 9:
+		return digits[:5], digits[5:]
+	}
+	return s, ""
+}
+
+// OneLine normalizes a one line address of the form "number street, city,
+// state zip".  The street is normalized as by Street, the state as by
+// State, and a ZIP+4 is cut to the ZIP, with the add-on returned
+// separately.  An address without commas is just cleaned.
+func OneLine(s string) (line, plus4 string) {
+	parts := strings.Split(s, ",")
+	if len(parts) < 2 {
+		return Clean(s), ""
+	}
+
+	words := fields(parts[0])
+	if len(words) > 0 && unicode.IsDigit([]rune(words[0])[0]) {
+		words = append(words[:1], street(words[1:])...)
+	} else {
+		words = street(words)
+	}
+	parts[0] = strings.Join(words, " ")
+	for i := 1; i < len(parts)-1; i++ {
+		parts[i] = Clean(parts[i])
+	}
+
+	last := fields(parts[len(parts)-1])
+	if n := len(last); n > 0 {
+		if zip, p4 := Zip(last[n-1]); len(zip) == 5 && isDigits(zip) {
+			last[n-1], plus4 = zip, p4
+			last = append([]string{State(strings.Join(last[:n-1], " "))},
+				last[n-1])
+		} else {
+			last = []string{State(strings.Join(last, " "))}
+		}
+	}
+	parts[len(parts)-1] = strings.TrimSpace(strings.Join(last, " "))
+
+	var kept []string
+	for _, p := range parts {
+		if p != "" {
+			kept = append(kept, p)
+		}
+	}
+	return strings.Join(kept, ", "), plus4
+}
+
+func isDigits(s string) bool {
+	for _, r := range s {
+		if r < '0' || r > '9' {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,104 @@
+package normalize
+
+import (
+	"testing"
+
+	"github.com/gdotgordon/locator-demo/locator/types"
+)
+
+func TestStreet(t *testing.T) {
+	for _, test := range []struct {
+		in  string
+		out string
+	}{
+		{"Silver Hill Rd", "SILVER HILL RD"},
+		{"silver hill road", "SILVER HILL RD"},
+		{"SILVER HILL RD.", "SILVER HILL RD"},
+		{"  Red  Rover  Street ", "RED ROVER ST"},
+		{"North Main Street", "N MAIN ST"},
+		{"Pennsylvania Avenue Northwest", "PENNSYLVANIA AVE NW"},
+		{"North St", "NORTH ST"},
+		{"West Avenue", "WEST AVE"},
+		{"Park", "PARK"},
+		{"O'Neil Blvd.", "ONEIL BLVD"},
+		{"Hill", "HILL"},
+		{"", ""},
+	} {
+		if s := Street(test.in); s != test.out {
+			t.Fatalf("Expected '%s' for '%s', got '%s'", test.out, test.in, s)
+		}
+	}
+}
+
+func TestState(t *testing.T) {
+	for _, test := range []struct {
+		in  string
+		out string
+	}{
+		{"MD", "MD"},
+		{"md", "MD"},
+		{"Maryland", "MD"},
+		{"district of columbia", "DC"},
+		{" New  York ", "NY"},
+		{"Atlantis", "ATLANTIS"},
+	} {
+		if s := State(test.in); s != test.out {
+			t.Fatalf("Expected '%s' for '%s', got '%s'", test.out, test.in, s)
+		}
+	}
+}
+
+func TestZip(t *testing.T) {
+	for _, test := range []struct {
+		in    string
+		zip   string
+		plus4 string
+	}{
+		{"20746", "20746", ""},
+		{"20746-1234", "20746", "1234"},
+		{"207461234", "20746", "1234"},
+		{" 20746 - 1234 ", "20746", "1234"},
+		{"2074", "2074", ""},
+		{"k1a 0b1", "K1A 0B1", ""},
+	} {
+		zip, plus4 := Zip(test.in)
+		if zip != test.zip || plus4 != test.plus4 {
+			t.Fatalf("Expected '%s', '%s' for '%s', got '%s', '%s'", test.zip,
+				test.plus4, test.in, zip, plus4)
+		}
+	}
+}
+
+func TestOneLine(t *testing.T) {
+	for _, test := range []struct {
+		in    string
+		out   string
+		plus4 string
+	}{
+		{"4600 Silver Hill Road, Suitland, Maryland 20746-1234",
+			"4600 SILVER HILL RD, SUITLAND, MD 20746", "1234"},
+		{"4600 silver hill rd., suitland, md", "4600 SILVER HILL RD, SUITLAND, MD", ""},
+		{"1500 Red Rover Street,Austin,TX 78701", "1500 RED ROVER ST, AUSTIN, TX 78701", ""},
+		{"4600 Silver Hill Rd Suitland MD", "4600 SILVER HILL RD SUITLAND MD", ""},
+	} {
+		line, plus4 := OneLine(test.in)
+		if line != test.out || plus4 != test.plus4 {
+			t.Fatalf("Expected '%s', '%s' for '%s', got '%s', '%s'", test.out,
+				test.plus4, test.in, line, plus4)
+		}
+	}
+}
+
+func TestRequest(t *testing.T) {
+	req := types.AddressRequest{StructureNumber: " 4600 ", Street: "silver hill road",
+		City: "suitland", State: "Maryland", Zip: "20746-1234", Enrich: true}
+	norm, echo := Request(req)
+	exp := types.AddressRequest{StructureNumber: "4600", Street: "SILVER HILL RD",
+		City: "SUITLAND", State: "MD", Zip: "20746", Enrich: true}
+	if norm != exp {
+		t.Fatalf("Expected %+v, got %+v", exp, norm)
+	}
+	if echo.Zip != "20746" || echo.Zip4 != "1234" || echo.Street != exp.Street {
+		t.Fatalf("Expected echo of %+v with zip4 '1234', got %+v", exp, echo)
+	}
+}
@@ -0,0 +1,524 @@
+package normalize
+
+// suffixes maps the street suffixes, and their common variants, to the
+// USPS standard abbreviations (Publication 28, Appendix C1).
+var suffixes = map[string]string{
+	"ALLEE":      "ALY",
+	"ALLEY":      "ALY",
+	"ALLY":       "ALY",
+	"ALY":        "ALY",
+	"ANEX":       "ANX",
+	"ANNEX":      "ANX",
+	"ANNX":       "ANX",
+	"ANX":        "ANX",
+	"ARC":        "ARC",
+	"ARCADE":     "ARC",
+	"AV":         "AVE",
+	"AVE":        "AVE",
+	"AVEN":       "AVE",
+	"AVENU":      "AVE",
+	"AVENUE":     "AVE",
+	"AVN":        "AVE",
+	"AVNUE":      "AVE",
+	"BAYOO":      "BYU",
+	"BAYOU":      "BYU",
+	"BCH":        "BCH",
+	"BEACH":      "BCH",
+	"BEND":       "BND",
+	"BLF":        "BLF",
+	"BLUF":       "BLF",
+	"BLUFF":      "BLF",
+	"BLVD":       "BLVD",
+	"BND":        "BND",
+	"BOT":        "BTM",
+	"BOTTM":      "BTM",
+	"BOTTOM":     "BTM",
+	"BOUL":       "BLVD",
+	"BOULEVARD":  "BLVD",
+	"BOULV":      "BLVD",
+	"BR":         "BR",
+	"BRANCH":     "BR",
+	"BRDGE":      "BRG",
+	"BRG":        "BRG",
+	"BRIDGE":     "BRG",
+	"BRK":        "BRK",
+	"BRNCH":      "BR",
+	"BROOK":      "BRK",
+	"BTM":        "BTM",
+	"BYP":        "BYP",
+	"BYPA":       "BYP",
+	"BYPAS":      "BYP",
+	"BYPASS":     "BYP",
+	"BYPS":       "BYP",
+	"BYU":        "BYU",
+	"CAMP":       "CP",
+	"CANYN":      "CYN",
+	"CANYON":     "CYN",
+	"CAPE":       "CPE",
+	"CAUSEWAY":   "CSWY",
+	"CAUSWA":     "CSWY",
+	"CEN":        "CTR",
+	"CENT":       "CTR",
+	"CENTER":     "CTR",
+	"CENTR":      "CTR",
+	"CENTRE":     "CTR",
+	"CIR":        "CIR",
+	"CIRC":       "CIR",
+	"CIRCL":      "CIR",
+	"CIRCLE":     "CIR",
+	"CLB":        "CLB",
+	"CLF":        "CLF",
+	"CLFS":       "CLFS",
+	"CLIFF":      "CLF",
+	"CLIFFS":     "CLFS",
+	"CLUB":       "CLB",
+	"CMN":        "CMN",
+	"CMP":        "CP",
+	"CNTER":      "CTR",
+	"CNTR":       "CTR",
+	"CNYN":       "CYN",
+	"COMMON":     "CMN",
+	"COR":        "COR",
+	"CORNER":     "COR",
+	"CORNERS":    "CORS",
+	"CORS":       "CORS",
+	"COURSE":     "CRSE",
+	"COURT":      "CT",
+	"COURTS":     "CTS",
+	"COVE":       "CV",
+	"CP":         "CP",
+	"CPE":        "CPE",
+	"CRCL":       "CIR",
+	"CRCLE":      "CIR",
+	"CREEK":      "CRK",
+	"CRES":       "CRES",
+	"CRESCENT":   "CRES",
+	"CRK":        "CRK",
+	"CROSSING":   "XING",
+	"CRSE":       "CRSE",
+	"CRSENT":     "CRES",
+	"CRSNT":      "CRES",
+	"CRSSNG":     "XING",
+	"CSWY":       "CSWY",
+	"CT":         "CT",
+	"CTR":        "CTR",
+	"CTS":        "CTS",
+	"CV":         "CV",
+	"CYN":        "CYN",
+	"DALE":       "DL",
+	"DAM":        "DM",
+	"DIV":        "DV",
+	"DIVIDE":     "DV",
+	"DL":         "DL",
+	"DM":         "DM",
+	"DR":         "DR",
+	"DRIV":       "DR",
+	"DRIVE":      "DR",
+	"DRIVES":     "DRS",
+	"DRS":        "DRS",
+	"DRV":        "DR",
+	"DV":         "DV",
+	"DVD":        "DV",
+	"EST":        "EST",
+	"ESTATE":     "EST",
+	"ESTATES":    "ESTS",
+	"ESTS":       "ESTS",
+	"EXP":        "EXPY",
+	"EXPR":       "EXPY",
+	"EXPRESS":    "EXPY",
+	"EXPRESSWAY": "EXPY",
+	"EXPW":       "EXPY",
+	"EXPY":       "EXPY",
+	"EXT":        "EXT",
+	"EXTENSION":  "EXT",
+	"EXTN":       "EXT",
+	"EXTNSN":     "EXT",
+	"FALLS":      "FLS",
+	"FERRY":      "FRY",
+	"FIELD":      "FLD",
+	"FIELDS":     "FLDS",
+	"FLAT":       "FLT",
+	"FLATS":      "FLTS",
+	"FLD":        "FLD",
+	"FLDS":       "FLDS",
+	"FLS":        "FLS",
+	"FLT":        "FLT",
+	"FLTS":       "FLTS",
+	"FORD":       "FRD",
+	"FOREST":     "FRST",
+	"FORESTS":    "FRST",
+	"FORG":       "FRG",
+	"FORGE":      "FRG",
+	"FORK":       "FRK",
+	"FORKS":      "FRKS",
+	"FORT":       "FT",
+	"FRD":        "FRD",
+	"FREEWAY":    "FWY",
+	"FREEWY":     "FWY",
+	"FRG":        "FRG",
+	"FRK":        "FRK",
+	"FRKS":       "FRKS",
+	"FRRY":       "FRY",
+	"FRST":       "FRST",
+	"FRT":        "FT",
+	"FRWAY":      "FWY",
+	"FRWY":       "FWY",
+	"FRY":        "FRY",
+	"FT":         "FT",
+	"FWY":        "FWY",
+	"GARDEN":     "GDN",
+	"GARDENS":    "GDNS",
+	"GARDN":      "GDN",
+	"GATEWAY":    "GTWY",
+	"GATEWY":     "GTWY",
+	"GATWAY":     "GTWY",
+	"GDN":        "GDN",
+	"GDNS":       "GDNS",
+	"GLEN":       "GLN",
+	"GLN":        "GLN",
+	"GRDEN":      "GDN",
+	"GRDN":       "GDN",
+	"GRDNS":      "GDNS",
+	"GREEN":      "GRN",
+	"GRN":        "GRN",
+	"GROV":       "GRV",
+	"GROVE":      "GRV",
+	"GRV":        "GRV",
+	"GTWAY":      "GTWY",
+	"GTWY":       "GTWY",
+	"HARB":       "HBR",
+	"HARBOR":     "HBR",
+	"HARBR":      "HBR",
+	"HAVEN":      "HVN",
+	"HBR":        "HBR",
+	"HEIGHTS":    "HTS",
+	"HIGHWAY":    "HWY",
+	"HIGHWY":     "HWY",
+	"HILL":       "HL",
+	"HILLS":      "HLS",
+	"HIWAY":      "HWY",
+	"HIWY":       "HWY",
+	"HL":         "HL",
+	"HLLW":       "HOLW",
+	"HLS":        "HLS",
+	"HOLLOW":     "HOLW",
+	"HOLLOWS":    "HOLW",
+	"HOLW":       "HOLW",
+	"HOLWS":      "HOLW",
+	"HRBOR":      "HBR",
+	"HT":         "HTS",
+	"HTS":        "HTS",
+	"HVN":        "HVN",
+	"HWAY":       "HWY",
+	"HWY":        "HWY",
+	"INLET":      "INLT",
+	"INLT":       "INLT",
+	"IS":         "IS",
+	"ISLAND":     "IS",
+	"ISLANDS":    "ISS",
+	"ISLND":      "IS",
+	"ISLNDS":     "ISS",
+	"ISS":        "ISS",
+	"JCT":        "JCT",
+	"JCTION":     "JCT",
+	"JCTN":       "JCT",
+	"JUNCTION":   "JCT",
+	"JUNCTN":     "JCT",
+	"JUNCTON":    "JCT",
+	"KEY":        "KY",
+	"KNL":        "KNL",
+	"KNOL":       "KNL",
+	"KNOLL":      "KNL",
+	"KY":         "KY",
+	"LAKE":       "LK",
+	"LAKES":      "LKS",
+	"LANDING":    "LNDG",
+	"LANE":       "LN",
+	"LGT":        "LGT",
+	"LIGHT":      "LGT",
+	"LK":         "LK",
+	"LKS":        "LKS",
+	"LN":         "LN",
+	"LNDG":       "LNDG",
+	"LNDNG":      "LNDG",
+	"LOOP":       "LOOP",
+	"LOOPS":      "LOOP",
+	"MANOR":      "MNR",
+	"MDW":        "MDW",
+	"MDWS":       "MDWS",
+	"MEADOW":     "MDW",
+	"MEADOWS":    "MDWS",
+	"MEDOWS":     "MDWS",
+	"MILL":       "ML",
+	"MILLS":      "MLS",
+	"MISSION":    "MSN",
+	"MISSN":      "MSN",
+	"ML":         "ML",
+	"MLS":        "MLS",
+	"MNR":        "MNR",
+	"MNT":        "MT",
+	"MNTAIN":     "MTN",
+	"MNTN":       "MTN",
+	"MOUNT":      "MT",
+	"MOUNTAIN":   "MTN",
+	"MOUNTIN":    "MTN",
+	"MSN":        "MSN",
+	"MSSN":       "MSN",
+	"MT":         "MT",
+	"MTIN":       "MTN",
+	"MTN":        "MTN",
+	"NCK":        "NCK",
+	"NECK":       "NCK",
+	"ORCH":       "ORCH",
+	"ORCHARD":    "ORCH",
+	"ORCHRD":     "ORCH",
+	"OVAL":       "OVAL",
+	"OVL":        "OVAL",
+	"PARK":       "PARK",
+	"PARKS":      "PARK",
+	"PARKWAY":    "PKWY",
+	"PARKWAYS":   "PKWY",
+	"PARKWY":     "PKWY",
+	"PASS":       "PASS",
+	"PATH":       "PATH",
+	"PATHS":      "PATH",
+	"PIKE":       "PIKE",
+	"PIKES":      "PIKE",
+	"PINE":       "PNE",
+	"PINES":      "PNES",
+	"PKWAY":      "PKWY",
+	"PKWY":       "PKWY",
+	"PKWYS":      "PKWY",
+	"PKY":        "PKWY",
+	"PL":         "PL",
+	"PLACE":      "PL",
+	"PLAIN":      "PLN",
+	"PLAINS":     "PLNS",
+	"PLAZA":      "PLZ",
+	"PLN":        "PLN",
+	"PLNS":       "PLNS",
+	"PLZ":        "PLZ",
+	"PLZA":       "PLZ",
+	"PNE":        "PNE",
+	"PNES":       "PNES",
+	"POINT":      "PT",
+	"POINTS":     "PTS",
+	"PORT":       "PRT",
+	"PR":         "PR",
+	"PRAIRIE":    "PR",
+	"PRK":        "PARK",
+	"PRR":        "PR",
+	"PRT":        "PRT",
+	"PT":         "PT",
+	"PTS":        "PTS",
+	"RAD":        "RADL",
+	"RADIAL":     "RADL",
+	"RADIEL":     "RADL",
+	"RADL":       "RADL",
+	"RANCH":      "RNCH",
+	"RANCHES":    "RNCH",
+	"RAPID":      "RPD",
+	"RAPIDS":     "RPDS",
+	"RD":         "RD",
+	"RDG":        "RDG",
+	"RDGE":       "RDG",
+	"RDS":        "RDS",
+	"REST":       "RST",
+	"RIDGE":      "RDG",
+	"RIV":        "RIV",
+	"RIVER":      "RIV",
+	"RIVR":       "RIV",
+	"RNCH":       "RNCH",
+	"RNCHS":      "RNCH",
+	"ROAD":       "RD",
+	"ROADS":      "RDS",
+	"ROUTE":      "RTE",
+	"ROW":        "ROW",
+	"RPD":        "RPD",
+	"RPDS":       "RPDS",
+	"RST":        "RST",
+	"RTE":        "RTE",
+	"RUN":        "RUN",
+	"RVR":        "RIV",
+	"SHORE":      "SHR",
+	"SHORES":     "SHRS",
+	"SHR":        "SHR",
+	"SHRS":       "SHRS",
+	"SMT":        "SMT",
+	"SPG":        "SPG",
+	"SPGS":       "SPGS",
+	"SPNG":       "SPG",
+	"SPNGS":      "SPGS",
+	"SPRING":     "SPG",
+	"SPRINGS":    "SPGS",
+	"SPRNG":      "SPG",
+	"SPRNGS":     "SPGS",
+	"SQ":         "SQ",
+	"SQR":        "SQ",
+	"SQRE":       "SQ",
+	"SQU":        "SQ",
+	"SQUARE":     "SQ",
+	"ST":         "ST",
+	"STA":        "STA",
+	"STATION":    "STA",
+	"STATN":      "STA",
+	"STN":        "STA",
+	"STR":        "ST",
+	"STRA":       "STRA",
+	"STRAV":      "STRA",
+	"STRAVEN":    "STRA",
+	"STRAVENUE":  "STRA",
+	"STRAVN":     "STRA",
+	"STREAM":     "STRM",
+	"STREET":     "ST",
+	"STREETS":    "STS",
+	"STREME":     "STRM",
+	"STRM":       "STRM",
+	"STRT":       "ST",
+	"STRVN":      "STRA",
+	"STRVNUE":    "STRA",
+	"STS":        "STS",
+	"SUMIT":      "SMT",
+	"SUMITT":     "SMT",
+	"SUMMIT":     "SMT",
+	"TER":        "TER",
+	"TERR":       "TER",
+	"TERRACE":    "TER",
+	"TPKE":       "TPKE",
+	"TRACE":      "TRCE",
+	"TRACES":     "TRCE",
+	"TRACK":      "TRAK",
+	"TRACKS":     "TRAK",
+	"TRAIL":      "TRL",
+	"TRAILS":     "TRL",
+	"TRAK":       "TRAK",
+	"TRCE":       "TRCE",
+	"TRK":        "TRAK",
+	"TRKS":       "TRAK",
+	"TRL":        "TRL",
+	"TRLS":       "TRL",
+	"TRNPK":      "TPKE",
+	"TUNEL":      "TUNL",
+	"TUNL":       "TUNL",
+	"TUNLS":      "TUNL",
+	"TUNNEL":     "TUNL",
+	"TUNNELS":    "TUNL",
+	"TUNNL":      "TUNL",
+	"TURNPIKE":   "TPKE",
+	"TURNPK":     "TPKE",
+	"UN":         "UN",
+	"UNION":      "UN",
+	"VALLEY":     "VLY",
+	"VALLY":      "VLY",
+	"VDCT":       "VIA",
+	"VIA":        "VIA",
+	"VIADCT":     "VIA",
+	"VIADUCT":    "VIA",
+	"VIEW":       "VW",
+	"VILL":       "VLG",
+	"VILLAG":     "VLG",
+	"VILLAGE":    "VLG",
+	"VILLE":      "VL",
+	"VILLG":      "VLG",
+	"VILLIAGE":   "VLG",
+	"VIS":        "VIS",
+	"VIST":       "VIS",
+	"VISTA":      "VIS",
+	"VL":         "VL",
+	"VLG":        "VLG",
+	"VLLY":       "VLY",
+	"VLY":        "VLY",
+	"VST":        "VIS",
+	"VSTA":       "VIS",
+	"VW":         "VW",
+	"WALK":       "WALK",
+	"WALKS":      "WALK",
+	"WAY":        "WAY",
+	"WELL":       "WL",
+	"WELLS":      "WLS",
+	"WL":         "WL",
+	"WLS":        "WLS",
+	"WY":         "WAY",
+	"XING":       "XING",
+}
+
+// directionals maps the directions to their abbreviations.
+var directionals = map[string]string{
+	"E":         "E",
+	"EAST":      "E",
+	"N":         "N",
+	"NE":        "NE",
+	"NORTH":     "N",
+	"NORTHEAST": "NE",
+	"NORTHWEST": "NW",
+	"NW":        "NW",
+	"S":         "S",
+	"SE":        "SE",
+	"SOUTH":     "S",
+	"SOUTHEAST": "SE",
+	"SOUTHWEST": "SW",
+	"SW":        "SW",
+	"W":         "W",
+	"WEST":      "W",
+}
+
+// states maps the state and territory names to their codes.
+var states = map[string]string{
+	"ALABAMA":                  "AL",
+	"ALASKA":                   "AK",
+	"AMERICAN SAMOA":           "AS",
+	"ARIZONA":                  "AZ",
+	"ARKANSAS":                 "AR",
+	"CALIFORNIA":               "CA",
+	"COLORADO":                 "CO",
+	"CONNECTICUT":              "CT",
+	"DELAWARE":                 "DE",
+	"DISTRICT OF COLUMBIA":     "DC",
+	"FLORIDA":                  "FL",
+	"GEORGIA":                  "GA",
+	"GUAM":                     "GU",
+	"HAWAII":                   "HI",
+	"IDAHO":                    "ID",
+	"ILLINOIS":                 "IL",
+	"INDIANA":                  "IN",
+	"IOWA":                     "IA",
+	"KANSAS":                   "KS",
+	"KENTUCKY":                 "KY",
+	"LOUISIANA":                "LA",
+	"MAINE":                    "ME",
+	"MARYLAND":                 "MD",
+	"MASSACHUSETTS":            "MA",
+	"MICHIGAN":                 "MI",
+	"MINNESOTA":                "MN",
+	"MISSISSIPPI":              "MS",
+	"MISSOURI":                 "MO",
+	"MONTANA":                  "MT",
+	"NEBRASKA":                 "NE",
+	"NEVADA":                   "NV",
+	"NEW HAMPSHIRE":            "NH",
+	"NEW JERSEY":               "NJ",
+	"NEW MEXICO":               "NM",
+	"NEW YORK":                 "NY",
+	"NORTH CAROLINA":           "NC",
+	"NORTH DAKOTA":             "ND",
+	"NORTHERN MARIANA ISLANDS": "MP",
+	"OHIO":                     "OH",
+	"OKLAHOMA":                 "OK",
+	"OREGON":                   "OR",
+	"PENNSYLVANIA":             "PA",
+	"PUERTO RICO":              "PR",
+	"RHODE ISLAND":             "RI",
+	"SOUTH CAROLINA":           "SC",
+	"SOUTH DAKOTA":             "SD",
+	"TENNESSEE":                "TN",
+	"TEXAS":                    "TX",
+	"US VIRGIN ISLANDS":        "VI",
+	"UTAH":                     "UT",
+	"VERMONT":                  "VT",
+	"VIRGIN ISLANDS":           "VI",
+	"VIRGINIA":                 "VA",
+	"WASHINGTON":               "WA",
+	"WEST VIRGINIA":            "WV",
+	"WISCONSIN":                "WI",
+	"WYOMING":                  "WY",
+}
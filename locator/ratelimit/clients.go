@@ -0,0 +1,102 @@
+package ratelimit
+
+import (
+	"time"
+
+	"github.com/go-redis/redis"
+)
+
+// InFlightTTL bounds how long an in-flight count outlives the last request
+// to change or renew it, so the count of a replica that dies mid-request
+// heals.  Requests that run longer must call Renew well within it.
+const InFlightTTL = time.Minute
+
+// acquireScript counts a request in flight for the client, unless that
+// would take it over the maximum.  It returns 1 if the request may go
+// ahead, and 0 if not.
+var acquireScript = redis.NewScript(`
+local n = redis.call("INCR", KEYS[1])
+redis.call("PEXPIRE", KEYS[1], ARGV[2])
+if n > tonumber(ARGV[1]) then
+	redis.call("DECR", KEYS[1])
+	return 0
+end
+return 1
+`)
+
+// releaseScript uncounts a request in flight, without going below zero if
+// the count expired in the meantime.
+var releaseScript = redis.NewScript(`
+if redis.call("DECR", KEYS[1]) < 0 then
+	redis.call("DEL", KEYS[1])
+end
+return 1
+`)
+
+// ClientLimits enforces a request rate and a number of requests in flight
+// for each client of the API.  The counters are kept in Redis, so the
+// limits hold across all the replicas.  A zero rate or in-flight maximum
+// means no limit of that kind.
+type ClientLimits struct {
+	cli         *redis.Client
+	rate        float64
+	burst       int
+	maxInFlight int
+}
+
+// NewClientLimits creates the limits.  Each client may make rate requests
+// a second, in bursts of up to burst, with at most maxInFlight of them in
+// flight at once.
+func NewClientLimits(cli *redis.Client, rate float64, burst,
+	maxInFlight int) *ClientLimits {
+	return &ClientLimits{cli: cli, rate: rate, burst: burst,
+		maxInFlight: maxInFlight}
+}
+
+// Allow takes a token from the client's bucket.  If there is none, it
+// returns how long until there will be.
+func (cl *ClientLimits) Allow(client string) (time.Duration, error) {
+	if cl.rate <= 0 {
+		return 0, nil
+	}
+	return NewRedisBucket(cl.cli, "client:"+client, cl.rate,
+		cl.burst).Take()
+}
+
+// Acquire counts a request in flight for the client, and returns false if
+// the client already has the maximum in flight.  A successful Acquire
+// must be followed by a Release when the request completes, and by a
+// Renew every so often until then, if it might take longer than
+// InFlightTTL.
+func (cl *ClientLimits) Acquire(client string) (bool, error) {
+	if cl.maxInFlight <= 0 {
+		return true, nil
+	}
+	n, err := acquireScript.Run(cl.cli, []string{cl.inFlightKey(client)},
+		cl.maxInFlight, int64(InFlightTTL/time.Millisecond)).Int64()
+	if err != nil {
+		return false, err
+	}
+	return n == 1, nil
+}
+
+// Renew keeps the client's in-flight count from expiring for another
+// InFlightTTL, while a request is still in flight.
+func (cl *ClientLimits) Renew(client string) error {
+	if cl.maxInFlight <= 0 {
+		return nil
+	}
+	return cl.cli.PExpire(cl.inFlightKey(client), InFlightTTL).Err()
+}
+
+// Release uncounts a request in flight for the client.
+func (cl *ClientLimits) Release(client string) error {
+	if cl.maxInFlight <= 0 {
+		return nil
+	}
+	return releaseScript.Run(cl.cli, []string{cl.inFlightKey(client)}).Err()
+}
+
+func (cl *ClientLimits) inFlightKey(client string) string {
+	return KeyPrefix + "inflight:" + client
+}
@@ -0,0 +1,152 @@
+// Package ratelimit implements token buckets for limiting the rate of
+// calls to a service.  The local bucket limits the calls of a single
+// process, while the Redis bucket keeps its state in Redis, so that every
+// replica of the locator draws on one shared budget.
+package ratelimit
+
+import (
+	"context"
+	"errors"
+	"math"
+	"sync"
+	"time"
+
+	"github.com/go-redis/redis"
+)
+
+// KeyPrefix is the prefix of the Redis bucket keys.  It is outside of the
+// "locator:" keyspace, so taking tokens doesn't generate events for the
+// analyzer.
+const KeyPrefix = "ratelimit:"
+
+// ErrThrottled is returned when a token can't be had within the time
+// allowed.
+var ErrThrottled = errors.New("Rate limit exceeded")
+
+// Limiter is a token bucket.  Take removes a token if there is one, and
+// returns zero.  Otherwise it returns how long until the next token, and
+// the caller may try again then.
+type Limiter interface {
+	Take() (time.Duration, error)
+}
+
+// Wait takes a token from the limiter, waiting for one for up to maxWait,
+// or until the context is done, whichever is sooner.  If the wait for the
+// next token would run past that, it gives up straight away with
+// ErrThrottled.
+func Wait(ctx context.Context, lim Limiter, maxWait time.Duration) error {
+	deadline := time.Now().Add(maxWait)
+	if d, ok := ctx.Deadline(); ok && d.Before(deadline) {
+		deadline = d
+	}
+	for {
+		wait, err := lim.Take()
+		if err != nil {
+			return err
+		}
+		if wait == 0 {
+			return nil
+		}
+		if time.Now().Add(wait).After(deadline) {
+			return ErrThrottled
+		}
+		t := time.NewTimer(wait)
+		select {
+		case <-t.C:
+		case <-ctx.Done():
+			t.Stop()
+			return ctx.Err()
+		}
+	}
+}
+
+// Bucket is a token bucket local to the process.  It holds up to burst
+// tokens, and is refilled at rate tokens a second.
+type Bucket struct {
+	mu     sync.Mutex
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+	now    func() time.Time
+}
+
+// NewBucket creates a full local bucket.
+func NewBucket(rate float64, burst int) *Bucket {
+	b := &Bucket{rate: rate, burst: float64(burst), tokens: float64(burst),
+		now: time.Now}
+	b.last = b.now()
+	return b
+}
+
+// Take removes a token from the bucket, if there is one.
+func (b *Bucket) Take() (time.Duration, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	now := b.now()
+	b.tokens = math.Min(b.burst,
+		b.tokens+now.Sub(b.last).Seconds()*b.rate)
+	b.last = now
+	if b.tokens >= 1 {
+		b.tokens--
+		return 0, nil
+	}
+	return secs((1 - b.tokens) / b.rate), nil
+}
+
+// takeScript refills the bucket for the time since it was last used, and
+// takes a token if there is one.  It returns zero on success, or else the
+// milliseconds until the next token.  Redis' clock is used, so replicas
+// with skewed clocks still agree.  The bucket expires once it would have
+// refilled anyway.
+var takeScript = redis.NewScript(`
+redis.replicate_commands()
+local rate = tonumber(ARGV[1])
+local burst = tonumber(ARGV[2])
+local t = redis.call("TIME")
+local now = tonumber(t[1]) + tonumber(t[2]) / 1000000
+local state = redis.call("HMGET", KEYS[1], "tokens", "ts")
+local tokens = tonumber(state[1]) or burst
+local ts = tonumber(state[2]) or now
+tokens = math.min(burst, tokens + math.max(0, now - ts) * rate)
+local wait = 0
+if tokens >= 1 then
+	tokens = tokens - 1
+else
+	wait = math.ceil((1 - tokens) / rate * 1000)
+end
+redis.call("HMSET", KEYS[1], "tokens", tostring(tokens), "ts", tostring(now))
+redis.call("PEXPIRE", KEYS[1], math.ceil(burst / rate * 1000) + 1000)
+return wait
+`)
+
+// RedisBucket is a token bucket kept in Redis, shared by every process
+// using the same name.
+type RedisBucket struct {
+	cli   *redis.Client
+	key   string
+	rate  float64
+	burst int
+}
+
+// NewRedisBucket creates the shared bucket with the name.  It holds up to
+// burst tokens, and is refilled at rate tokens a second.
+func NewRedisBucket(cli *redis.Client, name string, rate float64,
+	burst int) *RedisBucket {
+	return &RedisBucket{cli: cli, key: KeyPrefix + name, rate: rate,
+		burst: burst}
+}
+
+// Take removes a token from the shared bucket, if there is one.
+func (rb *RedisBucket) Take() (time.Duration, error) {
+	ms, err := takeScript.Run(rb.cli, []string{rb.key}, rb.rate,
+		rb.burst).Int64()
+	if err != nil {
+		return 0, err
+	}
+	return time.Duration(ms) * time.Millisecond, nil
+}
+
+func secs(s float64) time.Duration {
+	return time.Duration(math.Ceil(s * float64(time.Second)))
+}
@@ -0,0 +1,84 @@
+package ratelimit
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestBucket(t *testing.T) {
+	now := time.Now()
+	b := NewBucket(2, 3)
+	b.now = func() time.Time { return now }
+	b.last = now
+
+	for i, step := range []struct {
+		advance time.Duration
+		wait    time.Duration
+	}{
+		{wait: 0},
+		{wait: 0},
+		{wait: 0},
+		{wait: 500 * time.Millisecond},
+		{advance: 250 * time.Millisecond, wait: 250 * time.Millisecond},
+		{advance: 250 * time.Millisecond, wait: 0},
+		{advance: time.Hour, wait: 0},
+		{wait: 0},
+		{wait: 0},
+		{wait: 500 * time.Millisecond},
+	} {
+		now = now.Add(step.advance)
+		wait, err := b.Take()
+		if err != nil {
+			t.Fatalf("Got unexpected error: %v", err)
+		}
+		if wait != step.wait {
+			t.Fatalf("%d: Expected wait %v, got %v", i, step.wait, wait)
+		}
+	}
+}
+
+// fixedWait is a limiter that always has the same wait, and counts the
+// attempts.
+type fixedWait struct {
+	wait  time.Duration
+	takes int
+}
+
+func (fw *fixedWait) Take() (time.Duration, error) {
+	fw.takes++
+	if fw.takes > 2 {
+		return 0, nil
+	}
+	return fw.wait, nil
+}
+
+func TestWait(t *testing.T) {
+	for _, test := range []struct {
+		wait    time.Duration
+		maxWait time.Duration
+		ctxWait time.Duration
+		err     error
+		takes   int
+	}{
+		{wait: time.Millisecond, maxWait: time.Second, err: nil, takes: 3},
+		{wait: time.Second, maxWait: 10 * time.Millisecond, err: ErrThrottled,
+			takes: 1},
+		{wait: time.Second, maxWait: time.Minute,
+			ctxWait: 10 * time.Millisecond, err: ErrThrottled, takes: 1},
+	} {
+		ctx := context.Background()
+		if test.ctxWait > 0 {
+			var cancel context.CancelFunc
+			ctx, cancel = context.WithTimeout(ctx, test.ctxWait)
+			defer cancel()
+		}
+		fw := &fixedWait{wait: test.wait}
+		if err := Wait(ctx, fw, test.maxWait); err != test.err {
+			t.Fatalf("Expected error %v, got %v", test.err, err)
+		}
+		if fw.takes != test.takes {
+			t.Fatalf("Expected %d takes, got %d", test.takes, fw.takes)
+		}
+	}
+}
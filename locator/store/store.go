@@ -13,11 +13,15 @@ import (
 	"github.com/go-redis/redis"
 )
 
-// Store is the data store abstraction.
+// Store is the data store abstraction.  Latencies are pushed onto the
+// list for the operation, provider or type of workflow step, and every
+// other stat is a counter, incremented with Incr under its key from the
+// types package, such as types.StatsKey(op, "success").
 type Store interface {
-	StoreLatency(d time.Duration) error
-	AddSuccess() error
-	AddError() error
+	StoreLatency(op string, d time.Duration) error
+	StoreProviderLatency(provider string, d time.Duration) error
+	StoreStepLatency(stepType string, d time.Duration) error
+	Incr(key string) error
 	Clear() error
 	AcquireLock() (*locking.Lock, error)
 	Unlock(lock *locking.Lock) error
@@ -47,14 +51,20 @@ func (rs *RedisStore) Clear() error {
 	return rs.cli.FlushDB().Err()
 }
 
-func (rs *RedisStore) StoreLatency(d time.Duration) error {
-	return rs.cli.LPush(types.LatencyKey, int64(d)).Err()
+func (rs *RedisStore) StoreLatency(op string, d time.Duration) error {
+	return rs.cli.LPush(types.StatsKey(op, "latency"), int64(d)).Err()
 }
 
-func (rs *RedisStore) AddSuccess() error {
-	return rs.cli.Incr(types.SuccessKey).Err()
+func (rs *RedisStore) StoreProviderLatency(provider string,
+	d time.Duration) error {
+	return rs.cli.LPush(types.ProviderKey(provider, "latency"), int64(d)).Err()
 }
 
-func (rs *RedisStore) AddError() error {
-	return rs.cli.Incr(types.ErrorKey).Err()
+func (rs *RedisStore) StoreStepLatency(stepType string,
+	d time.Duration) error {
+	return rs.cli.LPush(types.StepKey(stepType, "latency"), int64(d)).Err()
+}
+
+func (rs *RedisStore) Incr(key string) error {
+	return rs.cli.Incr(key).Err()
 }
@@ -0,0 +1,64 @@
+// Package storetest has a Store for unit tests, which counts the stats
+// it is sent, rather than storing them in redis.
+package storetest
+
+import (
+	"sync"
+	"time"
+
+	"github.com/gdotgordon/locator-demo/locator/locking"
+	"github.com/gdotgordon/locator-demo/locator/types"
+)
+
+// Store counts the stats sent to each key, with a latency counted under
+// the key of the list it would be pushed onto.  The zero value is ready
+// to use.
+type Store struct {
+	mu     sync.Mutex
+	counts map[string]int
+}
+
+// Count returns the number of stats sent to the key.
+func (s *Store) Count(key string) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.counts[key]
+}
+
+func (s *Store) StoreLatency(op string, d time.Duration) error {
+	return s.Incr(types.StatsKey(op, "latency"))
+}
+
+func (s *Store) StoreProviderLatency(provider string,
+	d time.Duration) error {
+	return s.Incr(types.ProviderKey(provider, "latency"))
+}
+
+func (s *Store) StoreStepLatency(stepType string, d time.Duration) error {
+	return s.Incr(types.StepKey(stepType, "latency"))
+}
+
+func (s *Store) Incr(key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.counts == nil {
+		s.counts = make(map[string]int)
+	}
+	s.counts[key]++
+	return nil
+}
+
+func (s *Store) Clear() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.counts = nil
+	return nil
+}
+
+func (s *Store) AcquireLock() (*locking.Lock, error) {
+	return nil, nil
+}
+
+func (s *Store) Unlock(lock *locking.Lock) error {
+	return nil
+}
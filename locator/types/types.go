@@ -1,5 +1,10 @@
 package types
 
+import (
+	"encoding/json"
+	"time"
+)
+
 const (
 	KeyPrefix  = "locator:"
 	LatencyKey = KeyPrefix + "latency"
@@ -8,23 +13,470 @@ const (
 	LockKey    = KeyPrefix + "lock"
 )
 
+// Operations, which label the stats events so the analyzer can tell
+// them apart.
+const (
+	OpLookup  = "lookup"
+	OpReverse = "reverse"
+	OpBatch   = "batch"
+)
+
+// StatsKey returns the key for the named stat ("latency", "success",
+// "error", "throttled" or "canceled") of an operation.  Lookups keep the
+// original unlabelled keys, while the other operations have the operation
+// name after the prefix, for example "locator:reverse:success".
+func StatsKey(op, stat string) string {
+	if op == OpLookup {
+		return KeyPrefix + stat
+	}
+	return KeyPrefix + op + ":" + stat
+}
+
+// CacheKey returns the key for the named stat ("hit", "miss", "eviction"
+// or "coalesced") of a cache tier, for example "locator:cache:redis:hit".
+func CacheKey(tier, stat string) string {
+	return KeyPrefix + "cache:" + tier + ":" + stat
+}
+
+// BreakerKey returns the key counting the transitions of a circuit
+// breaker to the state, for example "locator:breaker:census:open".
+func BreakerKey(breaker, state string) string {
+	return KeyPrefix + "breaker:" + breaker + ":" + state
+}
+
+// RejectedKey returns the key counting the API requests rejected for the
+// reason, for example "locator:rejected:rate".
+func RejectedKey(reason string) string {
+	return KeyPrefix + "rejected:" + reason
+}
+
+// ErrorClassKey returns the key counting the failures of the class, for
+// example "locator:errors:upstream_timeout".
+func ErrorClassKey(class string) string {
+	return KeyPrefix + "errors:" + class
+}
+
+// JobKey returns the key counting the job lifecycle events of the state,
+// for example "locator:jobs:succeeded".
+func JobKey(state string) string {
+	return KeyPrefix + "jobs:" + state
+}
+
+// GeofenceKey returns the key counting the lookups found in the geofence,
+// for example "locator:geofence:downtown".
+func GeofenceKey(name string) string {
+	return KeyPrefix + "geofence:" + name
+}
+
+// StepKey returns the key for the named stat ("latency", "success" or
+// "error") of a type of workflow step, for example
+// "locator:step:geocode:success".
+func StepKey(stepType, stat string) string {
+	return KeyPrefix + "step:" + stepType + ":" + stat
+}
+
+// ProviderKey returns the key for the named stat of a geocoding provider,
+// for example "locator:provider:census:success".
+func ProviderKey(provider, stat string) string {
+	return KeyPrefix + "provider:" + provider + ":" + stat
+}
+
+// Outcomes of a single item in a batch lookup.
+const (
+	BatchFound    = "found"
+	BatchNotFound = "not_found"
+	BatchError    = "error"
+)
+
+// Job states.  A job is queued until a worker starts it, and then it
+// either succeeds, or fails if none of its lookups could be done.  The
+// states label the job lifecycle events, where a job that starts is
+// reported as "started".  Workflows go through the same states.
+const (
+	JobQueued    = "queued"
+	JobRunning   = "running"
+	JobStarted   = "started"
+	JobSucceeded = "succeeded"
+	JobFailed    = "failed"
+)
+
+// Workflow step states.  A step is pending until the steps it depends on
+// have succeeded, and is skipped if any of them didn't.
+const (
+	StepPending   = "pending"
+	StepRunning   = "running"
+	StepSucceeded = "succeeded"
+	StepFailed    = "failed"
+	StepSkipped   = "skipped"
+)
+
+// Match indicators returned by the Census batch geocoder.
+const (
+	CensusMatch   = "Match"
+	CensusNoMatch = "No_Match"
+	CensusTie     = "Tie"
+)
+
 type StatusResponse struct {
 	Status string `json:"status"`
 }
 
+// AddressRequest is an address to geocode.  When there are multiple
+// matches, the first is used, unless PreferInputMatch is set, in which
+// case the one that agrees most with the zip, city and state is used.
+// Enrich asks for the Census geographies containing the address too.
+//
+// The address may be given either in structured form, or as a single
+// free-form string in OneLine, but not both.  Benchmark and Vintage, if
+// set, override the Census benchmark and geographies vintage configured
+// for the service.
 type AddressRequest struct {
-	StructureNumber string `json:"struct_number"`
-	Street          string `json:"street"`
-	City            string `json:"city,omitempty"`
-	State           string `json:"state,omitempty"`
-	Zip             string `json:"zip,omitempty"`
+	StructureNumber  string `json:"struct_number"`
+	Street           string `json:"street"`
+	OneLine          string `json:"oneline,omitempty"`
+	City             string `json:"city,omitempty"`
+	State            string `json:"state,omitempty"`
+	Zip              string `json:"zip,omitempty"`
+	PreferInputMatch bool   `json:"prefer_input_match,omitempty"`
+	Enrich           bool   `json:"enrich,omitempty"`
+	Benchmark        string `json:"benchmark,omitempty"`
+	Vintage          string `json:"vintage,omitempty"`
 }
 
 type Coords struct {
 	X float64 `json:"x"`
 	Y float64 `json:"y"`
 }
+
+// AddressResponse is the result of geocoding an address.  The top level
+// fields describe the chosen match, and Matches has every candidate the
+// service returned.  A zero MatchCount means the address was not found.
+// Provider is the name of the geocoding provider that answered, and
+// Cached is set if the response came from the cache instead.  Normalized
+// is the standardized form of the address that was looked up, and
+// Geofences the names of the geofences the address is in.
 type AddressResponse struct {
-	Zip         string `json:"zip"`
-	Coordinates Coords `json:"coordinates"`
+	Zip            string             `json:"zip"`
+	Coordinates    Coords             `json:"coordinates"`
+	MatchCount     int                `json:"match_count"`
+	MatchedAddress string             `json:"matched_address,omitempty"`
+	Components     *AddressComponents `json:"address_components,omitempty"`
+	TigerLineID    string             `json:"tiger_line_id,omitempty"`
+	Side           string             `json:"side,omitempty"`
+	Geographies    *Geographies       `json:"geographies,omitempty"`
+	Matches        []AddressMatch     `json:"matches,omitempty"`
+	Provider       string             `json:"provider,omitempty"`
+	Cached         bool               `json:"cached,omitempty"`
+	Normalized     *NormalizedAddress `json:"normalized,omitempty"`
+	Geofences      []string           `json:"geofences,omitempty"`
+}
+
+// NormalizedAddress is an address request in USPS standard form.  Zip4 is
+// the ZIP+4 add-on, if the request had one.
+type NormalizedAddress struct {
+	StructureNumber string `json:"struct_number,omitempty"`
+	Street          string `json:"street,omitempty"`
+	OneLine         string `json:"oneline,omitempty"`
+	City            string `json:"city,omitempty"`
+	State           string `json:"state,omitempty"`
+	Zip             string `json:"zip,omitempty"`
+	Zip4            string `json:"zip4,omitempty"`
+}
+
+// V1 returns the response in the original v1 shape, which has only the
+// zip and coordinates, along with any geofences.
+func (ar *AddressResponse) V1() AddressResponseV1 {
+	return AddressResponseV1{Zip: ar.Zip, Coordinates: ar.Coordinates,
+		Geofences: ar.Geofences}
+}
+
+// AddressResponseV1 is the v1 lookup response.
+type AddressResponseV1 struct {
+	Zip         string   `json:"zip"`
+	Coordinates Coords   `json:"coordinates"`
+	Geofences   []string `json:"geofences,omitempty"`
+}
+
+// AddressMatch is one candidate match for an address.
+type AddressMatch struct {
+	MatchedAddress string            `json:"matched_address"`
+	Coordinates    Coords            `json:"coordinates"`
+	Components     AddressComponents `json:"address_components"`
+	TigerLineID    string            `json:"tiger_line_id,omitempty"`
+	Side           string            `json:"side,omitempty"`
+	Geographies    *Geographies      `json:"geographies,omitempty"`
+}
+
+// AddressComponents are the parts of a matched address, as parsed by the
+// Census service.  The from and to addresses are the house number range
+// of the matched street segment.
+type AddressComponents struct {
+	FromAddress     string `json:"from_address,omitempty"`
+	ToAddress       string `json:"to_address,omitempty"`
+	PreQualifier    string `json:"pre_qualifier,omitempty"`
+	PreDirection    string `json:"pre_direction,omitempty"`
+	PreType         string `json:"pre_type,omitempty"`
+	StreetName      string `json:"street_name,omitempty"`
+	SuffixType      string `json:"suffix_type,omitempty"`
+	SuffixDirection string `json:"suffix_direction,omitempty"`
+	SuffixQualifier string `json:"suffix_qualifier,omitempty"`
+	City            string `json:"city,omitempty"`
+	State           string `json:"state,omitempty"`
+	Zip             string `json:"zip,omitempty"`
+}
+
+// BatchResult is the outcome of one address in a batch lookup.  Index is
+// the position of the address in the request array, so callers can match
+// results up with their input.  ErrorClass is the class of the error, as
+// in the error stats.
+type BatchResult struct {
+	Index      int              `json:"index"`
+	Status     string           `json:"status"`
+	Response   *AddressResponse `json:"response,omitempty"`
+	Error      string           `json:"error,omitempty"`
+	ErrorClass string           `json:"error_class,omitempty"`
+}
+
+// BatchResponse is the response to a batch lookup.  The results are in
+// the same order as the addresses in the request.
+type BatchResponse struct {
+	Found    int           `json:"found"`
+	NotFound int           `json:"not_found"`
+	Failed   int           `json:"failed"`
+	Results  []BatchResult `json:"results"`
+}
+
+// Job is the state of an asynchronous lookup job.  Done counts the
+// addresses looked up so far, out of Total, and Progress is the same as a
+// fraction.  Results has the outcome of each address that is done, in the
+// order of the request.
+type Job struct {
+	ID       string        `json:"id"`
+	Status   string        `json:"status"`
+	Total    int           `json:"total"`
+	Done     int           `json:"done"`
+	Progress float64       `json:"progress"`
+	Found    int           `json:"found"`
+	NotFound int           `json:"not_found"`
+	Failed   int           `json:"failed"`
+	Error    string        `json:"error,omitempty"`
+	Created  time.Time     `json:"created"`
+	Started  *time.Time    `json:"started,omitempty"`
+	Finished *time.Time    `json:"finished,omitempty"`
+	Results  []BatchResult `json:"results,omitempty"`
+}
+
+// WorkflowRequest is a workflow to run: a set of steps, each of which
+// runs once the steps it depends on have succeeded.
+type WorkflowRequest struct {
+	Steps []WorkflowStep `json:"steps"`
+}
+
+// WorkflowStep is one step of a workflow.  The input of the step is
+// either given in Address or Coordinates, or taken from the output of
+// the steps it depends on.  URL is the endpoint of a webhook step.
+type WorkflowStep struct {
+	ID          string          `json:"id"`
+	Type        string          `json:"type"`
+	DependsOn   []string        `json:"depends_on,omitempty"`
+	Address     *AddressRequest `json:"address,omitempty"`
+	Coordinates *Coords         `json:"coordinates,omitempty"`
+	URL         string          `json:"url,omitempty"`
+}
+
+// StepState is the progress of one step of a workflow, with its output
+// once it has succeeded.
+type StepState struct {
+	Status   string          `json:"status"`
+	Started  *time.Time      `json:"started,omitempty"`
+	Finished *time.Time      `json:"finished,omitempty"`
+	Duration string          `json:"duration,omitempty"`
+	Output   json.RawMessage `json:"output,omitempty"`
+	Error    string          `json:"error,omitempty"`
+}
+
+// Workflow is the state of a workflow, and of each of its steps, keyed by
+// the step id.
+type Workflow struct {
+	ID       string                `json:"id"`
+	Status   string                `json:"status"`
+	Steps    []WorkflowStep        `json:"steps"`
+	State    map[string]*StepState `json:"state"`
+	Error    string                `json:"error,omitempty"`
+	Created  time.Time             `json:"created"`
+	Started  *time.Time            `json:"started,omitempty"`
+	Finished *time.Time            `json:"finished,omitempty"`
+}
+
+// WebhookOutput is the output of a webhook step: the status of the
+// response, and its body, if it was JSON.
+type WebhookOutput struct {
+	Status int             `json:"status"`
+	Body   json.RawMessage `json:"body,omitempty"`
+}
+
+// Place is an address in the geospatial index of located addresses.
+// Address is the matched address, or the normalized one if the provider
+// didn't give one, and Located the last time it was looked up.  Distance
+// is from the center of a search, in the unit of the search.
+type Place struct {
+	Address     string             `json:"address"`
+	Coordinates Coords             `json:"coordinates"`
+	Zip         string             `json:"zip,omitempty"`
+	Normalized  *NormalizedAddress `json:"normalized,omitempty"`
+	Located     time.Time          `json:"located"`
+	Distance    float64            `json:"distance"`
+}
+
+// NearbyRequest searches for the located addresses within the radius of
+// either the coordinates or the address, which is looked up first.  The
+// unit is one of "m", "km", "mi" or "ft", and Offset and Limit select the
+// page of results.
+type NearbyRequest struct {
+	Coordinates *Coords         `json:"coordinates,omitempty"`
+	Address     *AddressRequest `json:"address,omitempty"`
+	Radius      float64         `json:"radius"`
+	Unit        string          `json:"unit,omitempty"`
+	Offset      int             `json:"offset,omitempty"`
+	Limit       int             `json:"limit,omitempty"`
+}
+
+// WithinRequest searches for the located addresses within the bounding
+// box from its south west corner, Min, to its north east corner, Max.
+// The results are sorted by their distance from the center of the box.
+type WithinRequest struct {
+	Min    Coords `json:"min"`
+	Max    Coords `json:"max"`
+	Unit   string `json:"unit,omitempty"`
+	Offset int    `json:"offset,omitempty"`
+	Limit  int    `json:"limit,omitempty"`
+}
+
+// PlacesResponse is a page of the results of a search of the located
+// addresses, nearest first.  NextOffset is the offset of the next page,
+// if there is one.
+type PlacesResponse struct {
+	Center     Coords  `json:"center"`
+	Unit       string  `json:"unit"`
+	Offset     int     `json:"offset"`
+	Limit      int     `json:"limit"`
+	NextOffset int     `json:"next_offset,omitempty"`
+	Results    []Place `json:"results"`
+}
+
+// Location is a point given either by its coordinates, or by an address
+// to be looked up.
+type Location struct {
+	Coordinates *Coords         `json:"coordinates,omitempty"`
+	Address     *AddressRequest `json:"address,omitempty"`
+}
+
+// DistanceRequest asks for the distance between two locations.  The unit
+// is one of "m", "km", "mi", "ft" or "nmi".
+type DistanceRequest struct {
+	From Location `json:"from"`
+	To   Location `json:"to"`
+	Unit string   `json:"unit,omitempty"`
+}
+
+// Distance is the great-circle distance between two points, on a sphere
+// by the haversine formula, and on the WGS-84 ellipsoid by Vincenty's.
+// Vincenty is left out for nearly antipodal points, where it can't be
+// computed.
+type Distance struct {
+	Haversine float64  `json:"haversine"`
+	Vincenty  *float64 `json:"vincenty,omitempty"`
+}
+
+// DistanceResponse is the distance between two locations, along with the
+// coordinates of each.
+type DistanceResponse struct {
+	From Coords `json:"from"`
+	To   Coords `json:"to"`
+	Unit string `json:"unit"`
+	Distance
+}
+
+// MatrixRequest asks for the distance from each of the origins to each of
+// the destinations.
+type MatrixRequest struct {
+	Origins      []Location `json:"origins"`
+	Destinations []Location `json:"destinations"`
+	Unit         string     `json:"unit,omitempty"`
+}
+
+// ResolvedLocation is the coordinates of a location, or the error if they
+// couldn't be had.
+type ResolvedLocation struct {
+	Coordinates *Coords `json:"coordinates,omitempty"`
+	Error       string  `json:"error,omitempty"`
+	ErrorClass  string  `json:"error_class,omitempty"`
+}
+
+// MatrixCell is the distance from an origin to a destination, or the
+// error if either of them couldn't be located.
+type MatrixCell struct {
+	Distance   *Distance `json:"distance,omitempty"`
+	Error      string    `json:"error,omitempty"`
+	ErrorClass string    `json:"error_class,omitempty"`
+}
+
+// MatrixResponse is the response to a distance matrix request.  Rows has
+// a row for each origin, with a cell for each destination, in the order
+// of the request.
+type MatrixResponse struct {
+	Unit         string             `json:"unit"`
+	Origins      []ResolvedLocation `json:"origins"`
+	Destinations []ResolvedLocation `json:"destinations"`
+	Rows         [][]MatrixCell     `json:"rows"`
+}
+
+// Geofence is a named area, whose geometry is a GeoJSON Polygon or
+// MultiPolygon, with its coordinates as [longitude, latitude] positions.
+// The properties are kept as they are given, so a GeoJSON Feature can be
+// used as a geofence.
+type Geofence struct {
+	Name       string                 `json:"name"`
+	Geometry   Geometry               `json:"geometry"`
+	Properties map[string]interface{} `json:"properties,omitempty"`
+	Updated    time.Time              `json:"updated"`
+}
+
+// Geometry is a GeoJSON geometry, whose coordinates are parsed according
+// to its type.
+type Geometry struct {
+	Type        string          `json:"type"`
+	Coordinates json.RawMessage `json:"coordinates"`
+}
+
+// CensusBatchResult is one row of the response from the Census batch
+// geocoder.  Only Match rows have the matched address, coordinates and
+// TIGER line fields filled in.
+type CensusBatchResult struct {
+	ID             string  `json:"id"`
+	InputAddress   string  `json:"input_address"`
+	Match          string  `json:"match"`
+	Exact          bool    `json:"exact"`
+	MatchedAddress string  `json:"matched_address,omitempty"`
+	Coordinates    *Coords `json:"coordinates,omitempty"`
+	TigerLineID    string  `json:"tiger_line_id,omitempty"`
+	Side           string  `json:"side,omitempty"`
+}
+
+// Geographies are the Census FIPS codes of the areas containing a point.
+// Which of them are filled in depends on the layers requested.
+type Geographies struct {
+	State                 string `json:"state"`
+	County                string `json:"county"`
+	Tract                 string `json:"tract"`
+	BlockGroup            string `json:"block_group,omitempty"`
+	Block                 string `json:"block"`
+	CongressionalDistrict string `json:"congressional_district,omitempty"`
+}
+
+// ReverseResponse is the response to a reverse lookup of coordinates.
+type ReverseResponse struct {
+	Coordinates Coords      `json:"coordinates"`
+	Geographies Geographies `json:"geographies"`
+	Provider    string      `json:"provider,omitempty"`
 }
@@ -0,0 +1,171 @@
+package workflow
+
+import (
+	"encoding/json"
+	"strings"
+	"time"
+
+	"github.com/gdotgordon/locator-demo/locator/lease"
+	"github.com/gdotgordon/locator-demo/locator/types"
+	"github.com/go-redis/redis"
+)
+
+const (
+	// KeyPrefix is the prefix of the Redis workflow keys.
+	KeyPrefix = "workflow:"
+
+	// DefaultTTL is how long a workflow is kept after it is created, and
+	// again after it finishes.
+	DefaultTTL = 24 * time.Hour
+
+	// stepField prefixes the hash fields of the step states.
+	stepField = "step:"
+)
+
+// Queue stores the workflows, and the queue of workflows waiting for a
+// worker, which the workers claim as described by lease.Claims.  The
+// claim on a workflow ends when it is finished.  Get returns nil, and no
+// error, for a workflow that doesn't exist.
+type Queue interface {
+	lease.Claims
+	Push(wf *types.Workflow) error
+	Start(id string) error
+	SaveStep(id, step string, st *types.StepState) error
+	Finish(id, status, msg string) error
+	Get(id string) (*types.Workflow, error)
+}
+
+// RedisQueue implements the Queue interface for the Redis client.  Each
+// workflow is a hash of its state, with a field for each step, which
+// expires after the ttl.
+type RedisQueue struct {
+	*lease.Queue
+	cli *redis.Client
+	ttl time.Duration
+}
+
+// NewRedisQueue creates a workflow queue using the Redis client, whose
+// claims last for the leaseTTL.
+func NewRedisQueue(cli *redis.Client, ttl,
+	leaseTTL time.Duration) *RedisQueue {
+	if ttl <= 0 {
+		ttl = DefaultTTL
+	}
+	q := lease.New(cli, KeyPrefix, "", leaseTTL)
+	return &RedisQueue{Queue: q, cli: cli, ttl: ttl}
+}
+
+func workflowKey(id string) string { return KeyPrefix + id }
+
+// Push saves the workflow and queues it.
+func (rq *RedisQueue) Push(wf *types.Workflow) error {
+	steps, err := json.Marshal(wf.Steps)
+	if err != nil {
+		return err
+	}
+	fields := map[string]interface{}{
+		"status":  wf.Status,
+		"steps":   steps,
+		"created": wf.Created.Format(time.RFC3339Nano),
+	}
+	for id, st := range wf.State {
+		b, err := json.Marshal(st)
+		if err != nil {
+			return err
+		}
+		fields[stepField+id] = b
+	}
+	_, err = rq.cli.TxPipelined(func(p redis.Pipeliner) error {
+		p.HMSet(workflowKey(wf.ID), fields)
+		p.Expire(workflowKey(wf.ID), rq.ttl)
+		rq.Add(p, wf.ID)
+		return nil
+	})
+	return err
+}
+
+// Start marks the workflow as running.  A resumed workflow keeps the time
+// it was first started.
+func (rq *RedisQueue) Start(id string) error {
+	_, err := rq.cli.TxPipelined(func(p redis.Pipeliner) error {
+		p.HSet(workflowKey(id), "status", types.JobRunning)
+		p.HSetNX(workflowKey(id), "started",
+			time.Now().Format(time.RFC3339Nano))
+		return nil
+	})
+	return err
+}
+
+// SaveStep saves the state of a step.
+func (rq *RedisQueue) SaveStep(id, step string, st *types.StepState) error {
+	b, err := json.Marshal(st)
+	if err != nil {
+		return err
+	}
+	return rq.cli.HSet(workflowKey(id), stepField+step, b).Err()
+}
+
+// Finish records the final status of the workflow, ends the claim on it,
+// and keeps it for another ttl so the outputs can be collected.
+func (rq *RedisQueue) Finish(id, status, msg string) error {
+	_, err := rq.cli.TxPipelined(func(p redis.Pipeliner) error {
+		p.HMSet(workflowKey(id), map[string]interface{}{
+			"status":   status,
+			"error":    msg,
+			"finished": time.Now().Format(time.RFC3339Nano),
+		})
+		p.Expire(workflowKey(id), rq.ttl)
+		rq.Done(p, id)
+		return nil
+	})
+	return err
+}
+
+// Get returns the workflow, with the state of each step.
+func (rq *RedisQueue) Get(id string) (*types.Workflow, error) {
+	fields, err := rq.cli.HGetAll(workflowKey(id)).Result()
+	if err != nil {
+		return nil, err
+	}
+	if len(fields) == 0 {
+		return nil, nil
+	}
+	wf := types.Workflow{ID: id, Status: fields["status"],
+		Error: fields["error"], State: make(map[string]*types.StepState)}
+	if err := json.Unmarshal([]byte(fields["steps"]), &wf.Steps); err != nil {
+		return nil, err
+	}
+	for name, v := range fields {
+		if !strings.HasPrefix(name, stepField) {
+			continue
+		}
+		var st types.StepState
+		if err := json.Unmarshal([]byte(v), &st); err != nil {
+			return nil, err
+		}
+		wf.State[strings.TrimPrefix(name, stepField)] = &st
+	}
+	if wf.Created, err = time.Parse(time.RFC3339Nano,
+		fields["created"]); err != nil {
+		return nil, err
+	}
+	if wf.Started, err = parseTime(fields["started"]); err != nil {
+		return nil, err
+	}
+	if wf.Finished, err = parseTime(fields["finished"]); err != nil {
+		return nil, err
+	}
+	return &wf, nil
+}
+
+// parseTime parses an optional time field.
+func parseTime(v string) (*time.Time, error) {
+	if v == "" {
+		return nil, nil
+	}
+	t, err := time.Parse(time.RFC3339Nano, v)
+	if err != nil {
+		return nil, err
+	}
+	return &t, nil
+}
@@ -0,0 +1,235 @@
+package workflow
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/gdotgordon/locator-demo/locator/geolocator"
+	"github.com/gdotgordon/locator-demo/locator/lease"
+	"github.com/gdotgordon/locator-demo/locator/store"
+	"github.com/gdotgordon/locator-demo/locator/types"
+)
+
+const (
+	// DefaultWorkers is the number of workflows a process runs at once.
+	DefaultWorkers = 4
+
+	// DefaultStepTimeout bounds how long a step may take.
+	DefaultStepTimeout = 30 * time.Second
+)
+
+// Config controls the workflow runner.  LeaseTTL should match that of
+// the queue, so that claims are renewed in time, and abandoned workflows
+// are looked for as often as they can expire.
+type Config struct {
+	Workers     int
+	StepTimeout time.Duration
+	LeaseTTL    time.Duration
+}
+
+// Runner runs the queued workflows.  Each of its workers runs one
+// workflow at a time, with the steps that are ready running in parallel.
+type Runner struct {
+	q     Queue
+	loc   geolocator.Geolocator
+	store store.Store
+	cfg   Config
+	hooks *webhooks
+}
+
+// NewRunner creates a runner using the geolocator for the lookups.
+func NewRunner(q Queue, loc geolocator.Geolocator, st store.Store,
+	cfg Config) *Runner {
+	if cfg.Workers <= 0 {
+		cfg.Workers = DefaultWorkers
+	}
+	if cfg.StepTimeout <= 0 {
+		cfg.StepTimeout = DefaultStepTimeout
+	}
+	return &Runner{q: q, loc: loc, store: st, cfg: cfg,
+		hooks: newWebhooks()}
+}
+
+// Run runs the workers until the context is cancelled, and returns once
+// they have stopped.  The workflows that are cut short are released, to
+// be resumed by another worker, or this one when it restarts.  Meanwhile,
+// the workflows abandoned by workers that died are recovered.
+func (r *Runner) Run(ctx context.Context) {
+	lease.Run(ctx, r.q, "workflow", r.cfg.Workers, r.cfg.LeaseTTL,
+		r.runWorkflow)
+}
+
+// runWorkflow runs the steps of the workflow that aren't done yet, and
+// returns whether it is done with the workflow, which it isn't if it was
+// cut short.
+func (r *Runner) runWorkflow(ctx context.Context, id string) bool {
+	wf, err := r.q.Get(id)
+	if err == nil && wf == nil {
+		err = errors.New("Workflow has expired")
+	}
+	if err != nil {
+		log.Printf("error reading workflow '%s': %v", id, err)
+		if err := r.q.Finish(id, types.JobFailed, err.Error()); err != nil {
+			log.Printf("error finishing workflow '%s': %v", id, err)
+		}
+		return true
+	}
+	if err := r.q.Start(id); err != nil {
+		log.Printf("error starting workflow '%s': %v", id, err)
+	}
+
+	err = r.execute(ctx, wf)
+	if ctx.Err() != nil {
+		return false
+	}
+	status, msg := types.JobSucceeded, ""
+	if err != nil {
+		status, msg = types.JobFailed, err.Error()
+	}
+	if err := r.q.Finish(id, status, msg); err != nil {
+		log.Printf("error finishing workflow '%s': %v", id, err)
+	}
+	return true
+}
+
+// stepDone is the outcome of a step.
+type stepDone struct {
+	id     string
+	output []byte
+	err    error
+	start  time.Time
+}
+
+// execute runs the steps.  Each time a step finishes, every pending step
+// whose dependencies have all succeeded is started, and every one with a
+// dependency that didn't is skipped.  Steps that were running when the
+// workflow was interrupted are run again.  The workflow fails if any step
+// doesn't succeed.
+func (r *Runner) execute(ctx context.Context, wf *types.Workflow) error {
+	for _, s := range wf.Steps {
+		st := wf.State[s.ID]
+		if st == nil || st.Status == types.StepRunning {
+			wf.State[s.ID] = &types.StepState{Status: types.StepPending}
+		}
+	}
+
+	done := make(chan stepDone)
+	var running int
+	for {
+		r.schedule(ctx, wf, done, &running)
+		if running == 0 {
+			break
+		}
+		d := <-done
+		running--
+		if ctx.Err() != nil {
+			// Shutting down, so leave the step to be run again.
+			continue
+		}
+		r.record(wf, d)
+	}
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+
+	var failed []string
+	for _, s := range wf.Steps {
+		if wf.State[s.ID].Status != types.StepSucceeded {
+			failed = append(failed, s.ID)
+		}
+	}
+	if len(failed) > 0 {
+		return fmt.Errorf("Steps did not succeed: %s",
+			strings.Join(failed, ", "))
+	}
+	return nil
+}
+
+// schedule starts the steps that are ready, and skips those that never
+// will be, until there is nothing more to do.
+func (r *Runner) schedule(ctx context.Context, wf *types.Workflow,
+	done chan<- stepDone, running *int) {
+	if ctx.Err() != nil {
+		return
+	}
+	for progress := true; progress; {
+		progress = false
+		for _, s := range wf.Steps {
+			st := wf.State[s.ID]
+			if st.Status != types.StepPending {
+				continue
+			}
+			ready, blocked := true, false
+			for _, dep := range s.DependsOn {
+				switch wf.State[dep].Status {
+				case types.StepSucceeded:
+				case types.StepFailed, types.StepSkipped:
+					blocked = true
+				default:
+					ready = false
+				}
+			}
+			switch {
+			case blocked:
+				st.Status = types.StepSkipped
+				r.save(wf.ID, s.ID, st)
+				progress = true
+			case ready:
+				now := time.Now()
+				st.Status, st.Started = types.StepRunning, &now
+				r.save(wf.ID, s.ID, st)
+				*running++
+				inputs := make(map[string][]byte)
+				for _, dep := range s.DependsOn {
+					inputs[dep] = wf.State[dep].Output
+				}
+				go func(s types.WorkflowStep) {
+					out, err := r.runStep(ctx, wf.ID, s, inputs)
+					done <- stepDone{id: s.ID, output: out, err: err,
+						start: now}
+				}(s)
+			}
+		}
+	}
+}
+
+// record saves the outcome of a step, and sends its stats.
+func (r *Runner) record(wf *types.Workflow, d stepDone) {
+	var stepType string
+	for _, s := range wf.Steps {
+		if s.ID == d.id {
+			stepType = s.Type
+		}
+	}
+	now := time.Now()
+	elapsed := now.Sub(d.start)
+	st := wf.State[d.id]
+	st.Finished, st.Duration = &now, elapsed.String()
+	if err := r.store.StoreStepLatency(stepType, elapsed); err != nil {
+		log.Printf("error storing step latency, skipped: %v", err)
+	}
+	if d.err != nil {
+		st.Status, st.Error = types.StepFailed, d.err.Error()
+		if err := r.store.Incr(types.StepKey(stepType, "error")); err != nil {
+			log.Printf("error storing step error, skipped: %v", err)
+		}
+	} else {
+		st.Status, st.Output = types.StepSucceeded, d.output
+		if err := r.store.Incr(types.StepKey(stepType, "success")); err != nil {
+			log.Printf("error storing step success, skipped: %v", err)
+		}
+	}
+	r.save(wf.ID, d.id, st)
+}
+
+// save persists the state of a step.
+func (r *Runner) save(id, step string, st *types.StepState) {
+	if err := r.q.SaveStep(id, step, st); err != nil {
+		log.Printf("error saving step '%s' of workflow '%s': %v", step, id,
+			err)
+	}
+}
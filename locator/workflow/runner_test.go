@@ -0,0 +1,333 @@
+package workflow
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gdotgordon/locator-demo/locator/store/storetest"
+	"github.com/gdotgordon/locator-demo/locator/types"
+)
+
+// memQueue is an in-memory Queue, so we can run workflows without a
+// redis.  The workflows are copied in and out through JSON, as they would
+// be by the RedisQueue.
+type memQueue struct {
+	mu  sync.Mutex
+	ids chan string
+	wfs map[string][]byte
+}
+
+func newMemQueue() *memQueue {
+	return &memQueue{ids: make(chan string, 10), wfs: make(map[string][]byte)}
+}
+
+func (mq *memQueue) update(id string, fn func(wf *types.Workflow)) error {
+	mq.mu.Lock()
+	defer mq.mu.Unlock()
+	var wf types.Workflow
+	if err := json.Unmarshal(mq.wfs[id], &wf); err != nil {
+		return err
+	}
+	fn(&wf)
+	b, err := json.Marshal(&wf)
+	mq.wfs[id] = b
+	return err
+}
+
+func (mq *memQueue) Push(wf *types.Workflow) error {
+	b, err := json.Marshal(wf)
+	if err != nil {
+		return err
+	}
+	mq.mu.Lock()
+	mq.wfs[wf.ID] = b
+	mq.mu.Unlock()
+	mq.ids <- wf.ID
+	return nil
+}
+
+func (mq *memQueue) Pop(wait time.Duration) (string, error) {
+	select {
+	case id := <-mq.ids:
+		return id, nil
+	case <-time.After(wait):
+		return "", nil
+	}
+}
+
+func (mq *memQueue) Renew(id string) error {
+	return nil
+}
+
+func (mq *memQueue) Release(id string) error {
+	mq.ids <- id
+	return nil
+}
+
+func (mq *memQueue) Recover() (int, error) {
+	return 0, nil
+}
+
+func (mq *memQueue) Start(id string) error {
+	return mq.update(id, func(wf *types.Workflow) {
+		wf.Status = types.JobRunning
+	})
+}
+
+func (mq *memQueue) SaveStep(id, step string, st *types.StepState) error {
+	return mq.update(id, func(wf *types.Workflow) {
+		s := *st
+		wf.State[step] = &s
+	})
+}
+
+func (mq *memQueue) Finish(id, status, msg string) error {
+	return mq.update(id, func(wf *types.Workflow) {
+		now := time.Now()
+		wf.Status, wf.Error, wf.Finished = status, msg, &now
+	})
+}
+
+func (mq *memQueue) Get(id string) (*types.Workflow, error) {
+	mq.mu.Lock()
+	defer mq.mu.Unlock()
+	b, ok := mq.wfs[id]
+	if !ok {
+		return nil, nil
+	}
+	var wf types.Workflow
+	if err := json.Unmarshal(b, &wf); err != nil {
+		return nil, err
+	}
+	return &wf, nil
+}
+
+// barrierLocator finds every address but "bad" at (1, 2).  Its reverse
+// lookups wait until parallel of them have started, and fail if
+// that doesn't happen, so we can tell that steps ran in parallel.
+type barrierLocator struct {
+	lookups  int32
+	parallel int32
+	started  int32
+}
+
+func (bl *barrierLocator) Locate(ctx context.Context,
+	req types.AddressRequest) (*types.AddressResponse, error) {
+	atomic.AddInt32(&bl.lookups, 1)
+	if req.Street == "bad" {
+		return nil, errors.New("bad street")
+	}
+	return &types.AddressResponse{Zip: req.Zip, MatchCount: 1,
+		Coordinates: types.Coords{X: 1, Y: 2}}, nil
+}
+
+func (bl *barrierLocator) Reverse(ctx context.Context,
+	coords types.Coords) (*types.ReverseResponse, error) {
+	atomic.AddInt32(&bl.started, 1)
+	deadline := time.Now().Add(2 * time.Second)
+	for atomic.LoadInt32(&bl.started) < bl.parallel {
+		if time.Now().After(deadline) {
+			return nil, errors.New("reverse lookups not in parallel")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	return &types.ReverseResponse{Coordinates: coords,
+		Geographies: types.Geographies{State: "24", County: "033"}}, nil
+}
+
+// waitFor waits for the workflow to finish.
+func waitFor(t *testing.T, q Queue, id string) *types.Workflow {
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		wf, err := q.Get(id)
+		if err != nil {
+			t.Fatalf("Got unexpected error: %v", err)
+		}
+		if wf.Finished != nil {
+			return wf
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("Expected workflow '%s' to finish", id)
+	return nil
+}
+
+func TestWorkflows(t *testing.T) {
+	var hook struct {
+		sync.Mutex
+		body webhookRequest
+	}
+	srv := httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			b, _ := ioutil.ReadAll(r.Body)
+			hook.Lock()
+			json.Unmarshal(b, &hook.body)
+			hook.Unlock()
+			w.Header().Set("Content-Type", "application/json")
+			w.Write([]byte(`{"ok":true}`))
+		}))
+	defer srv.Close()
+	u, _ := url.Parse(srv.URL)
+
+	q := newMemQueue()
+	ss := &storetest.Store{}
+	bl := &barrierLocator{parallel: 2}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		NewRunner(q, bl, ss, Config{Workers: 2, StepTimeout: 5 * time.Second,
+			LeaseTTL: 50 * time.Millisecond}).Run(ctx)
+		close(done)
+	}()
+	defer func() {
+		cancel()
+		<-done
+	}()
+
+	// The reverse and enrich steps both depend on the geocode, so run
+	// together once it is done, and the webhook waits for both of them.
+	addr := &types.AddressRequest{StructureNumber: "1", Street: "Main St",
+		Zip: "20746"}
+	wf, err := Submit(q, types.WorkflowRequest{Steps: []types.WorkflowStep{
+		{ID: "hook", Type: StepWebhook, DependsOn: []string{"geo", "rich"},
+			URL: srv.URL + "/done"},
+		{ID: "geo", Type: StepReverse, DependsOn: []string{"home"}},
+		{ID: "rich", Type: StepEnrich, DependsOn: []string{"home"}},
+		{ID: "home", Type: StepGeocode, Address: addr},
+	}}, []string{u.Hostname()})
+	if err != nil {
+		t.Fatalf("Got unexpected error: %v", err)
+	}
+	if wf.Status != types.JobQueued ||
+		wf.State["home"].Status != types.StepPending {
+		t.Fatalf("Expected queued workflow, got %+v", wf)
+	}
+	wf = waitFor(t, q, wf.ID)
+	if wf.Status != types.JobSucceeded {
+		t.Fatalf("Expected success, got '%s': %s", wf.Status, wf.Error)
+	}
+	for id, st := range wf.State {
+		if st.Status != types.StepSucceeded || st.Duration == "" {
+			t.Fatalf("Expected step '%s' to succeed, got %+v", id, st)
+		}
+	}
+	var rich types.AddressResponse
+	if err := json.Unmarshal(wf.State["rich"].Output, &rich); err != nil {
+		t.Fatalf("Got unexpected error: %v", err)
+	}
+	if rich.Zip != "20746" || rich.Geographies == nil ||
+		rich.Geographies.County != "033" {
+		t.Fatalf("Expected enriched address, got %+v", rich)
+	}
+	var out types.WebhookOutput
+	if err := json.Unmarshal(wf.State["hook"].Output, &out); err != nil {
+		t.Fatalf("Got unexpected error: %v", err)
+	}
+	if out.Status != http.StatusOK || string(out.Body) != `{"ok":true}` {
+		t.Fatalf("Unexpected webhook output: %+v", out)
+	}
+	hook.Lock()
+	if hook.body.Workflow != wf.ID || hook.body.Step != "hook" ||
+		len(hook.body.Inputs) != 2 || hook.body.Inputs["geo"] == nil {
+		t.Fatalf("Unexpected webhook request: %+v", hook.body)
+	}
+	hook.Unlock()
+
+	// A failed step skips the steps that depend on it, but not the others.
+	bl.parallel = 1
+	wf, err = Submit(q, types.WorkflowRequest{Steps: []types.WorkflowStep{
+		{ID: "home", Type: StepGeocode,
+			Address: &types.AddressRequest{Street: "bad"}},
+		{ID: "geo", Type: StepReverse, DependsOn: []string{"home"}},
+		{ID: "there", Type: StepReverse,
+			Coordinates: &types.Coords{X: 3, Y: 4}},
+	}}, nil)
+	if err != nil {
+		t.Fatalf("Got unexpected error: %v", err)
+	}
+	wf = waitFor(t, q, wf.ID)
+	if wf.Status != types.JobFailed ||
+		wf.Error != "Steps did not succeed: home, geo" {
+		t.Fatalf("Expected failure, got '%s': %s", wf.Status, wf.Error)
+	}
+	for id, exp := range map[string]string{
+		"home":  types.StepFailed,
+		"geo":   types.StepSkipped,
+		"there": types.StepSucceeded,
+	} {
+		if st := wf.State[id]; st.Status != exp {
+			t.Fatalf("Expected step '%s' %s, got %+v", id, exp, st)
+		}
+	}
+
+	for key, exp := range map[string]int{
+		types.StepKey(StepGeocode, "latency"): 2,
+		types.StepKey(StepGeocode, "success"): 1,
+		types.StepKey(StepGeocode, "error"):   1,
+		types.StepKey(StepReverse, "success"): 2,
+		types.StepKey(StepEnrich, "success"):  1,
+		types.StepKey(StepWebhook, "success"): 1,
+	} {
+		if n := ss.Count(key); n != exp {
+			t.Fatalf("Expected %d for '%s', got %d", exp, key, n)
+		}
+	}
+}
+
+func TestResume(t *testing.T) {
+	q := newMemQueue()
+	bl := &barrierLocator{parallel: 1}
+
+	// The geocode was done, and the reverse was running, when the worker
+	// went away.
+	home, _ := json.Marshal(types.AddressResponse{MatchCount: 1,
+		Coordinates: types.Coords{X: 1, Y: 2}})
+	started := time.Now()
+	wf := &types.Workflow{ID: "resumed", Status: types.JobRunning,
+		Steps: []types.WorkflowStep{
+			{ID: "home", Type: StepGeocode,
+				Address: &types.AddressRequest{Street: "Main St"}},
+			{ID: "geo", Type: StepReverse, DependsOn: []string{"home"}},
+		},
+		State: map[string]*types.StepState{
+			"home": {Status: types.StepSucceeded, Output: home},
+			"geo":  {Status: types.StepRunning, Started: &started},
+		},
+		Created: started}
+	if err := q.Push(wf); err != nil {
+		t.Fatalf("Got unexpected error: %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		NewRunner(q, bl, &storetest.Store{}, Config{Workers: 1}).Run(ctx)
+		close(done)
+	}()
+	wf = waitFor(t, q, wf.ID)
+	cancel()
+	<-done
+
+	if wf.Status != types.JobSucceeded {
+		t.Fatalf("Expected success, got '%s': %s", wf.Status, wf.Error)
+	}
+	if n := atomic.LoadInt32(&bl.lookups); n != 0 {
+		t.Fatalf("Expected the geocode not to run again, got %d lookups", n)
+	}
+	var rr types.ReverseResponse
+	if err := json.Unmarshal(wf.State["geo"].Output, &rr); err != nil {
+		t.Fatalf("Got unexpected error: %v", err)
+	}
+	if rr.Coordinates.X != 1 || rr.Geographies.State != "24" {
+		t.Fatalf("Expected geographies of (1, 2), got %+v", rr)
+	}
+}
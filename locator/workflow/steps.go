@@ -0,0 +1,176 @@
+package workflow
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"time"
+
+	"github.com/gdotgordon/locator-demo/locator/geoerr"
+	"github.com/gdotgordon/locator-demo/locator/types"
+)
+
+// maxWebhookBody is the most of a webhook response that is kept.
+const maxWebhookBody = 64 * 1024
+
+// webhooks calls the webhook steps.  Redirects aren't followed, as they
+// could lead anywhere, not just to the hosts allowed.
+type webhooks struct {
+	client *http.Client
+}
+
+func newWebhooks() *webhooks {
+	return &webhooks{client: &http.Client{
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}}
+}
+
+// runStep runs one step with the outputs of the steps it depends on, and
+// returns its output as JSON.
+func (r *Runner) runStep(ctx context.Context, wfID string,
+	s types.WorkflowStep, inputs map[string][]byte) ([]byte, error) {
+	ctx, cancel := context.WithTimeout(ctx, r.cfg.StepTimeout)
+	defer cancel()
+
+	var out interface{}
+	var err error
+	switch s.Type {
+	case StepGeocode:
+		out, err = r.geocode(ctx, *s.Address)
+	case StepReverse:
+		out, err = r.reverse(ctx, s, inputs)
+	case StepEnrich:
+		out, err = r.enrich(ctx, s, inputs)
+	case StepWebhook:
+		out, err = r.hooks.call(ctx, wfID, s, inputs)
+	default:
+		err = fmt.Errorf("Unknown step type '%s'", s.Type)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(out)
+}
+
+// geocode looks up the address, failing if it isn't found, so that the
+// steps that depend on it are skipped.
+func (r *Runner) geocode(ctx context.Context,
+	req types.AddressRequest) (*types.AddressResponse, error) {
+	resp, err := r.loc.Locate(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.MatchCount == 0 {
+		return nil, geoerr.New(geoerr.NotFound, "Address not located")
+	}
+	return resp, nil
+}
+
+// reverse finds the geographies of the step's coordinates, or those of
+// the first address found by the steps it depends on.
+func (r *Runner) reverse(ctx context.Context, s types.WorkflowStep,
+	inputs map[string][]byte) (*types.ReverseResponse, error) {
+	coords := s.Coordinates
+	if coords == nil {
+		ar, err := located(s, inputs)
+		if err != nil {
+			return nil, err
+		}
+		coords = &ar.Coordinates
+	}
+	return r.loc.Reverse(ctx, *coords)
+}
+
+// enrich looks up the step's address with its geographies, or adds the
+// geographies to the first address found by the steps it depends on.
+func (r *Runner) enrich(ctx context.Context, s types.WorkflowStep,
+	inputs map[string][]byte) (*types.AddressResponse, error) {
+	if s.Address != nil {
+		req := *s.Address
+		req.Enrich = true
+		return r.geocode(ctx, req)
+	}
+	ar, err := located(s, inputs)
+	if err != nil {
+		return nil, err
+	}
+	if ar.Geographies == nil {
+		rr, err := r.loc.Reverse(ctx, ar.Coordinates)
+		if err != nil {
+			return nil, err
+		}
+		ar.Geographies = &rr.Geographies
+	}
+	return ar, nil
+}
+
+// located returns the first address found by the steps the step depends
+// on, in the order they are listed.
+func located(s types.WorkflowStep,
+	inputs map[string][]byte) (*types.AddressResponse, error) {
+	for _, dep := range s.DependsOn {
+		var ar types.AddressResponse
+		if err := json.Unmarshal(inputs[dep], &ar); err != nil {
+			continue
+		}
+		if ar.MatchCount > 0 {
+			return &ar, nil
+		}
+	}
+	return nil, fmt.Errorf("No located address from the steps of '%s'",
+		s.ID)
+}
+
+// webhookRequest is the body posted to a webhook.
+type webhookRequest struct {
+	Workflow string                     `json:"workflow"`
+	Step     string                     `json:"step"`
+	Inputs   map[string]json.RawMessage `json:"inputs"`
+	Sent     time.Time                  `json:"sent"`
+}
+
+// call posts the outputs of the steps the webhook depends on, keyed by
+// step id, to its URL.  Any status but a 2xx fails the step.
+func (wh *webhooks) call(ctx context.Context, wfID string,
+	s types.WorkflowStep, inputs map[string][]byte) (*types.WebhookOutput,
+	error) {
+	body := webhookRequest{Workflow: wfID, Step: s.ID,
+		Inputs: make(map[string]json.RawMessage), Sent: time.Now()}
+	for dep, out := range inputs {
+		body.Inputs[dep] = out
+	}
+	b, err := json.Marshal(body)
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequest(http.MethodPost, s.URL, bytes.NewReader(b))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := wh.client.Do(req.WithContext(ctx))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	rb, err := ioutil.ReadAll(io.LimitReader(resp.Body, maxWebhookBody))
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return nil, fmt.Errorf("Webhook returned HTTP status %d : %s",
+			resp.StatusCode, http.StatusText(resp.StatusCode))
+	}
+	out := types.WebhookOutput{Status: resp.StatusCode}
+	if json.Valid(rb) {
+		out.Body = rb
+	}
+	return &out, nil
+}
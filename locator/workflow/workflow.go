@@ -0,0 +1,173 @@
+// Package workflow runs workflows of geocoding steps.  A workflow is a
+// DAG of steps, each of which runs as soon as the steps it depends on have
+// succeeded, so independent steps run in parallel, and dependent ones in
+// sequence.  The state of the workflows is kept in Redis, and saved after
+// every step, so a workflow whose worker dies is picked up by another
+// worker, and resumed after the steps that were done.
+package workflow
+
+import (
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/gdotgordon/locator-demo/locator/geoerr"
+	"github.com/gdotgordon/locator-demo/locator/types"
+	"github.com/rs/xid"
+)
+
+// Types of step.
+const (
+	// StepGeocode looks up its address.
+	StepGeocode = "geocode"
+
+	// StepReverse finds the Census geographies of its coordinates, or of
+	// the address found by the step it depends on.
+	StepReverse = "reverse"
+
+	// StepEnrich looks up its address with the Census geographies, or
+	// adds them to the address found by the step it depends on.
+	StepEnrich = "enrich"
+
+	// StepWebhook posts the outputs of the steps it depends on to its URL.
+	StepWebhook = "webhook"
+)
+
+// MaxSteps is the most steps allowed in a workflow.
+const MaxSteps = 50
+
+// Submit checks the workflow, and queues it.  Webhook steps may only call
+// the hosts allowed.  An invalid workflow fails with a validation error.
+func Submit(q Queue, req types.WorkflowRequest,
+	hosts []string) (*types.Workflow, error) {
+	if err := Validate(req, hosts); err != nil {
+		return nil, err
+	}
+	wf := &types.Workflow{ID: xid.New().String(), Status: types.JobQueued,
+		Steps: req.Steps, State: make(map[string]*types.StepState),
+		Created: time.Now()}
+	for _, s := range req.Steps {
+		wf.State[s.ID] = &types.StepState{Status: types.StepPending}
+	}
+	if err := q.Push(wf); err != nil {
+		return nil, err
+	}
+	return wf, nil
+}
+
+// Validate checks that the steps have unique ids, known types and the
+// inputs they need, that they only depend on steps in the workflow, and
+// that there are no cycles.
+func Validate(req types.WorkflowRequest, hosts []string) error {
+	if len(req.Steps) == 0 {
+		return geoerr.New(geoerr.Validation, "Workflow has no steps")
+	}
+	if len(req.Steps) > MaxSteps {
+		return geoerr.Errorf(geoerr.Validation,
+			"Workflow exceeds %d steps", MaxSteps)
+	}
+	steps := make(map[string]types.WorkflowStep)
+	for _, s := range req.Steps {
+		if s.ID == "" {
+			return geoerr.New(geoerr.Validation, "Step id is required")
+		}
+		if _, ok := steps[s.ID]; ok {
+			return geoerr.Errorf(geoerr.Validation,
+				"Duplicate step id '%s'", s.ID)
+		}
+		steps[s.ID] = s
+	}
+	for _, s := range req.Steps {
+		for _, dep := range s.DependsOn {
+			if _, ok := steps[dep]; !ok {
+				return geoerr.Errorf(geoerr.Validation,
+					"Step '%s' depends on unknown step '%s'", s.ID, dep)
+			}
+		}
+		if err := validateStep(s, hosts); err != nil {
+			return err
+		}
+	}
+	if id := cycle(req.Steps); id != "" {
+		return geoerr.Errorf(geoerr.Validation,
+			"Dependency cycle involving step '%s'", id)
+	}
+	return nil
+}
+
+// validateStep checks the step has the inputs its type needs.
+func validateStep(s types.WorkflowStep, hosts []string) error {
+	switch s.Type {
+	case StepGeocode:
+		if s.Address == nil {
+			return geoerr.Errorf(geoerr.Validation,
+				"Geocode step '%s' needs an address", s.ID)
+		}
+	case StepReverse:
+		if s.Coordinates == nil && len(s.DependsOn) == 0 {
+			return geoerr.Errorf(geoerr.Validation,
+				"Reverse step '%s' needs coordinates or a step to depend on",
+				s.ID)
+		}
+	case StepEnrich:
+		if s.Address == nil && len(s.DependsOn) == 0 {
+			return geoerr.Errorf(geoerr.Validation,
+				"Enrich step '%s' needs an address or a step to depend on",
+				s.ID)
+		}
+	case StepWebhook:
+		u, err := url.Parse(s.URL)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") ||
+			u.Host == "" {
+			return geoerr.Errorf(geoerr.Validation,
+				"Webhook step '%s' has an invalid URL '%s'", s.ID, s.URL)
+		}
+		if !allowed(u.Hostname(), hosts) {
+			return geoerr.Errorf(geoerr.Validation,
+				"Webhook host '%s' is not allowed", u.Hostname())
+		}
+	default:
+		return geoerr.Errorf(geoerr.Validation,
+			"Unknown type '%s' for step '%s'", s.Type, s.ID)
+	}
+	return nil
+}
+
+// allowed says whether webhooks may call the host.
+func allowed(host string, hosts []string) bool {
+	for _, h := range hosts {
+		if strings.EqualFold(host, h) {
+			return true
+		}
+	}
+	return false
+}
+
+// cycle returns the id of a step caught up in a cycle of dependencies, or
+// "" if there is none.  It repeatedly removes the steps whose dependencies
+// have all been removed, and whatever is left is on, or behind, a cycle.
+func cycle(steps []types.WorkflowStep) string {
+	done := make(map[string]bool)
+	for progress := true; progress; {
+		progress = false
+		for _, s := range steps {
+			if done[s.ID] {
+				continue
+			}
+			ready := true
+			for _, dep := range s.DependsOn {
+				ready = ready && done[dep]
+			}
+			if ready {
+				done[s.ID] = true
+				progress = true
+			}
+		}
+	}
+	for _, s := range steps {
+		if !done[s.ID] {
+			return s.ID
+		}
+	}
+	return ""
+}
@@ -0,0 +1,69 @@
+package workflow
+
+import (
+	"testing"
+
+	"github.com/gdotgordon/locator-demo/locator/geoerr"
+	"github.com/gdotgordon/locator-demo/locator/types"
+)
+
+func TestValidate(t *testing.T) {
+	addr := &types.AddressRequest{StructureNumber: "1", Street: "Main St"}
+	hosts := []string{"hooks.example.com"}
+	for _, test := range []struct {
+		steps []types.WorkflowStep
+		e     string
+	}{
+		{steps: []types.WorkflowStep{
+			{ID: "a", Type: StepGeocode, Address: addr},
+			{ID: "b", Type: StepReverse, DependsOn: []string{"a"}},
+			{ID: "c", Type: StepEnrich, DependsOn: []string{"a"}},
+			{ID: "d", Type: StepWebhook, DependsOn: []string{"b", "c"},
+				URL: "https://HOOKS.example.com/done"},
+		}},
+		{e: "Workflow has no steps"},
+		{steps: []types.WorkflowStep{{Type: StepGeocode, Address: addr}},
+			e: "Step id is required"},
+		{steps: []types.WorkflowStep{
+			{ID: "a", Type: StepGeocode, Address: addr},
+			{ID: "a", Type: StepGeocode, Address: addr},
+		}, e: "Duplicate step id 'a'"},
+		{steps: []types.WorkflowStep{
+			{ID: "a", Type: StepReverse, DependsOn: []string{"x"}},
+		}, e: "Step 'a' depends on unknown step 'x'"},
+		{steps: []types.WorkflowStep{{ID: "a", Type: "teleport"}},
+			e: "Unknown type 'teleport' for step 'a'"},
+		{steps: []types.WorkflowStep{{ID: "a", Type: StepGeocode}},
+			e: "Geocode step 'a' needs an address"},
+		{steps: []types.WorkflowStep{{ID: "a", Type: StepReverse}},
+			e: "Reverse step 'a' needs coordinates or a step to depend on"},
+		{steps: []types.WorkflowStep{{ID: "a", Type: StepEnrich}},
+			e: "Enrich step 'a' needs an address or a step to depend on"},
+		{steps: []types.WorkflowStep{
+			{ID: "a", Type: StepWebhook, URL: "ftp://hooks.example.com/"},
+		}, e: "Webhook step 'a' has an invalid URL 'ftp://hooks.example.com/'"},
+		{steps: []types.WorkflowStep{
+			{ID: "a", Type: StepWebhook, URL: "http://169.254.169.254/"},
+		}, e: "Webhook host '169.254.169.254' is not allowed"},
+		{steps: []types.WorkflowStep{
+			{ID: "a", Type: StepReverse, DependsOn: []string{"c"}},
+			{ID: "b", Type: StepReverse, DependsOn: []string{"a"}},
+			{ID: "c", Type: StepEnrich, DependsOn: []string{"b"}},
+		}, e: "Dependency cycle involving step 'a'"},
+	} {
+		err := Validate(types.WorkflowRequest{Steps: test.steps}, hosts)
+		if test.e == "" {
+			if err != nil {
+				t.Fatalf("Got unexpected error: %v", err)
+			}
+			continue
+		}
+		if err == nil || err.Error() != test.e {
+			t.Fatalf("Expected error '%s', got %v", test.e, err)
+		}
+		if class := geoerr.ClassOf(err); class != geoerr.Validation {
+			t.Fatalf("Expected class '%s', got '%s'", geoerr.Validation,
+				class)
+		}
+	}
+}